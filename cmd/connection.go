@@ -0,0 +1,167 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/spf13/cobra"
+)
+
+var (
+	portName      = ""     // Serial port, discovered automatically when empty
+	baudRate      = 9600   // Default baud rate for serial connections
+	deviceAddress = ""     // Bluetooth device address (empty by default)
+	useBluetooth  = false  // Flag to toggle Bluetooth connection
+	protocolName  = "auto" // Protocol to use, searched automatically by default
+	vehicleID     = ""     // Vehicle identifier used to remember its protocol (empty disables it)
+	useSTN        = false  // Flag to enable OBDLink STN extended commands
+	detectBaud    = false  // Flag to probe common baud rates for the adapter
+	negotiateBaud = 0      // Baud rate to switch the adapter to after connecting (0 keeps the rate)
+	recordFile    = ""     // File to record every command and response to (empty disables recording)
+	replayFile    = ""     // Recording to replay instead of connecting to an adapter
+	replaySpeed   = 0.0    // Replay speed relative to the recording (0 answers immediately)
+
+	replayOrder    = "command"        // How replayed commands are matched to the recording: strict or command
+	commandTimeout = 10 * time.Second // Time to wait for the adapter's answer to a command (0 waits forever)
+)
+
+// addConnectionFlags registers the flags selecting and configuring the OBD2 adapter connection.
+func addConnectionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&portName, "port", "p", "", "Specify the serial port for connection (discovered if omitted)")
+	cmd.Flags().IntVarP(&baudRate, "baud", "b", 9600, "Specify the baud rate for serial connection")
+	cmd.Flags().StringVarP(&deviceAddress, "address", "a", "", "Specify the Bluetooth device address")
+	cmd.Flags().BoolVarP(&useBluetooth, "bluetooth", "l", false, "Use Bluetooth for connection instead of serial")
+	cmd.Flags().StringVar(&protocolName, "protocol", "auto", "Specify the OBD2 protocol, e.g. can-11bit-500k or 6")
+	cmd.Flags().StringVar(&vehicleID, "vehicle", "", "Remember the detected protocol under this vehicle name")
+	cmd.Flags().BoolVar(&useSTN, "stn", false, "Use OBDLink STN extended commands when the adapter supports them")
+	cmd.Flags().BoolVar(&detectBaud, "detect-baud", false, "Probe common baud rates to find the adapter's current rate")
+	cmd.Flags().IntVar(&negotiateBaud, "negotiate-baud", 0, "Switch the adapter to this baud rate after connecting")
+	cmd.Flags().DurationVar(&commandTimeout, "timeout", 10*time.Second, "Time to wait for the adapter's answer to a command (0 waits forever)")
+	cmd.Flags().StringVar(&recordFile, "record", "", "Record every command and response to this file")
+	cmd.Flags().StringVar(&replayFile, "replay", "", "Replay a recording instead of connecting to an adapter")
+	cmd.Flags().Float64Var(&replaySpeed, "replay-speed", 0, "Replay speed relative to the recording, e.g. 1 for real time (0 answers immediately)")
+	cmd.Flags().StringVar(&replayOrder, "replay-order", "command", "Match replayed commands to the recording: strict (recorded order) or command")
+}
+
+// connect creates the connector selected by the connection flags and connects it, exiting on failure.
+func connect() gobd2.Connector {
+	var connector gobd2.Connector
+
+	if replayFile != "" {
+		exchanges, err := gobd2.LoadRecording(replayFile)
+		if err != nil {
+			log.Fatalf("Failed to load recording: %v", err)
+		}
+
+		return gobd2.NewReplayConnector(exchanges, gobd2.WithReplayOrder(parseReplayOrder()), gobd2.WithReplaySpeed(replaySpeed))
+	}
+
+	if useBluetooth {
+		if deviceAddress == "" {
+			log.Fatal("Bluetooth device address must be provided when using Bluetooth.")
+		}
+
+		if detectBaud || negotiateBaud > 0 {
+			log.Fatal("--detect-baud and --negotiate-baud apply to serial adapters only, not to Bluetooth.")
+		}
+		connector = gobd2.NewBluetoothConnector(deviceAddress, gobd2.WithSerialOptions(serialOptions()...))
+	} else {
+		if portName == "" {
+			portName, baudRate = discoverPort()
+		}
+		connector = gobd2.NewSerialConnector(portName, baudRate, &gobd2.RealPortOpener{}, serialOptions()...)
+	}
+
+	if err := connector.Connect(); err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+
+	if recordFile != "" {
+		recorder, err := gobd2.CreateRecording(connector, recordFile)
+		if err != nil {
+			log.Fatalf("Failed to create recording: %v", err)
+		}
+
+		return recorder
+	}
+
+	return connector
+}
+
+// parseReplayOrder translates the replay order flag, exiting if it is invalid.
+func parseReplayOrder() gobd2.ReplayOrder {
+	switch replayOrder {
+	case "strict":
+		return gobd2.ReplayStrict
+	case "command":
+		return gobd2.ReplayByCommand
+	}
+
+	log.Fatalf("Invalid replay order %q, use strict or command", replayOrder)
+
+	return 0
+}
+
+// serialOptions translates the protocol flags into serial connector options.
+func serialOptions() []gobd2.SerialConnectorOption {
+	var opts []gobd2.SerialConnectorOption
+
+	if commandTimeout > 0 {
+		opts = append(opts, gobd2.WithTimeout(commandTimeout))
+	}
+
+	protocol, err := gobd2.ParseProtocol(protocolName)
+	if err != nil {
+		log.Fatalf("Invalid protocol: %v", err)
+	}
+
+	if protocol != gobd2.ProtocolAuto {
+		opts = append(opts, gobd2.WithProtocol(protocol))
+	}
+
+	if useSTN {
+		opts = append(opts, gobd2.WithSTNExtensions())
+	}
+
+	if detectBaud {
+		opts = append(opts, gobd2.WithBaudDetection())
+	}
+
+	if negotiateBaud > 0 {
+		opts = append(opts, gobd2.WithBaudNegotiation(negotiateBaud))
+	}
+
+	if vehicleID != "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			log.Fatalf("Failed to locate cache directory: %v", err)
+		}
+
+		store := gobd2.NewFileProtocolStore(filepath.Join(cacheDir, "gobd2", "protocols.json"))
+		opts = append(opts, gobd2.WithProtocolStore(store, vehicleID))
+	}
+
+	return opts
+}
+
+// discoverPort finds the serial adapter to use when no port was given, exiting if none answers.
+func discoverPort() (string, int) {
+	discovery := gobd2.NewSerialDiscovery(&gobd2.RealPortOpener{})
+	discovery.BaudRates = append([]int{baudRate}, discovery.BaudRates...)
+
+	candidates, err := discovery.Discover()
+	if err != nil {
+		log.Fatalf("Failed to discover serial adapters, specify --port: %v", err)
+	}
+
+	if len(candidates) == 0 || candidates[0].Identification == "" {
+		log.Fatal("No serial OBD2 adapter found, specify --port.")
+	}
+
+	log.Printf("Using %s adapter on %s at %d baud", candidates[0].Identification, candidates[0].Path, candidates[0].BaudRate)
+
+	return candidates[0].Path, candidates[0].BaudRate
+}
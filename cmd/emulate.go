@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	emulateAddress = ""      // TCP address to serve the emulated adapter on (empty disables it)
+	emulatePTY     = false   // Flag to serve the emulated adapter on a pseudo terminal
+	emulateCycle   = "urban" // Drive cycle of the virtual vehicle
+	emulateFaults  []string  // Scripted faults, e.g. "misfire@2m"
+	emulateReplay  = ""      // Snapshot file to replay instead of simulating a vehicle
+)
+
+// emulateCmd runs an emulated ELM327 adapter connected to a virtual vehicle.
+var emulateCmd = &cobra.Command{
+	Use:   "emulate",
+	Short: "Emulates an ELM327 adapter connected to a virtual vehicle.",
+	Long: `This command runs an emulated ELM327 adapter connected to a virtual vehicle, so that gobd2 and
+third-party diagnostic apps can be used without a car. The vehicle follows a drive cycle and can be
+scripted to develop faults that raise trouble codes. Alternatively, the adapter replays a snapshot
+captured from a real vehicle with "gobd2 snapshot". The adapter is served over TCP, like a WiFi
+adapter, and/or on a pseudo terminal that apps open like a serial port (Linux only).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if emulateAddress == "" && !emulatePTY {
+			log.Fatal("Either --tcp or --pty must be given.")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		vehicle, err := emulatedVehicle()
+		if err != nil {
+			log.Fatalf("Invalid vehicle: %v", err)
+		}
+
+		if emulatePTY {
+			pty, err := emulator.OpenPTY(vehicle)
+			if err != nil {
+				log.Fatalf("Failed to open pseudo terminal: %v", err)
+			}
+			defer pty.Close()
+
+			log.Printf("Emulated adapter available on %s", pty.Path())
+		}
+
+		if emulateAddress != "" {
+			go func() {
+				log.Printf("Emulated adapter listening on %s", emulateAddress)
+
+				if err := emulator.ListenAndServe(emulateAddress, vehicle); err != nil {
+					log.Fatalf("Failed to serve emulated adapter: %v", err)
+				}
+			}()
+		}
+
+		<-ctx.Done()
+	},
+}
+
+// emulatedVehicle creates the vehicle selected by the emulate flags: a replayed snapshot or a simulated vehicle.
+func emulatedVehicle() (emulator.Vehicle, error) {
+	if emulateReplay != "" {
+		return emulator.LoadSnapshotVehicle(emulateReplay)
+	}
+
+	return simulatedVehicle()
+}
+
+// simulatedVehicle creates the virtual vehicle selected by the emulate flags.
+func simulatedVehicle() (*emulator.SimulatedVehicle, error) {
+	cycle, err := emulator.ParseDriveCycle(emulateCycle)
+	if err != nil {
+		return nil, err
+	}
+
+	faults := make([]emulator.Fault, 0, len(emulateFaults))
+
+	for _, spec := range emulateFaults {
+		fault, err := parseFault(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		faults = append(faults, fault)
+	}
+
+	return emulator.NewSimulatedVehicle(cycle, emulator.WithFaults(faults...)), nil
+}
+
+// parseFault parses a scripted fault given as kind@delay, e.g. "misfire@2m" or "lean@30s".
+func parseFault(spec string) (emulator.Fault, error) {
+	kind, delay, _ := strings.Cut(spec, "@")
+
+	at, err := time.ParseDuration(delay)
+	if err != nil {
+		return emulator.Fault{}, fmt.Errorf("fault %q: %w", spec, err)
+	}
+
+	switch kind {
+	case "misfire":
+		return emulator.MisfireFault(at, 1), nil
+	case "lean":
+		return emulator.LeanFault(at), nil
+	case "thermostat":
+		return emulator.ThermostatFault(at), nil
+	}
+
+	return emulator.Fault{}, fmt.Errorf("fault %q: unknown kind %q, use misfire, lean or thermostat", spec, kind)
+}
+
+// registerEmulateCommand adds the emulate command to the root command and sets up command line flags.
+func registerEmulateCommand(rootCmd *cobra.Command) {
+	emulateCmd.Flags().StringVar(&emulateAddress, "tcp", "", "Serve the emulated adapter on this TCP address, e.g. :35000")
+	emulateCmd.Flags().BoolVar(&emulatePTY, "pty", false, "Serve the emulated adapter on a pseudo terminal")
+	emulateCmd.Flags().StringVar(&emulateCycle, "cycle", "urban", "Drive cycle of the virtual vehicle: idle, urban or highway")
+	emulateCmd.Flags().StringArrayVar(&emulateFaults, "fault", nil, "Script a fault as kind@delay, e.g. misfire@2m (misfire, lean, thermostat)")
+	emulateCmd.Flags().StringVar(&emulateReplay, "snapshot", "", "Replay a snapshot file captured with the snapshot command")
+
+	rootCmd.AddCommand(emulateCmd)
+}
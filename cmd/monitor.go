@@ -15,12 +15,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var (
-	portName      = "/dev/ttyUSB0" // Default serial port
-	baudRate      = 9600           // Default baud rate for serial connections
-	deviceAddress = ""             // Bluetooth device address (empty by default)
-	useBluetooth  = false          // Flag to toggle Bluetooth connection
-)
+// monitoredPIDs are the names of the PIDs the monitor shows.
+var monitoredPIDs = []string{"Engine RPM", "Vehicle speed", "Throttle position", "Coolant temperature"}
 
 // monitorCmd defines the command line structure and handling for the monitoring tool.
 var monitorCmd = &cobra.Command{
@@ -28,26 +24,23 @@ var monitorCmd = &cobra.Command{
 	Short: "Monitors vehicle diagnostics using OBD2 interfaces.",
 	Long: `This command supports real-time monitoring of various vehicle diagnostics parameters
 from an OBD2 interface via serial or Bluetooth connection. It displays data dynamically in
-a full-screen terminal interface powered by termui.`,
+a full-screen terminal interface powered by termui.
+
+Any registered PID can be shown, including manufacturer DIDs loaded from definition files and
+virtual sensors computed from other PIDs, which are read along.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var connector gobd2.Connector
+		registry := pidRegistry()
+		pids := lookupPIDs(registry, monitoredPIDs)
 
-		if useBluetooth {
-			if deviceAddress == "" {
-				log.Fatal("Bluetooth device address must be provided when using Bluetooth.")
-			}
-			connector = gobd2.NewBluetoothConnector(deviceAddress)
-		} else {
-			connector = gobd2.NewSerialConnector(portName, baudRate, &gobd2.RealPortOpener{})
-		}
+		connector := connect()
 		defer connector.Close()
 
-		if err := connector.Connect(); err != nil {
-			log.Fatalf("Failed to connect: %v", err)
+		sampler, err := gobd2.NewSampler(gobd2.NewCommander(connector), registry, pids...)
+		if err != nil {
+			log.Fatalf("Invalid PID: %v", err)
 		}
 
-		commander := gobd2.NewCommander(connector)
-		runMonitor(commander)
+		runMonitor(sampler)
 	},
 }
 
@@ -101,7 +94,7 @@ func setupDynamicGrid(widgetsList []*widgets.Paragraph) *termui.Grid {
 }
 
 // runMonitor initializes the UI and starts the monitoring process.
-func runMonitor(commander *gobd2.Commander) {
+func runMonitor(sampler *gobd2.Sampler) {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
@@ -110,26 +103,19 @@ func runMonitor(commander *gobd2.Commander) {
 	}
 	defer termui.Close()
 
-	pids := []gobd2.CommandCode{
-		gobd2.EngineRPMCommand,
-		gobd2.VehicleSpeedCommand,
-		gobd2.ThrottlePositionCommand,
-		gobd2.CoolantTemperatureCommand,
-	}
-	widgetsList := createWidgets(len(pids))
+	widgetsList := createWidgets(len(sampler.PIDs()))
 	grid := setupDynamicGrid(widgetsList)
 
 	termui.Render(grid) // Render the grid
 
-	for i, widget := range widgetsList {
-		go startMonitoring(ctx, widget, commander, pids[i]) // Update each widget at random intervals between 1-5 seconds
-	}
+	go startMonitoring(ctx, widgetsList, sampler)
 
 	handleUIEvents(ctx)
 }
 
-// startMonitoring begins the data monitoring process for each PID using goroutines.
-func startMonitoring(ctx context.Context, p *widgets.Paragraph, commander *gobd2.Commander, pid gobd2.CommandCode) {
+// startMonitoring samples the PIDs every 2 seconds and shows each one in its widget. PIDs are read sequentially
+// because reading a PID may change the adapter's addressing.
+func startMonitoring(ctx context.Context, widgetsList []*widgets.Paragraph, sampler *gobd2.Sampler) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
@@ -138,16 +124,18 @@ func startMonitoring(ctx context.Context, p *widgets.Paragraph, commander *gobd2
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			p.Title = string(pid)
+			for i, sample := range sampler.Sample() {
+				p := widgetsList[i]
+				p.Title = sample.PID.Name
+
+				if sample.Err != nil {
+					p.Text = "Error: " + sample.Err.Error()
+				} else {
+					p.Text = sample.Reading.String()
+				}
 
-			data, err := commander.ExecuteCommand(pid)
-			if err != nil {
-				p.Text = "Error: " + err.Error()
-			} else {
-				p.Text = "Data: " + data
+				termui.Render(p)
 			}
-
-			termui.Render(p)
 		}
 	}
 }
@@ -173,10 +161,9 @@ func handleUIEvents(ctx context.Context) {
 
 // registerMonitorCommand adds the monitor command to the root command and sets up command line flags.
 func registerMonitorCommand(rootCmd *cobra.Command) {
-	monitorCmd.Flags().StringVarP(&portName, "port", "p", "/dev/ttyUSB0", "Specify the serial port for connection")
-	monitorCmd.Flags().IntVarP(&baudRate, "baud", "b", 9600, "Specify the baud rate for serial connection")
-	monitorCmd.Flags().StringVarP(&deviceAddress, "address", "a", "", "Specify the Bluetooth device address")
-	monitorCmd.Flags().BoolVarP(&useBluetooth, "bluetooth", "l", false, "Use Bluetooth for connection instead of serial")
+	addConnectionFlags(monitorCmd)
+	addPIDFlags(monitorCmd)
+	monitorCmd.Flags().StringSliceVar(&monitoredPIDs, "pid", monitoredPIDs, "Names of the PIDs to show")
 
 	rootCmd.AddCommand(monitorCmd)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/spf13/cobra"
+)
+
+var (
+	definitionFiles []string // DID definition files loaded into the PID registry
+	torqueFiles     []string // Torque Pro custom PID lists loaded into the PID registry
+	sensorFiles     []string // Virtual sensor definitions loaded into the PID registry
+)
+
+// addPIDFlags adds the flags loading custom PIDs to a command reading PIDs.
+func addPIDFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&definitionFiles, "definitions", nil, "Load manufacturer DIDs from these definition files")
+	cmd.Flags().StringSliceVar(&torqueFiles, "torque", nil, "Load custom PIDs from these Torque Pro CSV files")
+	cmd.Flags().StringSliceVar(&sensorFiles, "sensors", nil, "Load virtual sensors from these JSON files")
+}
+
+// pidRegistry returns a registry holding the standard PIDs and virtual sensors and the ones loaded from the given
+// files, exiting if a file cannot be loaded. Virtual sensors are loaded last so they can use the PIDs of any file.
+func pidRegistry() *gobd2.PIDRegistry {
+	registry := gobd2.NewPIDRegistry()
+
+	for _, path := range definitionFiles {
+		pids, err := gobd2.LoadDIDDefinitions(path)
+		if err != nil {
+			log.Fatalf("Failed to load DID definitions: %v", err)
+		}
+
+		if err := registry.Register(pids...); err != nil {
+			log.Fatalf("Failed to register DIDs from %s: %v", path, err)
+		}
+	}
+
+	for _, path := range torqueFiles {
+		pids, err := gobd2.LoadTorqueCSV(path)
+		if err != nil {
+			log.Fatalf("Failed to load Torque PIDs: %v", err)
+		}
+
+		if err := registry.Register(pids...); err != nil {
+			log.Fatalf("Failed to register PIDs from %s: %v", path, err)
+		}
+	}
+
+	for _, path := range sensorFiles {
+		sensors, err := gobd2.LoadVirtualSensors(path)
+		if err != nil {
+			log.Fatalf("Failed to load virtual sensors: %v", err)
+		}
+
+		if err := registry.Register(sensors...); err != nil {
+			log.Fatalf("Failed to register virtual sensors from %s: %v", path, err)
+		}
+	}
+
+	return registry
+}
+
+// lookupPIDs returns the registered PIDs with the given names, exiting if one is unknown.
+func lookupPIDs(registry *gobd2.PIDRegistry, names []string) []gobd2.PID {
+	pids := make([]gobd2.PID, 0, len(names))
+
+	for _, name := range names {
+		pid, err := registry.Lookup(name)
+		if err != nil {
+			log.Fatalf("Invalid PID: %v", err)
+		}
+
+		pids = append(pids, pid)
+	}
+
+	return pids
+}
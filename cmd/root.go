@@ -14,6 +14,31 @@ Example usage:
   - Connect to a Bluetooth OBD2 device:
     ./gobd2 monitor --bluetooth --address "00:1D:A5:68:98:8B"
 
+  - List the ECUs on the vehicle network as JSON:
+    ./gobd2 scan ecus --port /dev/ttyUSB0 --output json
+
+  - Emulate an adapter on a pseudo terminal and connect to it:
+    ./gobd2 emulate --pty
+    ./gobd2 scan ecus --port /dev/pts/3
+
+  - Clone a vehicle into a snapshot and replay it in the emulator:
+    ./gobd2 snapshot --port /dev/ttyUSB0 --output car.json
+    ./gobd2 emulate --pty --snapshot car.json
+
+  - Monitor manufacturer DIDs next to standard PIDs:
+    ./gobd2 monitor --port /dev/ttyUSB0 --definitions dids.json --pid "Engine RPM" --pid "Oil life"
+
+  - Monitor custom PIDs from a Torque Pro list:
+    ./gobd2 monitor --port /dev/ttyUSB0 --torque pids.csv --pid "Transmission Temperature"
+
+  - Monitor virtual sensors computed from other PIDs:
+    ./gobd2 monitor --port /dev/ttyUSB0 --pid "Boost pressure" --pid "Fuel economy"
+    ./gobd2 monitor --port /dev/ttyUSB0 --sensors sensors.json --pid "Engine power estimate"
+
+  - Record a drive and monitor it again later without the car:
+    ./gobd2 monitor --port /dev/ttyUSB0 --record drive.jsonl
+    ./gobd2 monitor --replay drive.jsonl --replay-speed 1
+
 For more information and updates, visit https://github.com/janekbaraniewski/gobd2.
 */
 package main
@@ -35,6 +60,9 @@ The tool supports a variety of commands to facilitate real-time monitoring and d
 
 func main() {
 	registerMonitorCommand(rootCmd)
+	registerScanCommand(rootCmd)
+	registerEmulateCommand(rootCmd)
+	registerSnapshotCommand(rootCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/spf13/cobra"
+)
+
+var outputFormat = "table" // Output format of scan results
+
+// scanCmd groups the commands that probe the vehicle.
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Probes the vehicle and reports what it finds.",
+}
+
+// scanECUsCmd reports every ECU answering on the vehicle network.
+var scanECUsCmd = &cobra.Command{
+	Use:   "ecus",
+	Short: "Lists the ECUs answering on the vehicle network.",
+	Long: `This command probes the vehicle using functional addressing and lists every ECU that answers,
+together with its address, name, supported PIDs and the protocol in use.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		connector := connect()
+		defer connector.Close()
+
+		topology, err := gobd2.NewCommander(connector).DiscoverTopology()
+		if err != nil {
+			log.Fatalf("Failed to discover ECUs: %v", err)
+		}
+
+		if err := printTopology(os.Stdout, topology, outputFormat); err != nil {
+			log.Fatalf("Failed to print ECUs: %v", err)
+		}
+	},
+}
+
+// printTopology writes the topology to w as a table or as JSON.
+func printTopology(w io.Writer, topology *gobd2.VehicleTopology, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(topology)
+	case "table":
+		fmt.Fprintf(w, "Protocol: %s\n\n", topology.Protocol)
+
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "ADDRESS\tNAME\tPIDS\tINFO TYPES")
+
+		for _, ecu := range topology.ECUs {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", ecu.Address, ecu.Name, joinCodes(ecu.SupportedPIDs), joinCodes(ecu.SupportedInfoTypes))
+		}
+
+		return table.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+func joinCodes(codes []gobd2.CommandCode) string {
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = string(code)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// registerScanCommand adds the scan command and its subcommands to the root command.
+func registerScanCommand(rootCmd *cobra.Command) {
+	addConnectionFlags(scanECUsCmd)
+	scanECUsCmd.Flags().StringVarP(&outputFormat, "output", "o", "table", "Output format: table or json")
+
+	scanCmd.AddCommand(scanECUsCmd)
+	rootCmd.AddCommand(scanCmd)
+}
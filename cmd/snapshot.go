@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/spf13/cobra"
+)
+
+var snapshotFile = "snapshot.json" // File the snapshot is written to
+
+// snapshotCmd clones a vehicle into a snapshot file the emulator can replay.
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Captures everything the vehicle reports into a snapshot file.",
+	Long: `This command queries every PID, freeze frame, vehicle information record, trouble code and
+on-board test result the vehicle's ECUs support and writes their answers, together with the
+protocol in use, to a JSON file. The emulator can replay the file with "gobd2 emulate --snapshot",
+answering exactly like the vehicle did.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		connector := connect()
+		defer connector.Close()
+
+		snapshot, err := gobd2.NewCommander(connector).CaptureSnapshot()
+		if err != nil {
+			log.Fatalf("Failed to capture snapshot: %v", err)
+		}
+
+		if err := snapshot.Save(snapshotFile); err != nil {
+			log.Fatalf("Failed to save snapshot: %v", err)
+		}
+
+		log.Printf("Captured %d ECUs on %s into %s", len(snapshot.ECUs), snapshot.Protocol.Description(), snapshotFile)
+	},
+}
+
+// registerSnapshotCommand adds the snapshot command to the root command and sets up command line flags.
+func registerSnapshotCommand(rootCmd *cobra.Command) {
+	addConnectionFlags(snapshotCmd)
+	snapshotCmd.Flags().StringVarP(&snapshotFile, "output", "o", "snapshot.json", "File to write the snapshot to")
+
+	rootCmd.AddCommand(snapshotCmd)
+}
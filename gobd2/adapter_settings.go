@@ -0,0 +1,81 @@
+package gobd2
+
+import (
+	"strings"
+)
+
+// SettingsTracker is implemented by connectors that remember the settings sent to their adapter. Library features
+// that change a setting temporarily, e.g. enabling headers during discovery, consult it to restore the setting
+// afterwards; with other connectors they restore the adapter's default.
+type SettingsTracker interface {
+	// AdapterSetting returns the last command that changed a setting, named by the command without its value, e.g.
+	// "ATH1" for "ATH". It reports false if the setting was not changed since the adapter was last reset.
+	AdapterSetting(name string) (CommandCode, bool)
+}
+
+// Settings tracked by adapterSettings, named by their command without the value.
+const (
+	settingHeaders        = "ATH"
+	settingHeader         = "ATSH"
+	settingPriority       = "ATCP"
+	settingReceiveFilter  = "ATCRA"
+	settingAdaptiveTiming = "ATAT"
+	settingTimeout        = "ATST"
+)
+
+// adapterSettings holds the last command that changed each tracked setting of an adapter.
+type adapterSettings map[string]CommandCode
+
+// record remembers command if it changed a tracked setting, given the adapter's answer. Resetting the adapter
+// returns every setting to its default.
+func (s adapterSettings) record(command CommandCode, response string) {
+	compact := CommandCode(strings.ToUpper(strings.ReplaceAll(string(command), " ", "")))
+
+	switch compact {
+	case "ATZ", "ATWS", "ATD":
+		clear(s)
+
+		return
+	}
+
+	lines := responseLines(response)
+	if len(lines) == 0 || lines[len(lines)-1] != "OK" {
+		return
+	}
+
+	if name := settingName(compact); name != "" {
+		s[name] = compact
+	}
+}
+
+// settingName returns the name of the tracked setting a command changes, or an empty string.
+func settingName(command CommandCode) string {
+	switch {
+	case command == "ATH0" || command == "ATH1":
+		return settingHeaders
+	case strings.HasPrefix(string(command), settingReceiveFilter):
+		return settingReceiveFilter
+	case strings.HasPrefix(string(command), settingPriority):
+		return settingPriority
+	case strings.HasPrefix(string(command), settingHeader):
+		return settingHeader
+	case strings.HasPrefix(string(command), settingAdaptiveTiming):
+		return settingAdaptiveTiming
+	case strings.HasPrefix(string(command), settingTimeout):
+		return settingTimeout
+	}
+
+	return ""
+}
+
+// restoreSetting returns the command setting name back to its current value on the adapter behind connector. It
+// returns fallback, the adapter's default, if the setting is at its default or the connector does not track it.
+func restoreSetting(connector Connector, name string, fallback CommandCode) CommandCode {
+	if tracker, ok := connector.(SettingsTracker); ok {
+		if command, ok := tracker.AdapterSetting(name); ok {
+			return command
+		}
+	}
+
+	return fallback
+}
@@ -0,0 +1,190 @@
+package gobd2
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// baudRateClock is the clock ATBRD divides to derive the baud rate.
+	baudRateClock = 4000000
+	// handshakeTimeout bounds each step of baud rate detection and negotiation.
+	handshakeTimeout = 500 * time.Millisecond
+)
+
+// commonBaudRates lists the baud rates probed when detecting the adapter's current rate, most common first.
+var commonBaudRates = []int{38400, 9600, 115200, 230400, 57600, 500000, 1000000, 2000000}
+
+// ErrAdapterNotFound is returned when no adapter answers at any of the probed baud rates.
+var ErrAdapterNotFound = errors.New("adapter not found")
+
+// WithBaudDetection probes the given baud rates, or common adapter baud rates if none are given, to find the rate
+// the adapter currently uses. The configured baud rate is always tried first.
+func WithBaudDetection(rates ...int) SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		if len(rates) == 0 {
+			rates = commonBaudRates
+		}
+
+		sc.baudRates = rates
+	}
+}
+
+// WithBaudNegotiation switches the adapter to a higher baud rate after initialization, using STBR on STN adapters
+// and ATBRD on others. If the adapter does not support the switch or the handshake fails, the connector keeps
+// using the current rate. Resetting the adapter (ATZ) later reverts it to its default rate.
+func WithBaudNegotiation(baud int) SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.targetBaud = baud
+	}
+}
+
+// BaudRate returns the baud rate the connector currently talks to the adapter at.
+func (sc *SerialConnector) BaudRate() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.config.Baud
+}
+
+// detectBaudRate opens the port at each candidate rate until the adapter answers, and returns its identification.
+func (sc *SerialConnector) detectBaudRate() (string, error) {
+	rates := append([]int{sc.config.Baud}, sc.baudRates...)
+	tried := make([]int, 0, len(rates))
+
+	for _, baud := range rates {
+		if slices.Contains(tried, baud) {
+			continue
+		}
+
+		tried = append(tried, baud)
+
+		if err := sc.open(baud); err != nil {
+			return "", err
+		}
+
+		if id, ok := sc.probeAdapter(); ok {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w on %s at baud rates %v", ErrAdapterNotFound, sc.config.Name, tried)
+}
+
+// probeAdapter checks whether an adapter answers at the current baud rate and returns its identification.
+func (sc *SerialConnector) probeAdapter() (string, bool) {
+	// Terminate whatever garbage the adapter received before, then ask for its identification.
+	if err := sc.write(""); err != nil {
+		return "", false
+	}
+
+	sc.readUntil('>', handshakeTimeout) //nolint:errcheck
+
+	if err := sc.write("ATI"); err != nil {
+		return "", false
+	}
+
+	response, err := sc.readUntil('>', handshakeTimeout)
+	if err != nil || !identifiesAdapter(response) {
+		return "", false
+	}
+
+	for _, line := range responseLines(strings.Trim(response, ">")) {
+		if identifiesAdapter(line) {
+			return line, true
+		}
+	}
+
+	return "", false
+}
+
+// negotiateBaudRate switches the adapter and the port to the target baud rate, falling back to the current rate
+// when the adapter does not complete the handshake.
+func (sc *SerialConnector) negotiateBaudRate() error {
+	if sc.targetBaud == 0 || sc.targetBaud == sc.config.Baud {
+		return nil
+	}
+
+	caps := sc.capabilities()
+	if sc.adapter != nil && !caps.Has(CapabilityBaudRateDivisor) && !caps.Has(CapabilitySTN) {
+		return nil
+	}
+
+	command := CommandCode(fmt.Sprintf("STBR%d", sc.targetBaud))
+	if !sc.stn {
+		divisor := (baudRateClock + sc.targetBaud/2) / sc.targetBaud
+		if divisor < 8 || divisor > 0xFF {
+			return fmt.Errorf("baud rate %d cannot be set with ATBRD", sc.targetBaud)
+		}
+
+		command = CommandCode(fmt.Sprintf("ATBRD%02X", divisor))
+	}
+
+	previousBaud := sc.config.Baud
+
+	if err := sc.write(command); err != nil {
+		return err
+	}
+
+	// The adapter confirms with "OK" at the old rate, without a prompt, before switching.
+	response, err := sc.readUntil('\r', handshakeTimeout)
+	for err == nil && strings.TrimSpace(response) == "" {
+		response, err = sc.readUntil('\r', handshakeTimeout)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(response) != "OK" {
+		_, err := sc.readUntil('>', handshakeTimeout)
+
+		return err
+	}
+
+	if sc.completeBaudHandshake() {
+		return nil
+	}
+
+	// The adapter reverts to the previous rate when the handshake is not completed in time.
+	if err := sc.open(previousBaud); err != nil {
+		return err
+	}
+
+	if _, ok := sc.probeAdapter(); !ok {
+		return fmt.Errorf("%w after failed baud rate switch to %d", ErrAdapterNotFound, sc.targetBaud)
+	}
+
+	return nil
+}
+
+// completeBaudHandshake reopens the port at the target rate, waits for the adapter's identification and confirms
+// the new rate with a carriage return.
+func (sc *SerialConnector) completeBaudHandshake() bool {
+	if err := sc.open(sc.targetBaud); err != nil {
+		return false
+	}
+
+	id, err := sc.readUntil('\r', handshakeTimeout)
+	if err != nil || !identifiesAdapter(id) {
+		return false
+	}
+
+	if err := sc.write(""); err != nil {
+		return false
+	}
+
+	_, err = sc.readUntil('>', handshakeTimeout)
+
+	return err == nil
+}
+
+// identifiesAdapter reports whether a response contains an ELM327 or STN identification.
+func identifiesAdapter(response string) bool {
+	response = strings.ToUpper(response)
+
+	return strings.Contains(response, "ELM") || strings.Contains(response, "STN")
+}
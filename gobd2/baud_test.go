@@ -0,0 +1,193 @@
+package gobd2_test
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/require"
+	"github.com/tarm/serial"
+)
+
+// fakeBaudAdapter simulates an adapter that only understands the host at its current baud rate and supports
+// switching rates with ATBRD.
+type fakeBaudAdapter struct {
+	mu          sync.Mutex
+	baud        int
+	supportsBRD bool
+	confirmBRD  bool // whether the adapter announces itself at the new rate
+	resetBaud   int  // rate the adapter restarts at when the next 0100 request resets it, zero for no reset
+	switchingTo int
+	opened      []int
+	currentPort *fakeBaudPort
+}
+
+func (a *fakeBaudAdapter) OpenPort(config *serial.Config) (gobd2.SerialPort, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.opened = append(a.opened, config.Baud)
+	port := &fakeBaudPort{adapter: a, baud: config.Baud}
+
+	if a.switchingTo != 0 {
+		if a.switchingTo == config.Baud && a.confirmBRD {
+			a.baud = a.switchingTo
+			port.out.WriteString("ELM327 v2.1\r")
+		}
+
+		a.switchingTo = 0
+	}
+
+	return port, nil
+}
+
+type fakeBaudPort struct {
+	adapter *fakeBaudAdapter
+	baud    int
+	pending string
+	out     bytes.Buffer
+}
+
+func (p *fakeBaudPort) Read(b []byte) (int, error) {
+	p.adapter.mu.Lock()
+	defer p.adapter.mu.Unlock()
+
+	return p.out.Read(b)
+}
+
+func (p *fakeBaudPort) Write(b []byte) (int, error) {
+	p.adapter.mu.Lock()
+	defer p.adapter.mu.Unlock()
+
+	if p.baud != p.adapter.baud {
+		p.out.WriteString("\xfe\x00\xf0") // garbage, the rates do not match
+
+		return len(b), nil
+	}
+
+	p.pending += string(b)
+
+	for {
+		command, rest, ok := strings.Cut(p.pending, "\r")
+		if !ok {
+			break
+		}
+
+		p.pending = rest
+
+		switch {
+		case command == "":
+			p.out.WriteString("\r>")
+		case command == "0100" && p.adapter.resetBaud != 0:
+			p.adapter.baud, p.adapter.resetBaud = p.adapter.resetBaud, 0
+			p.out.WriteString("\rLV RESET\r\rELM327 v2.1\r\r>")
+		case command == "ATI" || command == "ATZ":
+			p.out.WriteString("ELM327 v2.1\r\r>")
+		case strings.HasPrefix(command, "ATBRD") && p.adapter.supportsBRD:
+			divisor, _ := strconv.ParseInt(command[5:], 16, 32)
+			p.adapter.switchingTo = 4000000 / int(divisor)
+			p.out.WriteString("OK\r")
+		case strings.HasPrefix(command, "AT") && !strings.HasPrefix(command, "ATBRD"):
+			p.out.WriteString("OK\r\r>")
+		default:
+			p.out.WriteString("?\r\r>")
+		}
+	}
+
+	return len(b), nil
+}
+
+func (p *fakeBaudPort) Close() error {
+	return nil
+}
+
+func fastProfile() gobd2.SerialConnectorOption {
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	return gobd2.WithInitProfile(profile)
+}
+
+func TestSerialConnector_BaudDetection(t *testing.T) {
+	t.Parallel()
+
+	adapter := &fakeBaudAdapter{baud: 38400}
+	connector := gobd2.NewSerialConnector("/dev/ttyUSB0", 9600, adapter, fastProfile(),
+		gobd2.WithBaudDetection(), gobd2.WithTimeout(time.Second))
+
+	require.NoError(t, connector.Connect())
+	require.Equal(t, 38400, connector.BaudRate())
+	require.Equal(t, []int{9600, 38400}, adapter.opened)
+}
+
+func TestSerialConnector_BaudDetectionNotFound(t *testing.T) {
+	t.Parallel()
+
+	adapter := &fakeBaudAdapter{baud: 19200}
+	connector := gobd2.NewSerialConnector("/dev/ttyUSB0", 9600, adapter, gobd2.WithBaudDetection(9600, 38400))
+
+	require.ErrorIs(t, connector.Connect(), gobd2.ErrAdapterNotFound)
+}
+
+func TestSerialConnector_BaudNegotiation(t *testing.T) {
+	t.Parallel()
+
+	adapter := &fakeBaudAdapter{baud: 38400, supportsBRD: true, confirmBRD: true}
+	connector := gobd2.NewSerialConnector("/dev/ttyUSB0", 38400, adapter, fastProfile(),
+		gobd2.WithBaudNegotiation(500000))
+
+	require.NoError(t, connector.Connect())
+	require.Equal(t, 500000, connector.BaudRate())
+
+	response, err := connector.SendCommand("ATI")
+	require.NoError(t, err)
+	require.Equal(t, "ELM327 v2.1", response)
+}
+
+func TestSerialConnector_BaudNegotiationAfterReset(t *testing.T) {
+	t.Parallel()
+
+	adapter := &fakeBaudAdapter{baud: 38400, supportsBRD: true, confirmBRD: true, resetBaud: 38400}
+	connector := gobd2.NewSerialConnector("/dev/ttyUSB0", 38400, adapter, fastProfile(),
+		gobd2.WithBaudNegotiation(500000))
+
+	require.NoError(t, connector.Connect())
+
+	_, err := connector.SendCommand(gobd2.SupportedPIDsCommand1_20)
+	require.ErrorIs(t, err, gobd2.ErrAdapterReset)
+	require.Equal(t, 500000, connector.BaudRate())
+	require.Equal(t, []int{38400, 500000, 38400, 500000}, adapter.opened, "the rate is negotiated again")
+
+	response, err := connector.SendCommand("ATI")
+	require.NoError(t, err)
+	require.Equal(t, "ELM327 v2.1", response)
+}
+
+func TestSerialConnector_BaudNegotiationFallback(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]*fakeBaudAdapter{
+		"unsupported":      {baud: 38400},
+		"handshake failed": {baud: 38400, supportsBRD: true},
+	}
+
+	for name, adapter := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			connector := gobd2.NewSerialConnector("/dev/ttyUSB0", 38400, adapter, fastProfile(),
+				gobd2.WithBaudNegotiation(115200))
+
+			require.NoError(t, connector.Connect())
+			require.Equal(t, 38400, connector.BaudRate())
+
+			response, err := connector.SendCommand("ATI")
+			require.NoError(t, err)
+			require.Equal(t, "ELM327 v2.1", response)
+		})
+	}
+}
@@ -84,4 +84,9 @@ const (
 	CommandedThrottleActuatorCommand        CommandCode = "014C"
 	TimeRunWithMILCommand                   CommandCode = "014D"
 	TimeSinceTroubleCodesClearedCommand     CommandCode = "014E"
+
+	// Vehicle information (mode 09).
+	SupportedInfoTypesCommand CommandCode = "0900"
+	VINCommand                CommandCode = "0902"
+	ECUNameCommand            CommandCode = "090A"
 )
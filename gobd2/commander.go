@@ -1,5 +1,7 @@
 package gobd2
 
+import "fmt"
+
 type Commander struct {
 	connector Connector
 }
@@ -8,11 +10,17 @@ func NewCommander(connector Connector) *Commander {
 	return &Commander{connector}
 }
 
+// ExecuteCommand sends a command and returns the adapter's answer. Status messages the adapter sends in place of a
+// vehicle response, like "NO DATA" or "CAN ERROR", are returned as their error, e.g. ErrNoData.
 func (cmd *Commander) ExecuteCommand(command CommandCode) (string, error) {
 	response, err := cmd.connector.SendCommand(command)
 	if err != nil {
 		return "", err
 	}
 
+	if err := responseError(response); err != nil {
+		return "", fmt.Errorf("%s: %w", command, err)
+	}
+
 	return response, nil
 }
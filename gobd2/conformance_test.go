@@ -0,0 +1,39 @@
+package gobd2_test
+
+import (
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/janekbaraniewski/gobd2/gobd2/gobd2test/conformance"
+)
+
+// conformanceProfile initializes adapters without pausing between steps.
+func conformanceProfile() gobd2.InitProfile {
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	return profile
+}
+
+func TestSerialConnector_Conformance(t *testing.T) {
+	t.Parallel()
+
+	conformance.Run(t, func(_ *testing.T, backend conformance.Backend) gobd2.Connector {
+		opener := &emulator.Opener{Vehicle: backend.Vehicle, Options: backend.Options}
+
+		return gobd2.NewSerialConnector("emulator", 38400, opener, gobd2.WithInitProfile(conformanceProfile()),
+			gobd2.WithTimeout(backend.Timeout))
+	})
+}
+
+func TestBluetoothConnector_Conformance(t *testing.T) {
+	t.Parallel()
+
+	conformance.Run(t, func(_ *testing.T, backend conformance.Backend) gobd2.Connector {
+		transport := &emulator.BluetoothTransport{Vehicle: backend.Vehicle, Options: backend.Options}
+
+		return gobd2.NewBluetoothConnector("00:1D:A5:68:98:8B", gobd2.WithBluetoothTransport(transport),
+			gobd2.WithSerialOptions(gobd2.WithInitProfile(conformanceProfile()), gobd2.WithTimeout(backend.Timeout)))
+	})
+}
@@ -1,8 +1,23 @@
 package gobd2
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotConnected is returned when a command is sent through a connector that is not connected.
+var ErrNotConnected = errors.New("not connected")
+
 // Connector defines the interface for connection operations.
 type Connector interface {
 	Connect() error
 	Close() error
 	SendCommand(command CommandCode) (string, error)
 }
+
+// StreamingConnector is implemented by connectors that can deliver the continuous output of monitoring commands
+// such as ATMA, line by line, until the context is canceled.
+type StreamingConnector interface {
+	Connector
+	Stream(ctx context.Context, command CommandCode, handle func(line string)) error
+}
@@ -1,112 +1,222 @@
 package gobd2
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"strings"
+	"io"
 	"time"
 
 	dbus "github.com/godbus/dbus/v5"
 	"github.com/muka/go-bluetooth/bluez/profile/adapter"
 	"github.com/muka/go-bluetooth/bluez/profile/device"
+	"github.com/tarm/serial"
 )
 
-// BluetoothConnector handles Bluetooth connections.
+// BluetoothTransport opens the data channel of a Bluetooth OBD2 adapter, which carries the same byte stream as
+// the serial port of a wired adapter.
+type BluetoothTransport interface {
+	Open(deviceAddress string) (SerialPort, error)
+}
+
+// BluetoothConnector handles Bluetooth connections. Once the transport is open, the adapter is driven exactly like
+// over a SerialConnector.
 type BluetoothConnector struct {
-	deviceAddress string
-	device        *device.Device1
-	adapter       *adapter.Adapter1
+	*SerialConnector
+	transport  BluetoothTransport
+	serialOpts []SerialConnectorOption
 }
 
-// NewBluetoothConnector creates a new connector for a Bluetooth device.
-func NewBluetoothConnector(deviceAddress string) *BluetoothConnector {
-	return &BluetoothConnector{
-		deviceAddress: deviceAddress,
+// BluetoothConnectorOption configures optional behavior of a BluetoothConnector.
+type BluetoothConnectorOption func(*BluetoothConnector)
+
+// WithBluetoothTransport replaces the default transport, which reaches the adapter through BlueZ.
+func WithBluetoothTransport(transport BluetoothTransport) BluetoothConnectorOption {
+	return func(bc *BluetoothConnector) {
+		bc.transport = transport
 	}
 }
 
-// Connect initializes the Bluetooth adapter and starts device discovery.
-func (bc *BluetoothConnector) Connect() error {
-	var err error
-	if bc.adapter, err = adapter.GetDefaultAdapter(); err != nil {
-		return fmt.Errorf("failed to get default adapter: %w", err)
+// WithSerialOptions configures the adapter session with serial connector options, e.g. WithInitProfile or
+// WithTimeout.
+func WithSerialOptions(opts ...SerialConnectorOption) BluetoothConnectorOption {
+	return func(bc *BluetoothConnector) {
+		bc.serialOpts = append(bc.serialOpts, opts...)
 	}
+}
 
-	if err = bc.adapter.StartDiscovery(); err != nil {
-		return fmt.Errorf("failed to start discovery: %w", err)
+// NewBluetoothConnector creates a new connector for a Bluetooth device. Commands time out after 10 seconds unless
+// another timeout is configured with WithSerialOptions.
+func NewBluetoothConnector(deviceAddress string, opts ...BluetoothConnectorOption) *BluetoothConnector {
+	bc := &BluetoothConnector{
+		transport:  &bluezTransport{},
+		serialOpts: []SerialConnectorOption{WithTimeout(defaultCommandTimeout)},
+	}
+
+	for _, opt := range opts {
+		opt(bc)
+	}
+
+	bc.SerialConnector = NewSerialConnector(deviceAddress, 0, &bluetoothOpener{bc.transport}, bc.serialOpts...)
+
+	return bc
+}
+
+// bluetoothOpener opens the transport in place of a serial port.
+type bluetoothOpener struct {
+	transport BluetoothTransport
+}
+
+func (o *bluetoothOpener) OpenPort(config *serial.Config) (SerialPort, error) {
+	return o.transport.Open(config.Name)
+}
+
+// bluezTransport reaches the adapter through BlueZ over D-Bus, writing commands to and reading answers from a GATT
+// characteristic.
+type bluezTransport struct{}
+
+// Open initializes the Bluetooth adapter, discovers the device and connects to it.
+func (t *bluezTransport) Open(deviceAddress string) (SerialPort, error) {
+	bluetoothAdapter, err := adapter.GetDefaultAdapter()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default adapter: %w", err)
+	}
+
+	if err = bluetoothAdapter.StartDiscovery(); err != nil {
+		return nil, fmt.Errorf("failed to start discovery: %w", err)
 	}
 
 	// This should be handled better in a real application, with timeout and context handling.
 	time.Sleep(10 * time.Second) // Wait for some time to discover devices
 
-	devices, err := bc.adapter.GetDevices()
+	devices, err := bluetoothAdapter.GetDevices()
 	if err != nil {
-		return fmt.Errorf("failed to get devices: %w", err)
+		return nil, fmt.Errorf("failed to get devices: %w", err)
 	}
 
+	var found *device.Device1
+
 	for _, d := range devices {
-		if d.Properties.Address == bc.deviceAddress {
-			bc.device = d
+		if d.Properties.Address == deviceAddress {
+			found = d
 
 			break
 		}
 	}
 
-	if bc.device == nil {
-		return errors.New("device not found")
+	if found == nil {
+		return nil, errors.New("device not found")
 	}
 
-	err = bc.device.Connect()
+	if err = found.Connect(); err != nil {
+		return nil, fmt.Errorf("failed to connect to device: %w", err)
+	}
+
+	// Access the D-Bus connection
+	conn, err := dbus.SystemBus()
 	if err != nil {
-		return fmt.Errorf("failed to connect to device: %w", err)
+		return nil, fmt.Errorf("failed to connect to system bus: %w", err)
 	}
 
-	return nil
-}
+	// TODO: find the actual dbus paths for the device
+	servicePath := fmt.Sprintf("%s/service0001", found.Path())
+	charPath := fmt.Sprintf("%s/char0001", servicePath)
 
-// Close terminates the connection to the Bluetooth device.
-func (bc *BluetoothConnector) Close() error {
-	if bc.device != nil {
-		return bc.device.Disconnect()
+	notifications := make(chan *dbus.Signal, notificationBuffer)
+	conn.Signal(notifications)
+
+	disconnect := func() error {
+		conn.RemoveSignal(notifications)
+
+		return found.Disconnect()
 	}
 
-	return nil
+	port, err := newBluezPort(conn.Object("org.bluez", dbus.ObjectPath(charPath)), notifications, disconnect)
+	if err != nil {
+		return nil, errors.Join(err, disconnect())
+	}
+
+	return port, nil
 }
 
-// Assuming you have a connected device object.
-func (bc *BluetoothConnector) SendCommand(command CommandCode) (string, error) {
-	// TODO: find the actual dbus paths for the device
-	servicePath := fmt.Sprintf("%s/service0001", bc.device.Path())
-	charPath := fmt.Sprintf("%s/char0001", servicePath)
+// D-Bus names used to exchange data through a GATT characteristic.
+const (
+	gattCharacteristicInterface = "org.bluez.GattCharacteristic1"
+	propertiesInterface         = "org.freedesktop.DBus.Properties"
+	propertiesChangedSignal     = "PropertiesChanged"
+)
 
-	// Access the D-Bus connection
-	conn, err := dbus.SystemBus()
-	if err != nil {
-		return "", fmt.Errorf("failed to connect to system bus: %w", err)
+// notificationBuffer is the number of value notifications queued before the connector reads them.
+const notificationBuffer = 64
+
+// bluezPort exchanges data through a GATT characteristic: commands are written to its value, and the adapter's
+// answer arrives as notifications of value changes, in chunks the connector reads until the prompt.
+type bluezPort struct {
+	characteristic dbus.BusObject
+	notifications  chan *dbus.Signal
+	pending        bytes.Buffer
+	// disconnect stops the delivery of notifications and disconnects the device.
+	disconnect func() error
+}
+
+// newBluezPort subscribes to the value notifications of characteristic, which the bus delivers to signals.
+func newBluezPort(characteristic dbus.BusObject, signals chan *dbus.Signal, disconnect func() error) (*bluezPort, error) {
+	if call := characteristic.AddMatchSignal(propertiesInterface, propertiesChangedSignal); call.Err != nil {
+		return nil, fmt.Errorf("failed to subscribe to notifications: %w", call.Err)
 	}
 
-	// Access the characteristic
-	char := conn.Object("org.bluez", dbus.ObjectPath(charPath))
-	writeValue := []byte(string(command) + "\r")
+	if call := characteristic.Call(gattCharacteristicInterface+".StartNotify", 0); call.Err != nil {
+		return nil, fmt.Errorf("failed to start notifications: %w", call.Err)
+	}
+
+	return &bluezPort{characteristic: characteristic, notifications: signals, disconnect: disconnect}, nil
+}
 
-	call := char.Call("org.bluez.GattCharacteristic1.WriteValue", 0, writeValue, map[string]interface{}{})
+func (p *bluezPort) Write(data []byte) (int, error) {
+	call := p.characteristic.Call(gattCharacteristicInterface+".WriteValue", 0, data, map[string]interface{}{})
 	if call.Err != nil {
-		return "", fmt.Errorf("failed to write value: %w", call.Err)
+		return 0, fmt.Errorf("failed to write value: %w", call.Err)
 	}
 
-	// Read the response, assuming the characteristic allows reading or notifies after write
-	var value []byte
+	return len(data), nil
+}
 
-	call = char.Call("org.bluez.GattCharacteristic1.ReadValue", 0, map[string]interface{}{})
-	if call.Err != nil {
-		return "", fmt.Errorf("failed to read value: %w", call.Err)
+// Read returns the data notified so far, or io.EOF if nothing arrived yet, in which case the connector polls again
+// until the prompt arrives or the command times out.
+func (p *bluezPort) Read(b []byte) (int, error) {
+	for p.pending.Len() == 0 {
+		select {
+		case signal := <-p.notifications:
+			p.pending.Write(p.notifiedValue(signal))
+		default:
+			return 0, io.EOF
+		}
 	}
 
-	if err := call.Store(&value); err != nil {
-		return "", err
+	return p.pending.Read(b)
+}
+
+// notifiedValue returns the value of the characteristic announced by signal, or nil if signal announces anything
+// else.
+func (p *bluezPort) notifiedValue(signal *dbus.Signal) []byte {
+	if signal.Path != p.characteristic.Path() || signal.Name != propertiesInterface+"."+propertiesChangedSignal ||
+		len(signal.Body) < 2 {
+		return nil
+	}
+
+	if iface, _ := signal.Body[0].(string); iface != gattCharacteristicInterface {
+		return nil
 	}
 
-	response := strings.Trim(string(value), " \r\n>")
+	changed, _ := signal.Body[1].(map[string]dbus.Variant)
+	value, _ := changed["Value"].Value().([]byte)
+
+	return value
+}
+
+// Close stops the notifications and terminates the connection to the Bluetooth device.
+func (p *bluezPort) Close() error {
+	call := p.characteristic.Call(gattCharacteristicInterface+".StopNotify", 0)
 
-	return response, nil
+	return errors.Join(call.Err, p.disconnect())
 }
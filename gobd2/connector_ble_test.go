@@ -0,0 +1,130 @@
+package gobd2_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeCharacteristic is the GATT characteristic of a BLE adapter on D-Bus. It notifies the answer to each command
+// written to it in chunks of 20 bytes, the first one after a delay, like a BLE adapter does.
+type FakeCharacteristic struct {
+	dbus.BusObject
+	responses map[string]string
+	delay     time.Duration
+	signals   chan *dbus.Signal
+
+	mu        sync.Mutex
+	notifying bool
+}
+
+func newFakeCharacteristic(responses map[string]string, delay time.Duration) *FakeCharacteristic {
+	characteristic := &FakeCharacteristic{responses: map[string]string{
+		"ATZ":   "\r\rELM327 v1.5",
+		"ATE0":  "OK",
+		"ATL0":  "OK",
+		"ATSP0": "OK",
+	}, delay: delay, signals: make(chan *dbus.Signal, 64)}
+	for command, response := range responses {
+		characteristic.responses[command] = response
+	}
+
+	return characteristic
+}
+
+func (c *FakeCharacteristic) Path() dbus.ObjectPath {
+	return "/org/bluez/hci0/dev_00_1D_A5_68_98_8B/service0001/char0001"
+}
+
+func (c *FakeCharacteristic) AddMatchSignal(string, string, ...dbus.MatchOption) *dbus.Call {
+	return &dbus.Call{}
+}
+
+func (c *FakeCharacteristic) Call(method string, _ dbus.Flags, args ...interface{}) *dbus.Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch method {
+	case "org.bluez.GattCharacteristic1.StartNotify":
+		c.notifying = true
+	case "org.bluez.GattCharacteristic1.StopNotify":
+		c.notifying = false
+	case "org.bluez.GattCharacteristic1.WriteValue":
+		command := strings.TrimSpace(string(args[0].([]byte)))
+
+		response, ok := c.responses[command]
+		if !ok {
+			response = "?"
+		}
+
+		c.notify("Notifying", true) // notifications of other properties are ignored
+
+		go c.answer(response + "\r\r>")
+	default:
+		return &dbus.Call{Err: fmt.Errorf("unexpected call of %s", method)}
+	}
+
+	return &dbus.Call{}
+}
+
+func (c *FakeCharacteristic) Notifying() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.notifying
+}
+
+func (c *FakeCharacteristic) answer(response string) {
+	time.Sleep(c.delay)
+
+	for len(response) > 0 {
+		chunk := response[:min(20, len(response))]
+		response = response[len(chunk):]
+
+		c.notify("Value", []byte(chunk))
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (c *FakeCharacteristic) notify(property string, value interface{}) {
+	c.signals <- &dbus.Signal{
+		Path: c.Path(),
+		Name: "org.freedesktop.DBus.Properties.PropertiesChanged",
+		Body: []interface{}{
+			"org.bluez.GattCharacteristic1",
+			map[string]dbus.Variant{property: dbus.MakeVariant(value)},
+			[]string{},
+		},
+	}
+}
+
+func TestBluezPort(t *testing.T) {
+	t.Parallel()
+
+	vin := "014\r0: 49 02 01 31 47 4F\r1: 42 44 32 45 4D 55 4C\r2: 41 54 4F 52 30 30 31"
+	characteristic := newFakeCharacteristic(map[string]string{"0902": vin}, 200*time.Millisecond)
+
+	port, err := gobd2.NewBluezPort(characteristic, characteristic.signals)
+	require.NoError(t, err)
+	require.True(t, characteristic.Notifying())
+
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(port, nil)
+
+	connector := gobd2.NewSerialConnector("00:1D:A5:68:98:8B", 0, mockOpener, gobd2.WithTimeout(time.Second))
+	require.NoError(t, connector.Connect(), "answers arriving late and in chunks are read until the prompt")
+
+	response, err := connector.SendCommand("0902")
+	require.NoError(t, err)
+	require.Equal(t, vin, response)
+
+	require.NoError(t, connector.Close())
+	require.False(t, characteristic.Notifying())
+}
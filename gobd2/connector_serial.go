@@ -2,7 +2,13 @@ package gobd2
 
 import (
 	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tarm/serial"
@@ -27,67 +33,627 @@ func (rpo *RealPortOpener) OpenPort(config *serial.Config) (SerialPort, error) {
 	return serial.OpenPort(config)
 }
 
-// SerialConnector now includes a SerialPortOpener.
+// SerialConnector talks to an ELM327 adapter over a serial port opened by a SerialPortOpener. It is safe for
+// concurrent use: commands are sent one at a time.
 type SerialConnector struct {
+	mu         sync.Mutex
+	connected  bool
 	portOpener SerialPortOpener
 	config     *serial.Config
 	connection SerialPort
 	reader     *bufio.Reader
 	writer     *bufio.Writer
+
+	initProfile   InitProfile
+	protocol      Protocol
+	forceProtocol bool
+	protocolStore ProtocolStore
+	vehicleID     string
+	fingerprint   bool
+	adapter       *AdapterFingerprint
+	stnMode       bool
+	stn           bool
+	timeout       time.Duration
+	stale         bool
+	baudRates     []int
+	targetBaud    int
+	// defaultBaud is the rate the adapter talks at after a reset, the rate it was found at while connecting.
+	defaultBaud int
+	resetting   bool
+	settings    adapterSettings
+}
+
+const (
+	// readPollInterval is the read timeout ports are opened with when reads need to be bounded.
+	readPollInterval = 100 * time.Millisecond
+	// defaultCommandTimeout bounds commands when reads are bounded but no timeout was configured.
+	defaultCommandTimeout = 10 * time.Second
+)
+
+// ErrTimeout is returned when the adapter does not answer in time.
+var ErrTimeout = errors.New("timeout waiting for adapter")
+
+// SerialConnectorOption configures optional behavior of a SerialConnector.
+type SerialConnectorOption func(*SerialConnector)
+
+// WithInitProfile replaces the default adapter initialization sequence.
+func WithInitProfile(profile InitProfile) SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.initProfile = profile
+	}
 }
 
-func NewSerialConnector(device string, baud int, opener SerialPortOpener) *SerialConnector {
-	return &SerialConnector{
-		portOpener: opener,
-		config:     &serial.Config{Name: device, Baud: baud},
+// WithFingerprint identifies the adapter after initialization, so that features depending on optional adapter
+// support are enabled or disabled according to what the adapter really supports.
+func WithFingerprint() SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.fingerprint = true
 	}
 }
 
+// WithSTNExtensions detects OBDLink STN adapters while connecting and, if one is found, uses its extended
+// commands: requests too long for a plain ELM327 are sent with STPX. Other adapters are used as before.
+func WithSTNExtensions() SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.stnMode = true
+	}
+}
+
+// WithTimeout bounds how long SendCommand waits for the adapter to finish answering. Without a timeout, reads
+// block until the adapter sends its prompt.
+func WithTimeout(timeout time.Duration) SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.timeout = timeout
+	}
+}
+
+// WithProtocol makes the adapter use the given protocol instead of searching for one.
+func WithProtocol(protocol Protocol) SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.protocol = protocol
+		sc.forceProtocol = true
+	}
+}
+
+// WithPreferredProtocol makes the adapter try the given protocol first and fall back to the automatic search
+// if the vehicle does not answer on it.
+func WithPreferredProtocol(protocol Protocol) SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.protocol = protocol
+		sc.forceProtocol = false
+	}
+}
+
+// WithProtocolStore remembers the protocol negotiated with the vehicle in store and tries it first on later
+// connections to the same vehicle.
+func WithProtocolStore(store ProtocolStore, vehicleID string) SerialConnectorOption {
+	return func(sc *SerialConnector) {
+		sc.protocolStore = store
+		sc.vehicleID = vehicleID
+	}
+}
+
+func NewSerialConnector(device string, baud int, opener SerialPortOpener, opts ...SerialConnectorOption) *SerialConnector {
+	sc := &SerialConnector{
+		portOpener:  opener,
+		config:      &serial.Config{Name: device, Baud: baud},
+		initProfile: DefaultInitProfile(),
+		settings:    adapterSettings{},
+	}
+
+	for _, opt := range opts {
+		opt(sc)
+	}
+
+	if sc.timeout == 0 && (len(sc.baudRates) > 0 || sc.targetBaud > 0) {
+		sc.timeout = defaultCommandTimeout
+	}
+
+	if sc.timeout > 0 {
+		sc.config.ReadTimeout = readPollInterval
+	}
+
+	return sc
+}
+
+// Connect opens the port and initializes the adapter. Connecting a connected connector does nothing.
 func (sc *SerialConnector) Connect() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.connected {
+		return nil
+	}
+
+	if err := sc.connect(); err != nil {
+		return err
+	}
+
+	sc.connected = true
+
+	return nil
+}
+
+// connect opens the port and runs the connection sequence.
+func (sc *SerialConnector) connect() error {
 	var err error
-	sc.connection, err = sc.portOpener.OpenPort(sc.config)
-	if err != nil { //nolint:wsl
+
+	if len(sc.baudRates) > 0 {
+		_, err = sc.detectBaudRate()
+	} else {
+		err = sc.open(sc.config.Baud)
+	}
+
+	if err != nil {
 		return err
 	}
 
-	sc.reader = bufio.NewReader(sc.connection)
-	sc.writer = bufio.NewWriter(sc.connection)
+	sc.defaultBaud = sc.config.Baud
+
+	if err := sc.loadProtocol(); err != nil {
+		return err
+	}
+
+	if err := sc.initializeELM327(); err != nil {
+		return err
+	}
+
+	if sc.fingerprint {
+		if sc.adapter, err = NewELM327(serialSession{sc}).Fingerprint(); err != nil {
+			return fmt.Errorf("failed to identify adapter: %w", err)
+		}
+	}
+
+	if err := sc.enableExtensions(); err != nil {
+		return err
+	}
 
-	return sc.initializeELM327()
+	return sc.storeProtocol()
 }
 
+// enableExtensions enables the STN extensions and switches to the target baud rate, if they were requested.
+func (sc *SerialConnector) enableExtensions() error {
+	if err := sc.detectSTN(); err != nil {
+		return err
+	}
+
+	return sc.negotiateBaudRate()
+}
+
+// recoverReset runs the connection sequence again after the adapter reset itself: the port is reopened at the
+// rate the adapter restarts at, and the init profile, the STN extensions and the target baud rate are applied
+// again. A reset during recovery fails it instead of starting over.
+func (sc *SerialConnector) recoverReset() error {
+	sc.resetting = true
+	defer func() { sc.resetting = false }()
+
+	clear(sc.settings)
+
+	if sc.config.Baud != sc.defaultBaud {
+		if err := sc.open(sc.defaultBaud); err != nil {
+			return err
+		}
+	}
+
+	if err := sc.initializeELM327(); err != nil {
+		return err
+	}
+
+	return sc.enableExtensions()
+}
+
+// Close closes the port. Closing a connector that is not connected does nothing.
 func (sc *SerialConnector) Close() error {
-	return sc.connection.Close()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.connected = false
+
+	if sc.connection == nil {
+		return nil
+	}
+
+	err := sc.connection.Close()
+	sc.connection = nil
+
+	return err
 }
 
+// SendCommand sends a command and returns the adapter's answer without the prompt.
+//
+// If the adapter reports a low voltage reset, the connection sequence runs again, restoring the init profile, the
+// STN extensions and the baud rate, and ErrAdapterReset is returned, so the command can be retried. After a
+// timeout, output the adapter sends late is discarded before the next command.
 func (sc *SerialConnector) SendCommand(command CommandCode) (string, error) {
-	_, err := sc.writer.WriteString(string(command) + "\r")
-	if err != nil {
-		return "", err
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.connected {
+		return "", ErrNotConnected
+	}
+
+	return sc.sendCommand(command)
+}
+
+// sendCommand sends a command while the caller holds the lock.
+func (sc *SerialConnector) sendCommand(command CommandCode) (string, error) {
+	if sc.stn {
+		command = stnCommand(command)
+	}
+
+	if sc.stale {
+		sc.discardPending()
 	}
 
-	sc.writer.Flush()
+	if err := sc.write(command); err != nil {
+		return "", err
+	}
 
 	// Reading and cleaning up the response to remove command echo and extra characters
-	response, err := sc.reader.ReadString('>')
+	response, err := sc.readUntil('>', sc.timeout)
+	if errors.Is(err, ErrTimeout) {
+		sc.stale = true
+	}
+
 	if err != nil {
 		return "", err
 	}
 
-	cleanedResponse := strings.Trim(response, " \r\n>")
+	cleanedResponse := strings.Trim(printable(response), " \r\n>")
+	sc.settings.record(command, cleanedResponse)
+
+	if errors.Is(responseError(cleanedResponse), ErrAdapterReset) {
+		if sc.resetting {
+			return "", fmt.Errorf("%s: %w", command, ErrAdapterReset)
+		}
+
+		if err := sc.recoverReset(); err != nil {
+			return "", fmt.Errorf("failed to initialize adapter after reset: %w", err)
+		}
+
+		return "", fmt.Errorf("%s: %w", command, ErrAdapterReset)
+	}
 
 	return cleanedResponse, nil
 }
 
+// Stream sends a monitoring command and passes each line of its output to handle until ctx is canceled, at which
+// point the command is interrupted and Stream returns once the adapter is ready for the next command.
+func (sc *SerialConnector) Stream(ctx context.Context, command CommandCode, handle func(line string)) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.connected {
+		return ErrNotConnected
+	}
+
+	if err := sc.write(command); err != nil {
+		return err
+	}
+
+	var interrupted bool
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			interrupted = true
+			sc.write("") //nolint:errcheck // any character interrupts monitoring
+		case <-done:
+		}
+	}()
+
+	// The interrupt is written through the connection while Stream holds the lock only. If it raced with the end of
+	// monitoring, the adapter's late answer to it is discarded before the next command.
+	defer func() {
+		close(done)
+		<-stopped
+
+		sc.stale = sc.stale || interrupted
+	}()
+
+	var line strings.Builder
+
+	for {
+		b, err := sc.reader.ReadByte()
+		if errors.Is(err, io.EOF) && sc.timeout > 0 {
+			time.Sleep(readPollInterval / 10) // nothing on the bus yet, keep monitoring
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch b {
+		case '\r', '\n', '>':
+			if text := strings.TrimSpace(line.String()); text != "" {
+				handle(text)
+			}
+
+			line.Reset()
+
+			if b == '>' {
+				return nil
+			}
+		default:
+			line.WriteByte(b)
+		}
+	}
+}
+
+// AdapterSetting returns the last command sent through the connector that changed a setting of the adapter, e.g.
+// "ATH1" for "ATH".
+func (sc *SerialConnector) AdapterSetting(name string) (CommandCode, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	command, ok := sc.settings[name]
+
+	return command, ok
+}
+
+// Adapter returns the adapter identified while connecting, or nil if fingerprinting is not enabled.
+func (sc *SerialConnector) Adapter() *AdapterFingerprint {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.adapter
+}
+
+// Capabilities returns the capabilities of the adapter identified while connecting. Without fingerprinting no
+// optional capability is assumed.
+func (sc *SerialConnector) Capabilities() Capabilities {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.capabilities()
+}
+
+// capabilities returns the capabilities of the adapter while the caller holds the lock.
+func (sc *SerialConnector) capabilities() Capabilities {
+	var caps Capabilities
+	if sc.adapter != nil {
+		caps = sc.adapter.Capabilities
+	}
+
+	if sc.stn {
+		caps |= CapabilitySTN
+	}
+
+	return caps
+}
+
+// Protocol reports the protocol the adapter negotiated with the vehicle.
+func (sc *SerialConnector) Protocol() (Protocol, error) {
+	return queryProtocol(sc)
+}
+
+// open opens the port at the given baud rate, closing the port opened before, if any.
+func (sc *SerialConnector) open(baud int) error {
+	if sc.connection != nil {
+		sc.connection.Close()
+		sc.connection = nil
+	}
+
+	config := *sc.config
+	config.Baud = baud
+
+	connection, err := sc.portOpener.OpenPort(&config)
+	if err != nil {
+		return err
+	}
+
+	sc.config = &config
+	sc.connection = connection
+	sc.reader = bufio.NewReader(sc.connection)
+	sc.writer = bufio.NewWriter(sc.connection)
+
+	return nil
+}
+
+// readUntil reads up to and including delim. Without a timeout the first read error is returned, otherwise
+// reads that come back empty are retried until the timeout expires.
+func (sc *SerialConnector) readUntil(delim byte, timeout time.Duration) (string, error) {
+	if timeout == 0 {
+		return sc.reader.ReadString(delim)
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	var data []byte
+
+	for {
+		chunk, err := sc.reader.ReadBytes(delim)
+		data = append(data, chunk...)
+
+		switch {
+		case err == nil:
+			return string(data), nil
+		case !errors.Is(err, io.EOF):
+			return string(data), err
+		case time.Now().After(deadline):
+			return string(data), ErrTimeout
+		case len(chunk) == 0:
+			time.Sleep(readPollInterval / 10) // the port returned without waiting
+		}
+	}
+}
+
+// discardPending drops whatever the adapter sent that was not read yet, e.g. the late answer to a command that
+// timed out.
+func (sc *SerialConnector) discardPending() {
+	sc.stale = false
+
+	sc.reader.Discard(sc.reader.Buffered()) //nolint:errcheck // discarding buffered bytes cannot fail
+
+	if sc.timeout == 0 {
+		return // reads would block
+	}
+
+	for {
+		if _, err := sc.reader.ReadByte(); err != nil {
+			return
+		}
+	}
+}
+
+// printable drops line noise from a response, keeping printable characters and line breaks.
+func printable(response string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || (r >= ' ' && r <= '~') {
+			return r
+		}
+
+		return -1
+	}, response)
+}
+
+func (sc *SerialConnector) write(command CommandCode) error {
+	if _, err := sc.writer.WriteString(string(command) + "\r"); err != nil {
+		return err
+	}
+
+	return sc.writer.Flush()
+}
+
+// detectSTN enables the STN extensions if they were requested and the adapter is STN based. A fingerprint taken
+// while connecting is trusted, otherwise the adapter is asked for its STN identification.
+func (sc *SerialConnector) detectSTN() error {
+	sc.stn = false
+	if !sc.stnMode {
+		return nil
+	}
+
+	if sc.adapter != nil {
+		sc.stn = sc.adapter.Capabilities.Has(CapabilitySTN)
+	} else {
+		id, err := NewELM327(serialSession{sc}).optionalQuery("STI")
+		if err != nil {
+			return fmt.Errorf("failed to detect STN adapter: %w", err)
+		}
+
+		sc.stn = strings.HasPrefix(id, "STN")
+	}
+
+	if !sc.stn {
+		return nil
+	}
+
+	if err := NewSTN(serialSession{sc}).SetCANSegmentation(true); err != nil {
+		return fmt.Errorf("failed to enable CAN segmentation: %w", err)
+	}
+
+	return nil
+}
+
+// stnCommand rewrites OBD requests longer than a plain ELM327 can send into STPX commands.
+func stnCommand(command CommandCode) CommandCode {
+	data, err := hex.DecodeString(string(command))
+	if err != nil || len(data) <= maxELMRequestBytes {
+		return command
+	}
+
+	return stpxCommand("", data, 0)
+}
+
 func (sc *SerialConnector) initializeELM327() error {
-	initCommands := []CommandCode{"ATZ", "ATE0", "ATL0", "ATSP0"}
-	for _, cmd := range initCommands {
-		if _, err := sc.SendCommand(cmd); err != nil {
+	steps, err := sc.initProfile.steps()
+	if err != nil {
+		return err
+	}
+
+	steps = append(steps, initStep{command: sc.protocolCommand(), expectOK: true})
+	for _, step := range steps {
+		response, err := sc.sendCommand(step.command)
+		if err != nil {
+			return &InitError{Step: step.command, Err: err}
+		}
+
+		if err := checkStepResponse(step, response); err != nil {
 			return err
 		}
 
-		time.Sleep(100 * time.Millisecond) // Delay to allow the ELM327 to reset and apply settings
+		time.Sleep(sc.initProfile.StepDelay) // Delay to allow the ELM327 to reset and apply settings
+	}
+
+	return nil
+}
+
+// protocolCommand returns the command selecting the configured protocol: ATSP for a forced protocol, ATTP with
+// automatic fallback for a preferred one.
+func (sc *SerialConnector) protocolCommand() CommandCode {
+	switch {
+	case sc.protocol == ProtocolAuto:
+		return "ATSP0"
+	case sc.forceProtocol:
+		return CommandCode(fmt.Sprintf("ATSP%X", byte(sc.protocol)))
+	default:
+		return CommandCode(fmt.Sprintf("ATTPA%X", byte(sc.protocol)))
+	}
+}
+
+// loadProtocol prefers the protocol remembered for the vehicle, unless a protocol was configured explicitly.
+func (sc *SerialConnector) loadProtocol() error {
+	if sc.protocolStore == nil || sc.protocol != ProtocolAuto {
+		return nil
+	}
+
+	protocol, ok, err := sc.protocolStore.LoadProtocol(sc.vehicleID)
+	if err != nil {
+		return fmt.Errorf("failed to load protocol for %s: %w", sc.vehicleID, err)
+	}
+
+	if ok {
+		sc.protocol = protocol
 	}
 
 	return nil
 }
+
+// storeProtocol remembers the negotiated protocol for the vehicle. A vehicle that does not answer is not an
+// error, the protocol is simply not stored.
+func (sc *SerialConnector) storeProtocol() error {
+	if sc.protocolStore == nil {
+		return nil
+	}
+
+	protocol, err := DetectProtocol(serialSession{sc})
+	if isAdapterError(err) || errors.Is(err, ErrUnknownProtocol) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return sc.protocolStore.SaveProtocol(sc.vehicleID, protocol)
+}
+
+// serialSession sends commands through a SerialConnector whose lock is already held, e.g. while connecting.
+type serialSession struct {
+	*SerialConnector
+}
+
+func (s serialSession) SendCommand(command CommandCode) (string, error) {
+	return s.sendCommand(command)
+}
+
+func (s serialSession) AdapterSetting(name string) (CommandCode, bool) {
+	command, ok := s.settings[name]
+
+	return command, ok
+}
+
+func (s serialSession) Adapter() *AdapterFingerprint {
+	return s.adapter
+}
+
+func (s serialSession) Capabilities() Capabilities {
+	return s.capabilities()
+}
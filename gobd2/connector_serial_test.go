@@ -2,8 +2,12 @@ package gobd2_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/janekbaraniewski/gobd2/gobd2"
 	"github.com/stretchr/testify/mock"
@@ -92,3 +96,166 @@ func TestSerialConnector_Connect_Failure(t *testing.T) {
 	// Verify the expectations were met for the mock
 	mockOpener.AssertExpectations(t)
 }
+
+func TestSerialConnector_SendCommand_AfterFailedConnect(t *testing.T) {
+	t.Parallel()
+
+	port := newScriptedSerialPort(map[string]string{"ATL0": "?"})
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(port, nil)
+
+	connector := gobd2.NewSerialConnector("COM1", 115200, mockOpener)
+	require.Error(t, connector.Connect())
+
+	sent := len(port.commands)
+
+	_, err := connector.SendCommand(gobd2.EngineRPMCommand)
+	require.ErrorIs(t, err, gobd2.ErrNotConnected)
+	require.Len(t, port.commands, sent, "nothing is written to the half-initialized adapter")
+}
+
+// ScriptedSerialPort answers every command written to it with a scripted response followed by the prompt, and
+// records the commands it received. Commands without a scripted response are answered with "?".
+type ScriptedSerialPort struct {
+	responses map[string]string
+	commands  []string
+	pending   string
+	out       bytes.Buffer
+}
+
+func newScriptedSerialPort(responses map[string]string) *ScriptedSerialPort {
+	port := &ScriptedSerialPort{responses: map[string]string{
+		"ATZ":   "ELM327 v1.5",
+		"ATE0":  "OK",
+		"ATL0":  "OK",
+		"ATSP0": "OK",
+	}}
+	for command, response := range responses {
+		port.responses[command] = response
+	}
+
+	return port
+}
+
+func (p *ScriptedSerialPort) Read(b []byte) (int, error) {
+	return p.out.Read(b)
+}
+
+func (p *ScriptedSerialPort) Write(b []byte) (int, error) {
+	p.pending += string(b)
+
+	for {
+		command, rest, ok := strings.Cut(p.pending, "\r")
+		if !ok {
+			break
+		}
+
+		p.pending = rest
+		p.commands = append(p.commands, command)
+
+		response, ok := p.responses[command]
+		if !ok {
+			response = "?"
+		}
+
+		p.out.WriteString(response + "\r\r>")
+	}
+
+	return len(b), nil
+}
+
+func (p *ScriptedSerialPort) Close() error {
+	return nil
+}
+
+func connectScripted(t *testing.T, port *ScriptedSerialPort, opts ...gobd2.SerialConnectorOption) *gobd2.SerialConnector {
+	t.Helper()
+
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(port, nil)
+
+	connector := gobd2.NewSerialConnector("COM1", 115200, mockOpener, opts...)
+	require.NoError(t, connector.Connect())
+
+	return connector
+}
+
+// MonitoringSerialPort is a ScriptedSerialPort safe for concurrent use that answers "ATMA" with a frame on every
+// read, until any character written to it stops monitoring.
+type MonitoringSerialPort struct {
+	*ScriptedSerialPort
+	mu         sync.Mutex
+	monitoring bool
+}
+
+func (p *MonitoringSerialPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.monitoring && p.out.Len() == 0 {
+		p.out.WriteString("7E8 03 41 0D 20\r")
+	}
+
+	return p.out.Read(b)
+}
+
+func (p *MonitoringSerialPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.monitoring:
+		p.monitoring = false
+		p.out.WriteString("STOPPED\r\r>")
+	case string(b) == "ATMA\r":
+		p.monitoring = true
+	default:
+		return p.ScriptedSerialPort.Write(b)
+	}
+
+	return len(b), nil
+}
+
+func TestSerialConnector_Stream_Cancel(t *testing.T) {
+	t.Parallel()
+
+	port := &MonitoringSerialPort{ScriptedSerialPort: newScriptedSerialPort(map[string]string{"010D": "41 0D 20"})}
+
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(port, nil)
+
+	connector := gobd2.NewSerialConnector("COM1", 115200, mockOpener, gobd2.WithTimeout(time.Second))
+	require.NoError(t, connector.Connect())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var lines []string
+
+	err := connector.Stream(ctx, "ATMA", func(line string) {
+		lines = append(lines, line)
+		if len(lines) == 3 {
+			cancel()
+		}
+	})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(lines), 4)
+	require.Equal(t, "STOPPED", lines[len(lines)-1])
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0D 20", response)
+}
+
+func TestSerialConnector_ResetDuringRecovery(t *testing.T) {
+	t.Parallel()
+
+	port := newScriptedSerialPort(map[string]string{"0100": "LV RESET\r\rELM327 v1.5"})
+	connector := connectScripted(t, port)
+
+	port.responses["ATE0"] = "LV RESET\r\rELM327 v1.5"
+
+	_, err := connector.SendCommand(gobd2.SupportedPIDsCommand1_20)
+	require.ErrorIs(t, err, gobd2.ErrAdapterReset)
+	require.ErrorContains(t, err, "failed to initialize adapter after reset")
+}
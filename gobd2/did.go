@@ -0,0 +1,105 @@
+package gobd2
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DIDDefinitions is the content of a definition file describing manufacturer data identifiers read with
+// ReadDataByIdentifier (mode 22), e.g.
+//
+//	{
+//	  "header": "7E0",
+//	  "dids": [
+//	    {"did": "1A2B", "name": "Oil life", "expression": "A*100/255", "unit": "%"},
+//	    {"did": "1A30", "name": "Transmission temperature", "offset": 1, "length": 2, "expression": "(A*256+B)/10-40", "unit": "°C"}
+//	  ]
+//	}
+type DIDDefinitions struct {
+	// Header is the request header of the identifiers that do not set their own, e.g. "7E0".
+	Header string          `json:"header,omitempty"`
+	DIDs   []DIDDefinition `json:"dids"`
+}
+
+// DIDDefinition describes a value read from a data identifier.
+type DIDDefinition struct {
+	// DID is the identifier in hex, e.g. "F40D".
+	DID  string `json:"did"`
+	Name string `json:"name"`
+	// Header is the request header, overriding the one of the file.
+	Header string `json:"header,omitempty"`
+	// Offset and Length select the bytes of the value in the data record, as in PID.
+	Offset int `json:"offset,omitempty"`
+	Length int `json:"length,omitempty"`
+	// Expression computes the value from its bytes, as in PID.
+	Expression string `json:"expression"`
+	Unit       string `json:"unit,omitempty"`
+}
+
+// PIDs converts the definitions into PIDs, validating them.
+func (d *DIDDefinitions) PIDs() ([]PID, error) {
+	pids := make([]PID, 0, len(d.DIDs))
+
+	for _, definition := range d.DIDs {
+		did, err := hex.DecodeString(definition.DID)
+		if err != nil || len(did) != 2 {
+			return nil, fmt.Errorf("%q: malformed DID %q: %w", definition.Name, definition.DID, ErrInvalidPID)
+		}
+
+		header := definition.Header
+		if header == "" {
+			header = d.Header
+		}
+
+		pid := PID{
+			Name:       definition.Name,
+			Command:    CommandCode(fmt.Sprintf("%02X%X", ServiceReadDataByIdentifier, did)),
+			Header:     header,
+			Offset:     definition.Offset,
+			Length:     definition.Length,
+			Expression: definition.Expression,
+			Unit:       definition.Unit,
+		}
+
+		if err := pid.compile(); err != nil {
+			return nil, err
+		}
+
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// ReadDIDDefinitions reads a definition file and returns the PIDs it defines.
+func ReadDIDDefinitions(r io.Reader) ([]PID, error) {
+	var definitions DIDDefinitions
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&definitions); err != nil {
+		return nil, fmt.Errorf("failed to decode DID definitions: %w", err)
+	}
+
+	return definitions.PIDs()
+}
+
+// LoadDIDDefinitions reads the definition file at path and returns the PIDs it defines.
+func LoadDIDDefinitions(path string) ([]PID, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pids, err := ReadDIDDefinitions(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return pids, nil
+}
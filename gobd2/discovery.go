@@ -0,0 +1,229 @@
+package gobd2
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// VehicleTopology describes the ECUs that answer on the vehicle network.
+type VehicleTopology struct {
+	// Protocol is the adapter's description of the negotiated protocol.
+	Protocol string `json:"protocol"`
+	// ECUs lists every ECU that answered functional requests, ordered by address.
+	ECUs []ECUInfo `json:"ecus"`
+}
+
+// ECUInfo describes a single ECU found during discovery.
+type ECUInfo struct {
+	// Address is the header the ECU answers with, e.g. "7E8".
+	Address string `json:"address"`
+	// Name is the ECU name reported by mode 09 info type 0A, if supported.
+	Name string `json:"name,omitempty"`
+	// SupportedPIDs lists the mode 01 commands the ECU reports as supported.
+	SupportedPIDs []CommandCode `json:"supportedPids"`
+	// SupportedInfoTypes lists the mode 09 commands the ECU reports as supported.
+	SupportedInfoTypes []CommandCode `json:"supportedInfoTypes,omitempty"`
+}
+
+const (
+	modeCurrentData     byte = 0x01
+	modeVehicleInfo     byte = 0x09
+	positiveResponseBit byte = 0x40
+	infoTypeECUName     byte = 0x0A
+)
+
+// DiscoverTopology probes the vehicle using functional addressing and reports every ECU that answers, together
+// with the PIDs and info types it supports, its name and the protocol in use.
+//
+// Headers are enabled on the adapter for the duration of the discovery and restored afterwards: turned off again
+// unless the connector tracks the adapter's settings and they were on.
+func (cmd *Commander) DiscoverTopology() (*VehicleTopology, error) {
+	restore := restoreSetting(cmd.connector, settingHeaders, "ATH0")
+
+	if _, err := cmd.connector.SendCommand("ATH1"); err != nil {
+		return nil, fmt.Errorf("failed to enable headers: %w", err)
+	}
+	defer cmd.connector.SendCommand(restore) //nolint:errcheck
+
+	pids, err := cmd.supportedCommands(modeCurrentData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query supported PIDs: %w", err)
+	}
+
+	infoTypes, err := cmd.supportedCommands(modeVehicleInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query supported info types: %w", err)
+	}
+
+	protocol, err := NewELM327(cmd.connector).ProtocolDescription()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query protocol: %w", err)
+	}
+
+	topology := &VehicleTopology{Protocol: strings.TrimPrefix(protocol, "AUTO, ")}
+
+	addresses := make([]string, 0, len(pids))
+	for address := range pids {
+		addresses = append(addresses, address)
+	}
+
+	for address := range infoTypes {
+		if _, ok := pids[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+
+	slices.Sort(addresses)
+
+	names, err := cmd.ecuNames(infoTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ECU names: %w", err)
+	}
+
+	for _, address := range addresses {
+		topology.ECUs = append(topology.ECUs, ECUInfo{
+			Address:            address,
+			Name:               names[address],
+			SupportedPIDs:      commandCodes(modeCurrentData, pids[address]),
+			SupportedInfoTypes: commandCodes(modeVehicleInfo, infoTypes[address]),
+		})
+	}
+
+	return topology, nil
+}
+
+// queryECUs sends a functional request and returns the messages of every ECU that answered.
+func (cmd *Commander) queryECUs(command CommandCode) ([]ECUMessage, error) {
+	response, err := cmd.connector.SendCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err := parseFrames(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return assembleMessages(frames), nil
+}
+
+// supportedCommands walks the "supported PIDs" ranges of a mode and returns the PIDs each ECU supports.
+func (cmd *Commander) supportedCommands(mode byte) (map[string][]byte, error) {
+	return cmd.supportedRanges(mode, nil)
+}
+
+// supportedRanges walks the "supported PIDs" ranges of a mode, sending suffix after the PID of each request, and
+// returns the PIDs each ECU supports. A range is only requested if at least one ECU announced support for it in
+// the previous range.
+func (cmd *Commander) supportedRanges(mode byte, suffix []byte) (map[string][]byte, error) {
+	supported := map[string][]byte{}
+	offset := 2 + len(suffix)
+
+	for base := 0x00; base <= 0xE0; base += 0x20 {
+		request := append([]byte{mode, byte(base)}, suffix...)
+
+		messages, err := cmd.queryECUs(CommandCode(strings.ToUpper(hex.EncodeToString(request))))
+		if errors.Is(err, ErrNoData) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		next := false
+
+		for _, message := range messages {
+			if len(message.Data) < offset+4 || message.Data[0] != mode|positiveResponseBit || message.Data[1] != byte(base) {
+				continue
+			}
+
+			pids := decodeSupportedPIDs(byte(base), message.Data[offset:offset+4])
+			supported[message.Address] = append(supported[message.Address], pids...)
+			next = next || slices.Contains(pids, byte(base+0x20))
+		}
+
+		if !next {
+			break
+		}
+	}
+
+	return supported, nil
+}
+
+// ecuNames queries the name of every ECU that supports mode 09 info type 0A.
+func (cmd *Commander) ecuNames(infoTypes map[string][]byte) (map[string]string, error) {
+	names := map[string]string{}
+
+	supported := false
+	for _, types := range infoTypes {
+		supported = supported || slices.Contains(types, infoTypeECUName)
+	}
+
+	if !supported {
+		return names, nil
+	}
+
+	messages, err := cmd.queryECUs(ECUNameCommand)
+	if errors.Is(err, ErrNoData) {
+		return names, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for address, data := range infoRecords(messages, infoTypeECUName) {
+		names[address] = decodeInfoString(data)
+	}
+
+	return names, nil
+}
+
+// decodeSupportedPIDs decodes a four byte "supported PIDs" bitmap starting after base.
+func decodeSupportedPIDs(base byte, bitmap []byte) []byte {
+	var pids []byte
+
+	for i, b := range bitmap {
+		for bit := range 8 {
+			if b&(0x80>>bit) != 0 {
+				pids = append(pids, base+byte(i*8+bit+1))
+			}
+		}
+	}
+
+	return pids
+}
+
+// infoRecords extracts the data of a mode 09 info type from the messages of every ECU. CAN messages carry the
+// whole record after a count byte, while legacy protocols split it into numbered frames that are concatenated.
+func infoRecords(messages []ECUMessage, infoType byte) map[string][]byte {
+	records := map[string][]byte{}
+
+	for _, message := range messages {
+		if len(message.Data) < 3 || message.Data[0] != modeVehicleInfo|positiveResponseBit || message.Data[1] != infoType {
+			continue
+		}
+
+		records[message.Address] = append(records[message.Address], message.Data[3:]...)
+	}
+
+	return records
+}
+
+// decodeInfoString decodes an ASCII mode 09 record, dropping padding and NUL separators.
+func decodeInfoString(data []byte) string {
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", ""))
+}
+
+func commandCodes(mode byte, pids []byte) []CommandCode {
+	codes := make([]CommandCode, 0, len(pids))
+	for _, pid := range pids {
+		codes = append(codes, CommandCode(fmt.Sprintf("%02X%02X", mode, pid)))
+	}
+
+	return codes
+}
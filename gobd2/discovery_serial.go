@@ -0,0 +1,145 @@
+package gobd2
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// ErrDiscoveryUnsupported is returned when serial adapter discovery is not available on the platform.
+var ErrDiscoveryUnsupported = errors.New("serial adapter discovery is not supported on this platform")
+
+// SerialCandidate is a serial device that may be an OBD adapter.
+type SerialCandidate struct {
+	// Path is the device node, e.g. "/dev/ttyUSB0".
+	Path string
+	// Links lists stable aliases of the device, such as its /dev/serial/by-id entries.
+	Links []string
+	// VendorID and ProductID are the USB identifiers of the device, empty for non-USB devices.
+	VendorID  string
+	ProductID string
+	// Manufacturer and Product are the USB descriptor strings of the device.
+	Manufacturer string
+	Product      string
+	// Identification is the adapter's answer to ATI, empty if the device did not answer.
+	Identification string
+	// BaudRate is the rate the adapter answered at.
+	BaudRate int
+	// Score ranks how likely the device is an OBD adapter; higher is more likely.
+	Score int
+}
+
+// usbAdapterChips lists USB serial bridges commonly found in OBD adapters, by "vendor:product" ID.
+var usbAdapterChips = []string{
+	"0403:6001", // FTDI FT232R
+	"0403:6015", // FTDI FT231X, used by OBDLink adapters
+	"1a86:7523", // QinHeng CH340
+	"067b:2303", // Prolific PL2303
+	"10c4:ea60", // Silicon Labs CP210x
+}
+
+// adapterKeywords are words in device names that indicate an OBD adapter.
+var adapterKeywords = []string{"OBD", "ELM", "STN", "VLINKER", "VGATE", "SCANTOOL"}
+
+// SerialDiscovery finds OBD adapters connected as serial devices.
+type SerialDiscovery struct {
+	// DevDir and SysDir are the roots of the device and sysfs trees, "/dev" and "/sys" by default.
+	DevDir string
+	SysDir string
+	// Opener opens candidate ports for probing.
+	Opener SerialPortOpener
+	// BaudRates are the rates each candidate is probed at.
+	BaudRates []int
+}
+
+// NewSerialDiscovery creates a discovery that probes candidates through opener at common adapter baud rates.
+func NewSerialDiscovery(opener SerialPortOpener) *SerialDiscovery {
+	return &SerialDiscovery{
+		DevDir:    "/dev",
+		SysDir:    "/sys",
+		Opener:    opener,
+		BaudRates: commonBaudRates,
+	}
+}
+
+// DiscoverSerialAdapters finds the OBD adapters connected to this machine, most likely adapter first.
+func DiscoverSerialAdapters() ([]SerialCandidate, error) {
+	return NewSerialDiscovery(&RealPortOpener{}).Discover()
+}
+
+// Discover enumerates serial devices, probes each of them with ATI and returns them ranked by how likely they
+// are OBD adapters. Devices that did not answer are included with a lower rank.
+func (d *SerialDiscovery) Discover() ([]SerialCandidate, error) {
+	candidates, err := d.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		d.probe(&candidates[i])
+		candidates[i].Score = score(candidates[i])
+	}
+
+	slices.SortStableFunc(candidates, func(a, b SerialCandidate) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return candidates, nil
+}
+
+// probe asks the candidate for its identification at each of the configured baud rates.
+func (d *SerialDiscovery) probe(candidate *SerialCandidate) {
+	if len(d.BaudRates) == 0 {
+		return
+	}
+
+	sc := NewSerialConnector(candidate.Path, d.BaudRates[0], d.Opener, WithBaudDetection(d.BaudRates...))
+
+	id, err := sc.detectBaudRate()
+	if sc.connection != nil {
+		sc.Close() //nolint:errcheck
+	}
+
+	if err != nil {
+		return
+	}
+
+	candidate.Identification = id
+	candidate.BaudRate = sc.BaudRate()
+}
+
+// score ranks a candidate: an answer to ATI outweighs everything, followed by hints in the device names and
+// USB identifiers.
+func score(candidate SerialCandidate) int {
+	points := 0
+
+	if candidate.Identification != "" {
+		points += 100
+	}
+
+	names := strings.ToUpper(strings.Join(append([]string{candidate.Manufacturer, candidate.Product}, candidate.Links...), " "))
+	for _, keyword := range adapterKeywords {
+		if strings.Contains(names, keyword) {
+			points += 30
+
+			break
+		}
+	}
+
+	if slices.Contains(usbAdapterChips, candidate.VendorID+":"+candidate.ProductID) {
+		points += 20
+	}
+
+	switch {
+	case strings.Contains(candidate.Path, "rfcomm"):
+		points += 10
+	case strings.Contains(candidate.Path, "ttyUSB"):
+		points += 5
+	}
+
+	return points
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+package gobd2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serialDevicePatterns are the device nodes serial OBD adapters show up as.
+var serialDevicePatterns = []string{"ttyUSB*", "ttyACM*", "rfcomm*"}
+
+// candidates lists the serial devices under DevDir, enriched with their USB details from SysDir.
+func (d *SerialDiscovery) candidates() ([]SerialCandidate, error) {
+	var candidates []SerialCandidate
+
+	index := map[string]int{}
+
+	for _, pattern := range serialDevicePatterns {
+		paths, err := filepath.Glob(filepath.Join(d.DevDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			index[path] = len(candidates)
+			candidate := SerialCandidate{Path: path}
+			d.readUSBDetails(&candidate)
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	links, err := filepath.Glob(filepath.Join(d.DevDir, "serial", "by-id", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+
+		if i, ok := index[target]; ok {
+			candidates[i].Links = append(candidates[i].Links, link)
+		}
+	}
+
+	return candidates, nil
+}
+
+// readUSBDetails fills in the USB identifiers of the device by walking up its sysfs device path to the USB
+// device it belongs to.
+func (d *SerialDiscovery) readUSBDetails(candidate *SerialCandidate) {
+	device, err := filepath.EvalSymlinks(filepath.Join(d.SysDir, "class", "tty", filepath.Base(candidate.Path), "device"))
+	if err != nil {
+		return
+	}
+
+	root, err := filepath.EvalSymlinks(d.SysDir)
+	if err != nil {
+		return
+	}
+
+	for dir := device; strings.HasPrefix(dir, root) && dir != root; dir = filepath.Dir(dir) {
+		vendor := readSysfsAttribute(dir, "idVendor")
+		if vendor == "" {
+			continue
+		}
+
+		candidate.VendorID = vendor
+		candidate.ProductID = readSysfsAttribute(dir, "idProduct")
+		candidate.Manufacturer = readSysfsAttribute(dir, "manufacturer")
+		candidate.Product = readSysfsAttribute(dir, "product")
+
+		return
+	}
+}
+
+func readSysfsAttribute(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
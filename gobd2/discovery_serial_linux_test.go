@@ -0,0 +1,132 @@
+//go:build linux
+
+package gobd2_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/require"
+	"github.com/tarm/serial"
+)
+
+// silentSerialPort is a serial device that never answers.
+type silentSerialPort struct{}
+
+func (silentSerialPort) Read([]byte) (int, error)    { return 0, nil }
+func (silentSerialPort) Write(p []byte) (int, error) { return len(p), nil }
+func (silentSerialPort) Close() error                { return nil }
+
+// pathPortOpener opens the port registered for each device path.
+type pathPortOpener map[string]gobd2.SerialPort
+
+func (o pathPortOpener) OpenPort(config *serial.Config) (gobd2.SerialPort, error) {
+	port, ok := o[config.Name]
+	if !ok {
+		return nil, errors.New("no such device")
+	}
+
+	return port, nil
+}
+
+// writeSysfsDevice creates a fake sysfs entry for a USB serial device.
+func writeSysfsDevice(t *testing.T, sys, tty, usbDevice string, attributes map[string]string) {
+	t.Helper()
+
+	deviceDir := filepath.Join(sys, "devices", "pci0000:00", "usb1", usbDevice)
+	portDir := filepath.Join(deviceDir, usbDevice+":1.0", tty)
+	require.NoError(t, os.MkdirAll(portDir, 0o755))
+
+	for name, value := range attributes {
+		require.NoError(t, os.WriteFile(filepath.Join(deviceDir, name), []byte(value+"\n"), 0o600))
+	}
+
+	classDir := filepath.Join(sys, "class", "tty", tty)
+	require.NoError(t, os.MkdirAll(classDir, 0o755))
+	require.NoError(t, os.Symlink(portDir, filepath.Join(classDir, "device")))
+}
+
+func TestSerialDiscovery_Discover(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dev := filepath.Join(root, "dev")
+	sys := filepath.Join(root, "sys")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dev, "serial", "by-id"), 0o755))
+
+	for _, tty := range []string{"ttyUSB0", "ttyUSB1", "ttyACM0", "ttyS0"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dev, tty), nil, 0o600))
+	}
+
+	require.NoError(t, os.Symlink(filepath.Join(dev, "ttyUSB1"),
+		filepath.Join(dev, "serial", "by-id", "usb-FTDI_OBDLink_SX_1234-if00-port0")))
+
+	writeSysfsDevice(t, sys, "ttyUSB0", "1-1", map[string]string{
+		"idVendor": "1a86", "idProduct": "7523", "product": "USB Serial",
+	})
+	writeSysfsDevice(t, sys, "ttyUSB1", "1-2", map[string]string{
+		"idVendor": "0403", "idProduct": "6015", "manufacturer": "FTDI", "product": "OBDLink SX",
+	})
+	writeSysfsDevice(t, sys, "ttyACM0", "1-3", map[string]string{
+		"idVendor": "2341", "idProduct": "0043", "product": "Arduino Uno",
+	})
+
+	discovery := gobd2.NewSerialDiscovery(pathPortOpener{
+		filepath.Join(dev, "ttyUSB0"): newScriptedSerialPort(map[string]string{"ATI": "ELM327 v1.5"}),
+		filepath.Join(dev, "ttyUSB1"): silentSerialPort{},
+		filepath.Join(dev, "ttyACM0"): silentSerialPort{},
+	})
+	discovery.DevDir = dev
+	discovery.SysDir = sys
+	discovery.BaudRates = []int{38400}
+
+	candidates, err := discovery.Discover()
+	require.NoError(t, err)
+	require.Len(t, candidates, 3)
+
+	require.Equal(t, filepath.Join(dev, "ttyUSB0"), candidates[0].Path)
+	require.Equal(t, "ELM327 v1.5", candidates[0].Identification)
+	require.Equal(t, 38400, candidates[0].BaudRate)
+	require.Equal(t, "1a86", candidates[0].VendorID)
+
+	require.Equal(t, filepath.Join(dev, "ttyUSB1"), candidates[1].Path)
+	require.Empty(t, candidates[1].Identification)
+	require.Equal(t, "OBDLink SX", candidates[1].Product)
+	require.Equal(t, []string{filepath.Join(dev, "serial", "by-id", "usb-FTDI_OBDLink_SX_1234-if00-port0")},
+		candidates[1].Links)
+
+	require.Equal(t, filepath.Join(dev, "ttyACM0"), candidates[2].Path)
+	require.Equal(t, "Arduino Uno", candidates[2].Product)
+	require.Less(t, candidates[2].Score, candidates[1].Score)
+}
+
+func TestSerialDiscovery_ProbesConfiguredRates(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dev := filepath.Join(root, "dev")
+	sys := filepath.Join(root, "sys")
+
+	require.NoError(t, os.MkdirAll(dev, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dev, "ttyUSB0"), nil, 0o600))
+	writeSysfsDevice(t, sys, "ttyUSB0", "1-1", map[string]string{"idVendor": "1a86", "idProduct": "7523"})
+
+	for _, rates := range [][]int{{9600}, {9600, 115200}} {
+		adapter := &fakeBaudAdapter{baud: 38400}
+
+		discovery := gobd2.NewSerialDiscovery(adapter)
+		discovery.DevDir = dev
+		discovery.SysDir = sys
+		discovery.BaudRates = rates
+
+		candidates, err := discovery.Discover()
+		require.NoError(t, err)
+		require.Len(t, candidates, 1)
+		require.Empty(t, candidates[0].Identification)
+		require.Equal(t, rates, adapter.opened, "only the configured rates are tried")
+	}
+}
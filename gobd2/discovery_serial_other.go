@@ -0,0 +1,8 @@
+//go:build !linux
+
+package gobd2
+
+// candidates is not implemented outside of Linux.
+func (d *SerialDiscovery) candidates() ([]SerialCandidate, error) {
+	return nil, ErrDiscoveryUnsupported
+}
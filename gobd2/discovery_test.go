@@ -0,0 +1,87 @@
+package gobd2_test
+
+import (
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommander_DiscoverTopology(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	commander := gobd2.NewCommander(mockConnector)
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand1_20).Return(
+		"SEARCHING...\r7E8 06 41 00 BE 1F A8 13\r7E9 06 41 00 98 18 80 11", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand21_40).Return("7E8 06 41 20 80 00 00 00", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedInfoTypesCommand).Return("7E8 06 49 00 55 40 00 00", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATDP")).Return("AUTO, ISO 15765-4 (CAN 11/500)", nil)
+	mockConnector.On("SendCommand", gobd2.ECUNameCommand).Return(
+		"7E8 10 17 49 0A 01 45 43 4D\r7E8 21 00 2D 45 6E 67 69 6E\r7E8 22 65 43 6F 6E 74 72 6F\r7E8 23 6C 00 00 00 00 00 00", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH0")).Return("OK", nil)
+
+	topology, err := commander.DiscoverTopology()
+	require.NoError(t, err)
+
+	require.Equal(t, "ISO 15765-4 (CAN 11/500)", topology.Protocol)
+	require.Len(t, topology.ECUs, 2)
+
+	engine := topology.ECUs[0]
+	require.Equal(t, "7E8", engine.Address)
+	require.Equal(t, "ECM-EngineControl", engine.Name)
+	require.Contains(t, engine.SupportedPIDs, gobd2.EngineRPMCommand)
+	require.Contains(t, engine.SupportedPIDs, gobd2.CommandCode("0121"))
+	require.Equal(t, []gobd2.CommandCode{"0902", "0904", "0906", "0908", "090A"}, engine.SupportedInfoTypes)
+
+	transmission := topology.ECUs[1]
+	require.Equal(t, "7E9", transmission.Address)
+	require.Empty(t, transmission.Name)
+	require.Equal(t, []gobd2.CommandCode{"0101", "0104", "0105", "010C", "010D", "0111", "011C", "0120"}, transmission.SupportedPIDs)
+	require.Empty(t, transmission.SupportedInfoTypes)
+
+	mockConnector.AssertExpectations(t)
+}
+
+func TestCommander_DiscoverTopology_NoResponse(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	commander := gobd2.NewCommander(mockConnector)
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand1_20).Return("SEARCHING...\rUNABLE TO CONNECT", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH0")).Return("OK", nil)
+
+	_, err := commander.DiscoverTopology()
+	require.ErrorIs(t, err, gobd2.ErrUnableToConnect)
+	mockConnector.AssertExpectations(t)
+}
+
+func TestCommander_DiscoverTopology_RestoresHeaders(t *testing.T) {
+	t.Parallel()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+	profile.Headers = gobd2.ToggleOn
+
+	connector := gobd2.NewSerialConnector("emulator", 38400, &emulator.Opener{Vehicle: emulator.NewDemoVehicle()},
+		gobd2.WithInitProfile(profile))
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	_, err := gobd2.NewCommander(connector).DiscoverTopology()
+	require.NoError(t, err)
+
+	setting, ok := connector.AdapterSetting("ATH")
+	require.True(t, ok)
+	require.Equal(t, gobd2.CommandCode("ATH1"), setting)
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "7E8 03 41 0D 00", response, "headers stay on as the profile set them")
+}
@@ -0,0 +1,178 @@
+package gobd2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ELM327 provides typed access to adapter-level ELM327 commands, complementing the OBD requests sent through
+// Commander.
+type ELM327 struct {
+	connector Connector
+}
+
+// ProgrammableParameter is the state of one of the adapter's programmable parameters.
+type ProgrammableParameter struct {
+	Value   byte
+	Enabled bool
+}
+
+// NewELM327 creates a control API for the adapter behind connector.
+func NewELM327(connector Connector) *ELM327 {
+	return &ELM327{connector}
+}
+
+// Identify returns the adapter identification reported by ATI, e.g. "ELM327 v1.5".
+func (e *ELM327) Identify() (string, error) {
+	return e.query("ATI")
+}
+
+// DeviceDescription returns the device description reported by AT@1.
+func (e *ELM327) DeviceDescription() (string, error) {
+	return e.query("AT@1")
+}
+
+// Voltage returns the voltage measured at the adapter's OBD connector, as reported by ATRV.
+func (e *ELM327) Voltage() (float64, error) {
+	response, err := e.query("ATRV")
+	if err != nil {
+		return 0, err
+	}
+
+	voltage, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToUpper(response), "V"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: voltage %q", ErrUnexpectedResponse, response)
+	}
+
+	return voltage, nil
+}
+
+// CalibrateVoltage calibrates the adapter's voltage reading to the given, externally measured, voltage (ATCV).
+func (e *ELM327) CalibrateVoltage(volts float64) error {
+	hundredths := int(volts*100 + 0.5)
+	if volts <= 0 || hundredths < 1 || hundredths > 9999 {
+		return fmt.Errorf("calibration voltage %.2f V out of range", volts)
+	}
+
+	return e.setting(CommandCode(fmt.Sprintf("ATCV%04d", hundredths)))
+}
+
+// ResetVoltageCalibration restores the factory voltage calibration (ATCV0000).
+func (e *ELM327) ResetVoltageCalibration() error {
+	return e.setting("ATCV0000")
+}
+
+// ProtocolDescription returns the description of the current protocol reported by ATDP, e.g.
+// "AUTO, ISO 15765-4 (CAN 11/500)".
+func (e *ELM327) ProtocolDescription() (string, error) {
+	return e.query("ATDP")
+}
+
+// Protocol returns the current protocol reported by ATDPN.
+func (e *ELM327) Protocol() (Protocol, error) {
+	return queryProtocol(e.connector)
+}
+
+// WarmStart restarts the adapter without the power-on LED test (ATWS) and returns its identification.
+func (e *ELM327) WarmStart() (string, error) {
+	return e.query("ATWS")
+}
+
+// LowPower puts the adapter into low power mode (ATLP).
+func (e *ELM327) LowPower() error {
+	return e.setting("ATLP")
+}
+
+// MonitorAll reports every frame seen on the bus (ATMA) until ctx is canceled. The connector must implement
+// StreamingConnector, and headers should be enabled for the frames to carry their identifiers.
+func (e *ELM327) MonitorAll(ctx context.Context, handle func(Frame)) error {
+	return monitor(ctx, e.connector, "ATMA", handle)
+}
+
+// ProgrammableParameters returns the state of all programmable parameters, as reported by ATPPS.
+func (e *ELM327) ProgrammableParameters() (map[byte]ProgrammableParameter, error) {
+	response, err := e.connector.SendCommand("ATPPS")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+
+	parameters := map[byte]ProgrammableParameter{}
+
+	// The summary lists entries such as "00:FF F" or "2D:01 N", several per line.
+	fields := strings.Fields(strings.Join(responseLines(response), " "))
+	for i := 0; i+1 < len(fields); i += 2 {
+		number, value, ok := strings.Cut(fields[i], ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: programmable parameter %q", ErrUnexpectedResponse, fields[i])
+		}
+
+		pp, err := strconv.ParseUint(number, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: programmable parameter %q", ErrUnexpectedResponse, fields[i])
+		}
+
+		v, err := strconv.ParseUint(value, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: programmable parameter %q", ErrUnexpectedResponse, fields[i])
+		}
+
+		parameters[byte(pp)] = ProgrammableParameter{Value: byte(v), Enabled: fields[i+1] == "N"}
+	}
+
+	return parameters, nil
+}
+
+// SetProgrammableParameter sets the value of a programmable parameter (ATPP xx SV yy). The value only takes
+// effect once the parameter is enabled.
+func (e *ELM327) SetProgrammableParameter(pp, value byte) error {
+	return e.setting(CommandCode(fmt.Sprintf("ATPP%02XSV%02X", pp, value)))
+}
+
+// EnableProgrammableParameter enables or disables a programmable parameter (ATPP xx ON/OFF).
+func (e *ELM327) EnableProgrammableParameter(pp byte, enabled bool) error {
+	state := "OFF"
+	if enabled {
+		state = "ON"
+	}
+
+	return e.setting(CommandCode(fmt.Sprintf("ATPP%02X%s", pp, state)))
+}
+
+// query sends an informational command and returns the last line of its answer.
+func (e *ELM327) query(command CommandCode) (string, error) {
+	response, err := e.connector.SendCommand(command)
+	if err != nil {
+		return "", err
+	}
+
+	if err := responseError(response); err != nil {
+		return "", fmt.Errorf("%s: %w", command, err)
+	}
+
+	lines := responseLines(response)
+	if len(lines) == 0 {
+		return "", fmt.Errorf("%s: %w: empty response", command, ErrUnexpectedResponse)
+	}
+
+	return lines[len(lines)-1], nil
+}
+
+// setting sends a command that the adapter acknowledges with "OK".
+func (e *ELM327) setting(command CommandCode) error {
+	response, err := e.query(command)
+	if err != nil {
+		return err
+	}
+
+	if response != "OK" {
+		return fmt.Errorf("%s: %w %q", command, ErrUnexpectedResponse, response)
+	}
+
+	return nil
+}
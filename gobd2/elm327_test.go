@@ -0,0 +1,81 @@
+package gobd2_test
+
+import (
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestELM327_Queries(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	elm := gobd2.NewELM327(mockConnector)
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATI")).Return("ELM327 v2.1", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("AT@1")).Return("OBDII to RS232 Interpreter", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATRV")).Return("12.6V", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATDP")).Return("AUTO, SAE J1850 VPW", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATDPN")).Return("A2", nil)
+
+	id, err := elm.Identify()
+	require.NoError(t, err)
+	require.Equal(t, "ELM327 v2.1", id)
+
+	description, err := elm.DeviceDescription()
+	require.NoError(t, err)
+	require.Equal(t, "OBDII to RS232 Interpreter", description)
+
+	voltage, err := elm.Voltage()
+	require.NoError(t, err)
+	require.InDelta(t, 12.6, voltage, 0.001)
+
+	protocolDescription, err := elm.ProtocolDescription()
+	require.NoError(t, err)
+	require.Equal(t, "AUTO, SAE J1850 VPW", protocolDescription)
+
+	protocol, err := elm.Protocol()
+	require.NoError(t, err)
+	require.Equal(t, gobd2.ProtocolJ1850VPW, protocol)
+
+	mockConnector.AssertExpectations(t)
+}
+
+func TestELM327_Settings(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	elm := gobd2.NewELM327(mockConnector)
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATCV1248")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATPP0CSV08")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATPP0CON")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATLP")).Return("?", nil)
+
+	require.NoError(t, elm.CalibrateVoltage(12.48))
+	require.NoError(t, elm.SetProgrammableParameter(0x0C, 0x08))
+	require.NoError(t, elm.EnableProgrammableParameter(0x0C, true))
+	require.ErrorIs(t, elm.LowPower(), gobd2.ErrUnknownCommand)
+	require.Error(t, elm.CalibrateVoltage(120))
+	require.Error(t, elm.CalibrateVoltage(99.996), "rounds to a five digit argument")
+	require.Error(t, elm.CalibrateVoltage(0.004), "rounds to the factory calibration")
+
+	mockConnector.AssertExpectations(t)
+}
+
+func TestELM327_ProgrammableParameters(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	elm := gobd2.NewELM327(mockConnector)
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATPPS")).Return(
+		"00:FF F  01:FF F  02:FF F  03:32 F\r04:01 F  05:FF F  06:F1 F  07:09 F\r0C:08 N  0D:0D F  0E:9A F  0F:FF F", nil)
+
+	parameters, err := elm.ProgrammableParameters()
+	require.NoError(t, err)
+	require.Len(t, parameters, 12)
+	require.Equal(t, gobd2.ProgrammableParameter{Value: 0x08, Enabled: true}, parameters[0x0C])
+	require.Equal(t, gobd2.ProgrammableParameter{Value: 0x32, Enabled: false}, parameters[0x03])
+}
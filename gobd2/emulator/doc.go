@@ -0,0 +1,12 @@
+// Package emulator implements an ELM327 adapter in software, connected to a virtual vehicle instead of a real
+// OBD-II port.
+//
+// The emulated adapter understands the AT commands used by gobd2 and common diagnostic apps, honors the echo,
+// linefeed, space and header settings, frames responses the way a real adapter does and ends every answer with
+// the ">" prompt. OBD requests are answered by a Vehicle, so tests can plug in anything from a static table to a
+// full simulation.
+//
+// The adapter can be reached in-process through Port, which plugs into gobd2.SerialConnector via Opener, over TCP
+// like a WiFi adapter with Serve and ListenAndServe, or on Linux through a pseudo terminal opened with OpenPTY that
+// third-party apps use like a serial port.
+package emulator
@@ -0,0 +1,547 @@
+package emulator
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+)
+
+const (
+	defaultIdentification = "ELM327 v2.1"
+	deviceDescription     = "OBDII to RS232 Interpreter"
+	defaultVoltage        = "12.6V"
+	// maxFrameData is the number of data bytes of a single CAN frame after the PCI byte.
+	maxFrameData = 7
+)
+
+// Option configures an emulated adapter.
+type Option func(*ELM327)
+
+// WithIdentification makes the adapter identify itself with id (ATI), e.g. "ELM327 v1.5" to pose as a clone.
+func WithIdentification(id string) Option {
+	return func(e *ELM327) {
+		e.identification = id
+	}
+}
+
+// WithVoltage sets the supply voltage the adapter reports (ATRV), e.g. "11.9V".
+func WithVoltage(voltage string) Option {
+	return func(e *ELM327) {
+		e.voltage = voltage
+	}
+}
+
+// ELM327 is a single emulated adapter. Every connection to the emulator gets its own adapter with its own
+// settings, while several adapters may share a Vehicle.
+type ELM327 struct {
+	mu             sync.Mutex
+	vehicle        Vehicle
+	identification string
+	voltage        string
+	settings       settings
+	lastCommand    string
+	faults         *FaultInjector
+	// truncate cuts the responses to the current request after their first frame.
+	truncate bool
+}
+
+// maxRequestBytes is the longest request the adapter sends, in a single frame. Longer ones are answered with "?".
+const maxRequestBytes = 7
+
+// settings is the state changed by AT commands and restored by ATZ, ATWS and ATD.
+type settings struct {
+	echo      bool
+	linefeeds bool
+	spaces    bool
+	headers   bool
+	caf       bool
+	// responses is cleared with ATR0 to send requests without waiting for answers.
+	responses bool
+	// protocol is the protocol selected with ATSP/ATTP, ProtocolAuto to search for one.
+	protocol gobd2.Protocol
+	// search allows falling back to a protocol search if the selected protocol does not connect.
+	search bool
+	// connected is the protocol found on the vehicle, ProtocolAuto until the first request.
+	connected gobd2.Protocol
+	// header is the request header set with ATSH, zero for the protocol's functional header.
+	header uint32
+	// priority is the first byte of 29-bit headers set with three bytes, changed with ATCP.
+	priority byte
+	// receiveFilter is the CAN identifier set with ATCRA, empty to receive all.
+	receiveFilter string
+}
+
+// New creates an adapter connected to vehicle.
+func New(vehicle Vehicle, opts ...Option) *ELM327 {
+	e := &ELM327{
+		vehicle:        vehicle,
+		identification: defaultIdentification,
+		voltage:        defaultVoltage,
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	e.settings = defaultSettings()
+
+	return e
+}
+
+func defaultSettings() settings {
+	return settings{echo: true, spaces: true, caf: true, responses: true, search: true, priority: 0x18}
+}
+
+// Execute runs a single command line, without the terminating carriage return, and returns everything the adapter
+// sends back: the echo, the answer and the prompt.
+func (e *ELM327) Execute(line string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	command := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(line), " ", ""))
+	if command == "" {
+		// An empty line repeats the last request.
+		command = e.lastCommand
+	}
+
+	var out strings.Builder
+
+	if e.settings.echo {
+		out.WriteString(line + "\r")
+	}
+
+	faults := e.faults.next(command != "" && !strings.HasPrefix(command, "AT") && isHex(command))
+	e.truncate = faults[FaultTruncated]
+
+	var lines []string
+
+	switch {
+	case faults[FaultLowVoltageReset]:
+		e.settings = defaultSettings()
+		lines = []string{"", "LV RESET", "", e.identification}
+	case faults[FaultNoData]:
+		lines = []string{"NO DATA"}
+	case faults[FaultCANError]:
+		lines = []string{"CAN ERROR"}
+	default:
+		lines = e.execute(command)
+	}
+
+	eol := "\r"
+	if e.settings.linefeeds {
+		eol = "\r\n"
+	}
+
+	for _, l := range lines {
+		out.WriteString(l + eol)
+	}
+
+	out.WriteString(eol)
+
+	if !faults[FaultDroppedPrompt] {
+		out.WriteString(">")
+	}
+
+	if faults[FaultLatency] {
+		time.Sleep(e.faults.delay())
+	}
+
+	if faults[FaultGarbage] {
+		return e.faults.garbage() + out.String()
+	}
+
+	return out.String()
+}
+
+// Serve runs the adapter over rw, reading commands terminated by carriage returns and writing the answers, until
+// rw is closed.
+func (e *ELM327) Serve(rw io.ReadWriter) error {
+	buf := make([]byte, 256)
+
+	var line []byte
+
+	for {
+		n, err := rw.Read(buf)
+
+		for _, b := range buf[:n] {
+			switch b {
+			case '\r':
+				if _, err := io.WriteString(rw, e.Execute(string(line))); err != nil {
+					return err
+				}
+
+				line = line[:0]
+			case '\n':
+			default:
+				line = append(line, b)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *ELM327) execute(command string) []string {
+	switch {
+	case command == "":
+		return nil
+	case strings.HasPrefix(command, "AT"):
+		return e.at(command[2:])
+	case isHex(command):
+		e.lastCommand = command
+
+		return e.request(command)
+	}
+
+	return []string{"?"}
+}
+
+// at runs an AT command, given without its "AT" prefix.
+func (e *ELM327) at(command string) []string {
+	s := &e.settings
+
+	switch command {
+	case "Z", "WS":
+		*s = defaultSettings()
+
+		return []string{e.identification}
+	case "D":
+		*s = defaultSettings()
+	case "I":
+		return []string{e.identification}
+	case "@1":
+		return []string{deviceDescription}
+	case "RV":
+		return []string{e.voltage}
+	case "E0", "E1":
+		s.echo = command == "E1"
+	case "L0", "L1":
+		s.linefeeds = command == "L1"
+	case "S0", "S1":
+		s.spaces = command == "S1"
+	case "H0", "H1":
+		s.headers = command == "H1"
+	case "R0", "R1":
+		s.responses = command == "R1"
+	case "CAF0", "CAF1":
+		s.caf = command == "CAF1"
+	case "AT0", "AT1", "AT2", "LP", "AR", "M0", "M1":
+	case "CRA":
+		s.receiveFilter = ""
+	case "DP":
+		return []string{e.protocolDescription()}
+	case "DPN":
+		return []string{e.protocolNumber()}
+	case "PPS":
+		return []string{"00:FF F  01:FF F  02:FF F  03:32 F", "04:01 F  05:FF F  06:F1 F  07:09 F"}
+	default:
+		return e.atWithArgument(command)
+	}
+
+	return []string{"OK"}
+}
+
+// atWithArgument runs the AT commands that take an argument.
+func (e *ELM327) atWithArgument(command string) []string {
+	s := &e.settings
+
+	switch {
+	case strings.HasPrefix(command, "SP") || strings.HasPrefix(command, "TP"):
+		argument := command[2:]
+		search := command[:2] == "TP" || strings.HasPrefix(argument, "A")
+
+		protocol, err := gobd2.ParseProtocol(argument)
+		if err != nil {
+			return []string{"?"}
+		}
+
+		s.protocol = protocol
+		s.search = search || protocol == gobd2.ProtocolAuto
+		s.connected = gobd2.ProtocolAuto
+	case strings.HasPrefix(command, "SH"):
+		header, err := strconv.ParseUint(command[2:], 16, 32)
+		if err != nil || !slices.Contains([]int{3, 6, 8}, len(command[2:])) {
+			return []string{"?"}
+		}
+
+		s.header = uint32(header)
+	case strings.HasPrefix(command, "CP"):
+		priority, err := strconv.ParseUint(command[2:], 16, 5)
+		if err != nil || len(command) != 4 {
+			return []string{"?"}
+		}
+
+		s.priority = byte(priority)
+	case strings.HasPrefix(command, "CRA"):
+		if !isHex(command[3:]) {
+			return []string{"?"}
+		}
+
+		s.receiveFilter = command[3:]
+	case strings.HasPrefix(command, "ST") && isHex(command[2:]) && len(command) == 4,
+		strings.HasPrefix(command, "CV") && len(command) == 6,
+		strings.HasPrefix(command, "PP") && len(command) > 4:
+	default:
+		return []string{"?"}
+	}
+
+	return []string{"OK"}
+}
+
+// request sends an OBD request to the vehicle and formats the answers.
+func (e *ELM327) request(command string) []string {
+	if len(command)%2 == 1 {
+		// A trailing digit limits the number of responses to wait for.
+		command = command[:len(command)-1]
+	}
+
+	data, err := hex.DecodeString(command)
+	if err != nil || len(data) == 0 || len(data) > maxRequestBytes {
+		return []string{"?"}
+	}
+
+	var lines []string
+
+	protocol := e.vehicle.Protocol()
+	if e.settings.connected == gobd2.ProtocolAuto {
+		if e.settings.protocol != protocol && !e.settings.search {
+			return []string{"UNABLE TO CONNECT"}
+		}
+
+		if e.settings.protocol != protocol {
+			lines = append(lines, "SEARCHING...")
+		}
+
+		e.settings.connected = protocol
+	}
+
+	header := e.settings.header
+
+	switch {
+	case header == 0:
+		header = functionalHeader(protocol)
+	case protocol.Is29Bit() && header <= 0xFFFFFF:
+		header |= uint32(e.settings.priority) << 24
+	}
+
+	responses := e.vehicle.Handle(Request{Header: header, Data: data})
+	if !e.settings.responses {
+		return lines
+	}
+
+	answered := false
+
+	for _, response := range responses {
+		if e.settings.receiveFilter != "" && formatHeader(protocol, response.Header, false) != e.settings.receiveFilter {
+			continue
+		}
+
+		lines = append(lines, e.format(protocol, response)...)
+		answered = true
+	}
+
+	if !answered {
+		lines = append(lines, "NO DATA")
+	}
+
+	return lines
+}
+
+// format renders a response the way the adapter prints it with the current settings.
+func (e *ELM327) format(protocol gobd2.Protocol, response Response) []string {
+	if !protocol.IsCAN() {
+		return e.formatLegacy(protocol, response)
+	}
+
+	frames := segment(response.Data)
+	if e.truncate {
+		frames = frames[:1]
+	}
+	header := formatHeader(protocol, response.Header, e.settings.spaces)
+	lines := make([]string, 0, len(frames)+1)
+
+	switch {
+	case e.settings.headers:
+		for _, frame := range frames {
+			lines = append(lines, e.join(header, e.bytes(frame)))
+		}
+	case !e.settings.caf:
+		for _, frame := range frames {
+			lines = append(lines, e.bytes(frame))
+		}
+	case len(frames) == 1:
+		lines = append(lines, e.bytes(response.Data))
+	default:
+		// Without headers, segmented messages are printed as their length followed by numbered lines.
+		lines = append(lines, fmt.Sprintf("%03X", len(response.Data)))
+		for i, frame := range frames {
+			payload := frame[1:]
+			if i == 0 {
+				payload = frame[2:]
+			}
+
+			lines = append(lines, fmt.Sprintf("%X:", i%16)+" "+e.bytes(payload))
+		}
+	}
+
+	return lines
+}
+
+// formatLegacy renders a response on J1850, ISO 9141 or KWP. Records longer than a frame are split into frames
+// numbered in place of the item count, the way mode 09 answers are sent on these protocols.
+func (e *ELM327) formatLegacy(protocol gobd2.Protocol, response Response) []string {
+	frames := [][]byte{response.Data}
+	if len(response.Data) > maxFrameData {
+		frames = nil
+		for i, sequence := 3, 1; i < len(response.Data); i, sequence = i+4, sequence+1 {
+			frame := []byte{response.Data[0], response.Data[1], byte(sequence)}
+			frames = append(frames, append(frame, response.Data[i:min(i+4, len(response.Data))]...))
+		}
+
+		if e.truncate {
+			frames = frames[:1]
+		}
+	}
+
+	priority := byte(0x48)
+	if protocol == gobd2.ProtocolJ1850PWM {
+		priority = 0x41
+	}
+
+	lines := make([]string, 0, len(frames))
+
+	for _, frame := range frames {
+		if !e.settings.headers {
+			lines = append(lines, e.bytes(frame))
+
+			continue
+		}
+
+		message := append([]byte{priority, 0x6B, byte(response.Header)}, frame...)
+
+		var checksum byte
+		for _, b := range message {
+			checksum += b
+		}
+
+		lines = append(lines, e.bytes(append(message, checksum)))
+	}
+
+	return lines
+}
+
+// segment splits a message into ISO-TP frames, each starting with its PCI bytes.
+func segment(data []byte) [][]byte {
+	if len(data) <= maxFrameData {
+		return [][]byte{append([]byte{byte(len(data))}, data...)}
+	}
+
+	frames := [][]byte{append([]byte{0x10 | byte(len(data)>>8), byte(len(data))}, data[:maxFrameData-1]...)}
+
+	for i, sequence := maxFrameData-1, 1; i < len(data); i, sequence = i+maxFrameData, sequence+1 {
+		frames = append(frames, append([]byte{0x20 | byte(sequence%16)}, data[i:min(i+maxFrameData, len(data))]...))
+	}
+
+	return frames
+}
+
+// bytes renders data as hex, separated by spaces if they are enabled.
+func (e *ELM327) bytes(data []byte) string {
+	fields := make([]string, len(data))
+	for i, b := range data {
+		fields[i] = fmt.Sprintf("%02X", b)
+	}
+
+	if e.settings.spaces {
+		return strings.Join(fields, " ")
+	}
+
+	return strings.Join(fields, "")
+}
+
+func (e *ELM327) join(fields ...string) string {
+	if e.settings.spaces {
+		return strings.Join(fields, " ")
+	}
+
+	return strings.Join(fields, "")
+}
+
+func (e *ELM327) protocolDescription() string {
+	s := e.settings
+
+	protocol := s.connected
+	if protocol == gobd2.ProtocolAuto {
+		protocol = s.protocol
+	}
+
+	if s.search && protocol != gobd2.ProtocolAuto {
+		return "AUTO, " + protocol.Description()
+	}
+
+	return protocol.Description()
+}
+
+func (e *ELM327) protocolNumber() string {
+	s := e.settings
+
+	protocol := s.connected
+	if protocol == gobd2.ProtocolAuto {
+		protocol = s.protocol
+	}
+
+	if s.search {
+		return fmt.Sprintf("A%X", byte(protocol))
+	}
+
+	return fmt.Sprintf("%X", byte(protocol))
+}
+
+// functionalHeader returns the header requests are sent with until one is set with ATSH.
+func functionalHeader(protocol gobd2.Protocol) uint32 {
+	switch {
+	case !protocol.IsCAN():
+		return FunctionalHeaderJ1850
+	case protocol.Is29Bit():
+		return FunctionalHeader29Bit
+	}
+
+	return FunctionalHeader11Bit
+}
+
+// formatHeader renders the identifier of a CAN response, e.g. "7E8" or "18 DA F1 10".
+func formatHeader(protocol gobd2.Protocol, header uint32, spaces bool) string {
+	if !protocol.Is29Bit() {
+		return fmt.Sprintf("%03X", header)
+	}
+
+	if !spaces {
+		return fmt.Sprintf("%08X", header)
+	}
+
+	return fmt.Sprintf("%02X %02X %02X %02X", byte(header>>24), byte(header>>16), byte(header>>8), byte(header))
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789ABCDEF", c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,199 @@
+package emulator_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+// connectEmulator connects a serial connector to an emulated adapter for vehicle.
+func connectEmulator(t *testing.T, vehicle emulator.Vehicle, opts ...emulator.Option) *gobd2.SerialConnector {
+	t.Helper()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	connector := gobd2.NewSerialConnector("emulator", 38400, &emulator.Opener{Vehicle: vehicle, Options: opts},
+		gobd2.WithInitProfile(profile))
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	return connector
+}
+
+func TestELM327_Execute_Settings(t *testing.T) {
+	t.Parallel()
+
+	adapter := emulator.New(emulator.NewDemoVehicle())
+
+	require.Equal(t, "ATZ\rELM327 v2.1\r\r>", adapter.Execute("ATZ"))
+	require.Equal(t, "ATE0\rOK\r\r>", adapter.Execute("ATE0"))
+	require.Equal(t, "SEARCHING...\r41 0C 0C 80\r\r>", adapter.Execute("010C"))
+	require.Equal(t, "41 0C 0C 80\r\r>", adapter.Execute(""), "an empty line repeats the last request")
+	require.Equal(t, "OK\r\n\r\n>", adapter.Execute("ATL1"))
+	require.Equal(t, "OK\r\n\r\n>", adapter.Execute("ATS0"))
+	require.Equal(t, "410C0C80\r\n\r\n>", adapter.Execute("010C"))
+	require.Equal(t, "OK\r\n\r\n>", adapter.Execute("ATH1"))
+	require.Equal(t, "7E804410C0C80\r\n\r\n>", adapter.Execute("010C"))
+	require.Equal(t, "?\r\n\r\n>", adapter.Execute("ATXYZ"))
+	require.Equal(t, "AUTO, ISO 15765-4 (CAN 11/500)\r\n\r\n>", adapter.Execute("ATDP"))
+	require.Equal(t, "A6\r\n\r\n>", adapter.Execute("ATDPN"))
+}
+
+func TestELM327_Execute_SegmentedMessages(t *testing.T) {
+	t.Parallel()
+
+	adapter := emulator.New(emulator.NewDemoVehicle())
+	adapter.Execute("ATE0")
+
+	require.Equal(t, "SEARCHING...\r014\r0: 49 02 01 31 47 4F\r1: 42 44 32 45 4D 55 4C\r2: 41 54 4F 52 30 30 31\r\r>",
+		adapter.Execute("0902"))
+
+	adapter.Execute("ATH1")
+	require.Equal(t, "7E8 10 14 49 02 01 31 47 4F\r7E8 21 42 44 32 45 4D 55 4C\r7E8 22 41 54 4F 52 30 30 31\r\r>",
+		adapter.Execute("0902"))
+}
+
+func TestELM327_Execute_Addressing(t *testing.T) {
+	t.Parallel()
+
+	adapter := emulator.New(emulator.NewDemoVehicle())
+	adapter.Execute("ATE0")
+	adapter.Execute("ATH1")
+
+	require.Equal(t, "SEARCHING...\r7E8 06 41 00 98 1B 80 11\r7E9 06 41 00 80 00 00 00\r\r>", adapter.Execute("0100"))
+
+	adapter.Execute("ATSH7E1")
+	require.Equal(t, "7E9 03 7F 22 11\r\r>", adapter.Execute("22F190"), "physical requests get negative responses")
+
+	adapter.Execute("ATSH7DF")
+	require.Equal(t, "NO DATA\r\r>", adapter.Execute("22F190"), "functional requests are ignored")
+
+	adapter.Execute("ATCRA7E9")
+	require.Equal(t, "7E9 06 41 00 80 00 00 00\r\r>", adapter.Execute("0100"))
+}
+
+func TestELM327_Execute_LongRequests(t *testing.T) {
+	t.Parallel()
+
+	adapter := emulator.New(emulator.NewDemoVehicle())
+	adapter.Execute("ATE0")
+	adapter.Execute("ATSH7E0")
+
+	require.Equal(t, "?\r\r>", adapter.Execute("2EF19031474F4244"), "8 bytes do not fit a single frame")
+	require.Equal(t, "SEARCHING...\r7F 2E 11\r\r>", adapter.Execute("2EF19031474F42"))
+}
+
+func TestELM327_Execute_DiagnosticSession(t *testing.T) {
+	t.Parallel()
+
+	adapter := emulator.New(emulator.NewDemoVehicle())
+	adapter.Execute("ATE0")
+	adapter.Execute("ATSH7E0")
+
+	require.Equal(t, "SEARCHING...\r50 03 00 32 01 F4\r\r>", adapter.Execute("1003"))
+	require.Equal(t, "7E 00\r\r>", adapter.Execute("3E00"))
+	require.Equal(t, "NO DATA\r\r>", adapter.Execute("3E80"), "the positive response is suppressed")
+
+	adapter.Execute("ATR0")
+	require.Equal(t, "\r>", adapter.Execute("0100"), "responses are off")
+}
+
+func TestELM327_Execute_ForcedProtocol(t *testing.T) {
+	t.Parallel()
+
+	adapter := emulator.New(emulator.NewDemoVehicle())
+	adapter.Execute("ATE0")
+	adapter.Execute("ATSP3")
+
+	require.Equal(t, "UNABLE TO CONNECT\r\r>", adapter.Execute("0100"))
+	require.Equal(t, "ISO 9141-2\r\r>", adapter.Execute("ATDP"))
+
+	adapter.Execute("ATSP6")
+	require.Equal(t, "41 0D 00\r\r>", adapter.Execute("010D"))
+}
+
+func TestELM327_Execute_LegacyProtocol(t *testing.T) {
+	t.Parallel()
+
+	vehicle := emulator.NewStaticVehicle(gobd2.ProtocolISO9141, &emulator.ECU{
+		Address:   0x10,
+		PIDs:      map[byte][]byte{0x0D: {0x32}},
+		InfoTypes: map[byte][]byte{0x02: []byte("WVWZZZ1JZXW000001")},
+		DTCs:      []string{"P0301", "U0100"},
+	})
+
+	adapter := emulator.New(vehicle)
+	adapter.Execute("ATE0")
+	adapter.Execute("ATH1")
+
+	require.Equal(t, "SEARCHING...\r48 6B 10 41 0D 32 43\r\r>", adapter.Execute("010D"))
+	require.Equal(t, "48 6B 10 43 03 01 C1 00 00 00 CB\r\r>", adapter.Execute("03"))
+	first, _, _ := strings.Cut(adapter.Execute("0902"), "\r")
+	require.Equal(t, "48 6B 10 49 02 01 57 56 57 5A 6D", first)
+}
+
+func TestELM327_Execute_29Bit(t *testing.T) {
+	t.Parallel()
+
+	vehicle := emulator.NewStaticVehicle(gobd2.ProtocolCAN29Bit500, &emulator.ECU{
+		Address: 0x18DAF110,
+		PIDs:    map[byte][]byte{0x0D: {0x32}},
+	})
+
+	adapter := emulator.New(vehicle)
+	adapter.Execute("ATE0")
+	adapter.Execute("ATH1")
+
+	require.Equal(t, "SEARCHING...\r18 DA F1 10 03 41 0D 32\r\r>", adapter.Execute("010D"))
+
+	adapter.Execute("ATSH18DA10F1")
+	require.Equal(t, "18 DA F1 10 03 41 0D 32\r\r>", adapter.Execute("010D"))
+}
+
+func TestOpener_SerialConnector(t *testing.T) {
+	t.Parallel()
+
+	connector := connectEmulator(t, emulator.NewDemoVehicle())
+
+	response, err := gobd2.NewCommander(connector).ExecuteCommand(gobd2.EngineRPMCommand)
+	require.NoError(t, err)
+	require.Equal(t, "SEARCHING...\r41 0C 0C 80", response)
+
+	topology, err := gobd2.NewCommander(connector).DiscoverTopology()
+	require.NoError(t, err)
+	require.Equal(t, "ISO 15765-4 (CAN 11/500)", topology.Protocol)
+	require.Len(t, topology.ECUs, 2)
+	require.Equal(t, "ECM-EngineControl", topology.ECUs[0].Name)
+	require.Contains(t, topology.ECUs[0].SupportedPIDs, gobd2.CommandCode("0146"))
+	require.Equal(t, "TCM-TransmissionCtl", topology.ECUs[1].Name)
+
+	fingerprint, err := gobd2.NewELM327(connector).Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, gobd2.ChipGenuineELM327, fingerprint.Family)
+	require.True(t, fingerprint.Capabilities.Has(gobd2.CapabilityCANReceiveFilter|gobd2.CapabilityMultiPID))
+}
+
+func TestOpener_LegacyDiscovery(t *testing.T) {
+	t.Parallel()
+
+	vehicle := emulator.NewStaticVehicle(gobd2.ProtocolJ1850VPW, &emulator.ECU{
+		Address:   0x10,
+		PIDs:      map[byte][]byte{0x0C: {0x0C, 0x80}},
+		InfoTypes: map[byte][]byte{0x0A: []byte("PCM\x00-PowertrainCtl\x00")},
+	})
+
+	topology, err := gobd2.NewCommander(connectEmulator(t, vehicle)).DiscoverTopology()
+	require.NoError(t, err)
+	require.Equal(t, "SAE J1850 VPW", topology.Protocol)
+	require.Equal(t, []gobd2.ECUInfo{{
+		Address:            "486B10",
+		Name:               "PCM-PowertrainCtl",
+		SupportedPIDs:      []gobd2.CommandCode{"010C"},
+		SupportedInfoTypes: []gobd2.CommandCode{"090A"},
+	}}, topology.ECUs)
+}
@@ -0,0 +1,164 @@
+package emulator
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// AdapterFault is a kind of misbehavior injected into an emulated adapter, modeled after flaky dongles.
+type AdapterFault int
+
+// Faults an emulated adapter can inject.
+const (
+	// FaultNoData answers an OBD request with "NO DATA" instead of the vehicle's response.
+	FaultNoData AdapterFault = iota
+	// FaultCANError answers an OBD request with "CAN ERROR".
+	FaultCANError
+	// FaultLatency delays the answer by the injector's latency.
+	FaultLatency
+	// FaultDroppedPrompt sends the answer without the ">" prompt.
+	FaultDroppedPrompt
+	// FaultTruncated cuts multi-frame responses after their first frame.
+	FaultTruncated
+	// FaultLowVoltageReset resets the adapter while it handles the command, answering "LV RESET" and losing all
+	// settings.
+	FaultLowVoltageReset
+	// FaultGarbage sends line noise before the answer.
+	FaultGarbage
+)
+
+var adapterFaultNames = map[AdapterFault]string{
+	FaultNoData:          "no data",
+	FaultCANError:        "CAN error",
+	FaultLatency:         "latency",
+	FaultDroppedPrompt:   "dropped prompt",
+	FaultTruncated:       "truncated response",
+	FaultLowVoltageReset: "low voltage reset",
+	FaultGarbage:         "garbage",
+}
+
+func (f AdapterFault) String() string {
+	if name, ok := adapterFaultNames[f]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("AdapterFault(%d)", int(f))
+}
+
+// requestOnly reports whether the fault only applies to OBD requests, not to AT commands.
+func (f AdapterFault) requestOnly() bool {
+	return f == FaultNoData || f == FaultCANError || f == FaultTruncated
+}
+
+// defaultLatency is the delay of FaultLatency unless configured otherwise.
+const defaultLatency = 2 * time.Second
+
+// FaultInjector decides which faults an emulated adapter injects. Faults are either scripted, to happen on the
+// next commands they apply to, or random, with a probability per command. It is safe for concurrent use, so tests
+// can script faults while a connector talks to the adapter.
+type FaultInjector struct {
+	mu            sync.Mutex
+	random        *rand.Rand
+	probabilities map[AdapterFault]float64
+	script        []AdapterFault
+	latency       time.Duration
+}
+
+// NewFaultInjector creates an injector without any faults. Random faults are drawn from a generator seeded with
+// seed, so a failing test can be reproduced.
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		random:        rand.New(rand.NewSource(seed)), //nolint:gosec // reproducible noise, not security
+		probabilities: map[AdapterFault]float64{},
+		latency:       defaultLatency,
+	}
+}
+
+// WithFaultInjector makes the adapter inject the faults decided by injector.
+func WithFaultInjector(injector *FaultInjector) Option {
+	return func(e *ELM327) {
+		e.faults = injector
+	}
+}
+
+// Inject scripts faults to happen once each, in the order given, one per command. Faults concerning the vehicle's
+// response wait for the next OBD request, AT commands sent in the meantime are answered normally.
+func (f *FaultInjector) Inject(faults ...AdapterFault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.script = append(f.script, faults...)
+}
+
+// SetProbability makes fault happen randomly, on the given fraction of the commands it applies to. A probability
+// of zero disables it.
+func (f *FaultInjector) SetProbability(fault AdapterFault, probability float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.probabilities[fault] = probability
+}
+
+// SetLatency sets the delay injected by FaultLatency.
+func (f *FaultInjector) SetLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = latency
+}
+
+// Pending returns the number of scripted faults that have not happened yet.
+func (f *FaultInjector) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.script)
+}
+
+// next returns the faults to inject on a command: the next scripted fault if it applies to the command, and the
+// random faults that were drawn.
+func (f *FaultInjector) next(request bool) map[AdapterFault]bool {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	faults := map[AdapterFault]bool{}
+
+	if len(f.script) > 0 && (request || !f.script[0].requestOnly()) {
+		faults[f.script[0]] = true
+		f.script = f.script[1:]
+	}
+
+	for fault := FaultNoData; fault <= FaultGarbage; fault++ {
+		if (request || !fault.requestOnly()) && f.random.Float64() < f.probabilities[fault] {
+			faults[fault] = true
+		}
+	}
+
+	return faults
+}
+
+// garbage returns a few bytes of line noise. They are never printable, so they cannot be mistaken for data.
+func (f *FaultInjector) garbage() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	noise := make([]byte, 1+f.random.Intn(8))
+	for i := range noise {
+		noise[i] = byte(0x80 + f.random.Intn(0x80))
+	}
+
+	return string(noise)
+}
+
+func (f *FaultInjector) delay() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.latency
+}
@@ -0,0 +1,153 @@
+package emulator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+// connectFlaky connects a serial connector with a command timeout to an adapter injecting faults.
+func connectFlaky(t *testing.T, injector *emulator.FaultInjector, timeout time.Duration) *gobd2.SerialConnector {
+	t.Helper()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	opener := &emulator.Opener{
+		Vehicle: emulator.NewDemoVehicle(),
+		Options: []emulator.Option{emulator.WithFaultInjector(injector)},
+	}
+
+	connector := gobd2.NewSerialConnector("emulator", 38400, opener, gobd2.WithInitProfile(profile), gobd2.WithTimeout(timeout))
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	// The first request searches for the protocol.
+	_, err := connector.SendCommand(gobd2.SupportedPIDsCommand1_20)
+	require.NoError(t, err)
+
+	return connector
+}
+
+func TestFaultInjector_Scripted(t *testing.T) {
+	t.Parallel()
+
+	injector := emulator.NewFaultInjector(1)
+	adapter := emulator.New(emulator.NewDemoVehicle(), emulator.WithFaultInjector(injector))
+	adapter.Execute("ATE0")
+	adapter.Execute("0100")
+
+	injector.Inject(emulator.FaultNoData, emulator.FaultCANError, emulator.FaultDroppedPrompt)
+	require.Equal(t, 3, injector.Pending())
+
+	require.Equal(t, "ELM327 v2.1\r\r>", adapter.Execute("ATI"), "AT commands are answered normally")
+	require.Equal(t, "NO DATA\r\r>", adapter.Execute("010D"))
+	require.Equal(t, "CAN ERROR\r\r>", adapter.Execute("010D"))
+	require.Equal(t, "OK\r\r", adapter.Execute("ATH1"))
+	require.Equal(t, "7E8 03 41 0D 00\r\r>", adapter.Execute("010D"))
+	require.Zero(t, injector.Pending())
+
+	injector.Inject(emulator.FaultTruncated)
+	require.Equal(t, "7E8 10 14 49 02 01 31 47 4F\r\r>", adapter.Execute("0902"))
+
+	injector.Inject(emulator.FaultLowVoltageReset)
+	require.Equal(t, "\rLV RESET\r\rELM327 v2.1\r\r>", adapter.Execute("ATDPN"))
+	require.Equal(t, "ATDPN\rA0\r\r>", adapter.Execute("ATDPN"), "settings are lost")
+}
+
+func TestFaultInjector_Garbage(t *testing.T) {
+	t.Parallel()
+
+	injector := emulator.NewFaultInjector(1)
+	connector := connectFlaky(t, injector, time.Second)
+
+	injector.Inject(emulator.FaultGarbage)
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0D 00", response)
+}
+
+func TestFaultInjector_LowVoltageReset(t *testing.T) {
+	t.Parallel()
+
+	injector := emulator.NewFaultInjector(1)
+	connector := connectFlaky(t, injector, time.Second)
+
+	injector.Inject(emulator.FaultLowVoltageReset)
+
+	_, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.ErrorIs(t, err, gobd2.ErrAdapterReset)
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "SEARCHING...\r41 0D 00", response, "the adapter is initialized again, without echo")
+}
+
+func TestFaultInjector_DroppedPrompt(t *testing.T) {
+	t.Parallel()
+
+	injector := emulator.NewFaultInjector(1)
+	connector := connectFlaky(t, injector, 300*time.Millisecond)
+
+	injector.Inject(emulator.FaultDroppedPrompt)
+
+	_, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.ErrorIs(t, err, gobd2.ErrTimeout)
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0D 00", response)
+}
+
+func TestFaultInjector_LatencySpike(t *testing.T) {
+	t.Parallel()
+
+	injector := emulator.NewFaultInjector(1)
+	injector.SetLatency(500 * time.Millisecond)
+	connector := connectFlaky(t, injector, 200*time.Millisecond)
+
+	injector.Inject(emulator.FaultLatency)
+
+	_, err := connector.SendCommand(gobd2.EngineRPMCommand)
+	require.ErrorIs(t, err, gobd2.ErrTimeout)
+
+	time.Sleep(400 * time.Millisecond) // the late answer arrives
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0D 00", response, "the late answer is discarded")
+}
+
+func TestFaultInjector_Random(t *testing.T) {
+	t.Parallel()
+
+	injector := emulator.NewFaultInjector(7)
+	connector := connectFlaky(t, injector, time.Second)
+	commander := gobd2.NewCommander(connector)
+
+	injector.SetProbability(emulator.FaultCANError, 0.3)
+	injector.SetProbability(emulator.FaultGarbage, 0.3)
+
+	failures := 0
+
+	for range 50 {
+		response, err := commander.ExecuteCommand(gobd2.VehicleSpeedCommand)
+		if err != nil {
+			require.ErrorIs(t, err, gobd2.ErrCANError)
+
+			failures++
+
+			continue
+		}
+
+		require.Equal(t, "41 0D 00", response)
+	}
+
+	require.Greater(t, failures, 5)
+	require.Less(t, failures, 30)
+}
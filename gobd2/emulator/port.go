@@ -0,0 +1,160 @@
+package emulator
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/tarm/serial"
+)
+
+// Port is an in-memory serial port with an emulated adapter on the other end. It implements gobd2.SerialPort.
+type Port struct {
+	toAdapter   *buffer
+	fromAdapter *buffer
+	readTimeout time.Duration
+}
+
+// NewPort creates a port connected to a new adapter for vehicle. Reads block until the adapter sends data.
+func NewPort(vehicle Vehicle, opts ...Option) *Port {
+	port := &Port{toAdapter: newBuffer(), fromAdapter: newBuffer()}
+
+	go New(vehicle, opts...).Serve(&adapterEnd{port}) //nolint:errcheck // ends when the port is closed
+
+	return port
+}
+
+// Read reads what the adapter sent. Like a serial port opened with a read timeout, it returns io.EOF if nothing
+// arrives in time.
+func (p *Port) Read(b []byte) (int, error) {
+	return p.fromAdapter.read(b, p.readTimeout)
+}
+
+// Write sends b to the adapter.
+func (p *Port) Write(b []byte) (int, error) {
+	return p.toAdapter.write(b)
+}
+
+// Close disconnects the port from the adapter.
+func (p *Port) Close() error {
+	p.toAdapter.close()
+	p.fromAdapter.close()
+
+	return nil
+}
+
+// Opener implements gobd2.SerialPortOpener, connecting every opened port to a new adapter for Vehicle. The port
+// honors the read timeout of the serial configuration.
+type Opener struct {
+	Vehicle Vehicle
+	Options []Option
+}
+
+// OpenPort opens a port to a new emulated adapter.
+func (o *Opener) OpenPort(config *serial.Config) (gobd2.SerialPort, error) {
+	port := NewPort(o.Vehicle, o.Options...)
+	port.readTimeout = config.ReadTimeout
+
+	return port, nil
+}
+
+// BluetoothTransport implements gobd2.BluetoothTransport, connecting every opened channel to a new adapter for
+// Vehicle, like a Bluetooth adapter in range regardless of its address.
+type BluetoothTransport struct {
+	Vehicle Vehicle
+	Options []Option
+}
+
+// bluetoothPollInterval is how long reads wait for the adapter on channels opened by BluetoothTransport.
+const bluetoothPollInterval = 50 * time.Millisecond
+
+// Open opens a channel to a new emulated adapter.
+func (t *BluetoothTransport) Open(string) (gobd2.SerialPort, error) {
+	port := NewPort(t.Vehicle, t.Options...)
+	port.readTimeout = bluetoothPollInterval
+
+	return port, nil
+}
+
+// adapterEnd is the adapter's side of a Port.
+type adapterEnd struct {
+	port *Port
+}
+
+func (a *adapterEnd) Read(b []byte) (int, error) {
+	return a.port.toAdapter.read(b, 0)
+}
+
+func (a *adapterEnd) Write(b []byte) (int, error) {
+	return a.port.fromAdapter.write(b)
+}
+
+// buffer carries data in one direction. Writes never block, reads wait for data.
+type buffer struct {
+	mu        sync.Mutex
+	data      bytes.Buffer
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBuffer() *buffer {
+	return &buffer{ready: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+func (b *buffer) write(p []byte) (int, error) {
+	select {
+	case <-b.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	b.mu.Lock()
+	b.data.Write(p)
+	b.mu.Unlock()
+
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+
+	return len(p), nil
+}
+
+// read waits for data, at most timeout if it is not zero, and returns io.EOF if none arrives or the buffer is
+// closed.
+func (b *buffer) read(p []byte, timeout time.Duration) (int, error) {
+	var expired <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	for {
+		b.mu.Lock()
+		if b.data.Len() > 0 {
+			n, err := b.data.Read(p)
+			b.mu.Unlock()
+
+			return n, err
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.ready:
+		case <-b.done:
+			return 0, io.EOF
+		case <-expired:
+			return 0, io.EOF
+		}
+	}
+}
+
+func (b *buffer) close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
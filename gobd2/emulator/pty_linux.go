@@ -0,0 +1,114 @@
+//go:build linux
+
+package emulator
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// PTY is a pseudo terminal with an emulated adapter on its master side. Applications open Path like the device
+// of a serial adapter.
+type PTY struct {
+	master *os.File
+	// slave is kept open so the master does not report a hangup while no application is connected.
+	slave *os.File
+	path  string
+}
+
+// OpenPTY creates a pseudo terminal and serves a new adapter for vehicle on it until Close is called.
+func OpenPTY(vehicle Vehicle, opts ...Option) (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pseudo terminal: %w", err)
+	}
+
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+
+		return nil, fmt.Errorf("failed to unlock pseudo terminal: %w", err)
+	}
+
+	var number uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+
+		return nil, fmt.Errorf("failed to get pseudo terminal number: %w", err)
+	}
+
+	path := fmt.Sprintf("/dev/pts/%d", number)
+
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	if err := makeRaw(slave); err != nil {
+		slave.Close()
+		master.Close()
+
+		return nil, fmt.Errorf("failed to configure %s: %w", path, err)
+	}
+
+	go New(vehicle, opts...).Serve(master) //nolint:errcheck // ends when the terminal is closed
+
+	return &PTY{master: master, slave: slave, path: path}, nil
+}
+
+// Path returns the device applications connect to, e.g. "/dev/pts/3".
+func (p *PTY) Path() string {
+	return p.path
+}
+
+// Close stops the adapter and removes the pseudo terminal.
+func (p *PTY) Close() error {
+	p.slave.Close()
+
+	return p.master.Close()
+}
+
+// makeRaw disables line editing and character translation, so commands and answers pass through unchanged.
+func makeRaw(f *os.File) error {
+	var termios syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		return err
+	}
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR |
+		syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+
+	return ioctl(f, syscall.TCSETS, unsafe.Pointer(&termios))
+}
+
+// ioctl runs an ioctl on f without switching it to blocking mode, so that Close interrupts pending reads.
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package emulator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenPTY(t *testing.T) {
+	t.Parallel()
+
+	pty, err := emulator.OpenPTY(emulator.NewDemoVehicle())
+	if err != nil {
+		t.Skipf("pseudo terminals unavailable: %v", err)
+	}
+
+	defer pty.Close()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	connector := gobd2.NewSerialConnector(pty.Path(), 38400, &gobd2.RealPortOpener{},
+		gobd2.WithInitProfile(profile), gobd2.WithTimeout(5*time.Second))
+	require.NoError(t, connector.Connect())
+
+	defer connector.Close()
+
+	id, err := gobd2.NewELM327(connector).Identify()
+	require.NoError(t, err)
+	require.Equal(t, "ELM327 v2.1", id)
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "SEARCHING...\r41 0D 00", response)
+}
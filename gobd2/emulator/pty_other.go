@@ -0,0 +1,26 @@
+//go:build !linux
+
+package emulator
+
+import "errors"
+
+// ErrPTYUnsupported is returned by OpenPTY on platforms without pseudo terminal support.
+var ErrPTYUnsupported = errors.New("pseudo terminals are only supported on Linux")
+
+// PTY is a pseudo terminal with an emulated adapter on its master side.
+type PTY struct{}
+
+// OpenPTY is not supported on this platform.
+func OpenPTY(Vehicle, ...Option) (*PTY, error) {
+	return nil, ErrPTYUnsupported
+}
+
+// Path returns the device applications connect to.
+func (p *PTY) Path() string {
+	return ""
+}
+
+// Close stops the adapter and removes the pseudo terminal.
+func (p *PTY) Close() error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package emulator
+
+import (
+	"errors"
+	"net"
+)
+
+// Serve accepts connections on listener and runs a new adapter for vehicle on each of them, the way WiFi adapters
+// are reached over TCP. It returns nil once listener is closed.
+func Serve(listener net.Listener, vehicle Vehicle, opts ...Option) error {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			New(vehicle, opts...).Serve(conn) //nolint:errcheck // the client disconnected
+		}()
+	}
+}
+
+// ListenAndServe listens on the TCP address addr, e.g. ":35000", and serves adapters for vehicle on it.
+func ListenAndServe(addr string, vehicle Vehicle, opts ...Option) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	return Serve(listener, vehicle, opts...)
+}
@@ -0,0 +1,45 @@
+package emulator_test
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+
+	go func() { served <- emulator.Serve(listener, emulator.NewDemoVehicle()) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	_, err = conn.Write([]byte("ATE0\r"))
+	require.NoError(t, err)
+
+	response, err := reader.ReadString('>')
+	require.NoError(t, err)
+	require.Equal(t, "ATE0\rOK\r\r>", response)
+
+	_, err = conn.Write([]byte("01 0D\r"))
+	require.NoError(t, err)
+
+	response, err = reader.ReadString('>')
+	require.NoError(t, err)
+	require.Equal(t, "SEARCHING...\r41 0D 00\r\r>", response)
+
+	require.NoError(t, listener.Close())
+	require.NoError(t, <-served)
+}
@@ -0,0 +1,516 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+)
+
+// DriveCycle is a speed profile the simulated vehicle follows.
+type DriveCycle int
+
+// Drive cycles supported by SimulatedVehicle.
+const (
+	// CycleIdle keeps the vehicle standing with the engine idling.
+	CycleIdle DriveCycle = iota
+	// CycleUrban repeats stop-and-go city driving modeled after the FTP-75 urban phase.
+	CycleUrban
+	// CycleHighway accelerates onto the highway and cruises between 95 and 110 km/h.
+	CycleHighway
+)
+
+var driveCycleNames = map[DriveCycle]string{
+	CycleIdle:    "idle",
+	CycleUrban:   "urban",
+	CycleHighway: "highway",
+}
+
+// ErrUnknownDriveCycle is returned when parsing an unknown drive cycle name.
+var ErrUnknownDriveCycle = errors.New("unknown drive cycle")
+
+func (c DriveCycle) String() string {
+	if name, ok := driveCycleNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("DriveCycle(%d)", int(c))
+}
+
+// ParseDriveCycle parses a drive cycle name: "idle", "urban" or "highway".
+func ParseDriveCycle(s string) (DriveCycle, error) {
+	for cycle, name := range driveCycleNames {
+		if strings.EqualFold(s, name) {
+			return cycle, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownDriveCycle, s)
+}
+
+// speedPoint is a waypoint of a drive cycle: the speed to reach at a point in time.
+type speedPoint struct {
+	at    time.Duration
+	speed float64 // km/h
+}
+
+// speedProfile is a drive cycle's waypoints. Once the last waypoint is passed, the profile repeats from loopFrom.
+type speedProfile struct {
+	points   []speedPoint
+	loopFrom time.Duration
+}
+
+var speedProfiles = map[DriveCycle]speedProfile{
+	CycleIdle: {points: []speedPoint{{0, 0}}},
+	CycleUrban: {points: []speedPoint{
+		{0, 0}, {20 * time.Second, 0}, {35 * time.Second, 30}, {60 * time.Second, 48}, {90 * time.Second, 48},
+		{110 * time.Second, 25}, {125 * time.Second, 0}, {145 * time.Second, 0}, {165 * time.Second, 40},
+		{200 * time.Second, 56}, {240 * time.Second, 56}, {260 * time.Second, 30}, {275 * time.Second, 0},
+		{290 * time.Second, 0}, {310 * time.Second, 35}, {335 * time.Second, 50}, {380 * time.Second, 50},
+		{400 * time.Second, 0}, {420 * time.Second, 0},
+	}},
+	CycleHighway: {points: []speedPoint{
+		{0, 0}, {10 * time.Second, 0}, {45 * time.Second, 80}, {70 * time.Second, 100}, {150 * time.Second, 110},
+		{210 * time.Second, 95}, {270 * time.Second, 105}, {330 * time.Second, 100},
+	}, loopFrom: 70 * time.Second},
+}
+
+// speedAt returns the target speed at a point of the cycle.
+func (p speedProfile) speedAt(t time.Duration) float64 {
+	last := p.points[len(p.points)-1]
+	if t > last.at {
+		if last.at == p.loopFrom {
+			return last.speed
+		}
+
+		t = p.loopFrom + (t-p.loopFrom)%(last.at-p.loopFrom)
+	}
+
+	for i := 1; i < len(p.points); i++ {
+		from, to := p.points[i-1], p.points[i]
+		if t <= to.at {
+			return from.speed + (to.speed-from.speed)*float64(t-from.at)/float64(to.at-from.at)
+		}
+	}
+
+	return last.speed
+}
+
+// Signals is the state of the simulated engine and vehicle at one point in time.
+type Signals struct {
+	Runtime           time.Duration
+	RPM               float64
+	Speed             float64 // km/h
+	Load              float64 // %
+	Throttle          float64 // %
+	Coolant           float64 // °C
+	IntakeTemperature float64 // °C
+	MAF               float64 // g/s
+	MAP               float64 // kPa
+	ShortTermFuelTrim float64 // %
+	LongTermFuelTrim  float64 // %
+	FuelLevel         float64 // %
+	Voltage           float64 // V
+	Distance          float64 // km driven since the simulation started
+}
+
+// Fault is a scripted malfunction of the simulated vehicle.
+type Fault struct {
+	// At is the simulation time the fault appears at.
+	At time.Duration
+	// DTC is the trouble code the fault raises, e.g. "P0301".
+	DTC string
+	// ConfirmAfter is how long the code stays pending (mode 07) before it is confirmed (mode 03), the MIL is
+	// turned on and a freeze frame is stored.
+	ConfirmAfter time.Duration
+	// Effect changes the simulated signals while the fault is present, may be nil.
+	Effect func(*Signals)
+}
+
+// MisfireFault makes a cylinder misfire from at on, raising P0301 to P0312 for cylinders 1 to 12, or P0300, random
+// misfire, for any other cylinder, and making the idle rough.
+func MisfireFault(at time.Duration, cylinder int) Fault {
+	if cylinder < 1 || cylinder > 12 {
+		cylinder = 0
+	}
+
+	return Fault{
+		At:           at,
+		DTC:          fmt.Sprintf("P03%02d", cylinder),
+		ConfirmAfter: 10 * time.Second,
+		Effect: func(s *Signals) {
+			s.RPM += 60 * math.Sin(s.Runtime.Seconds()*7)
+			s.ShortTermFuelTrim += 4
+		},
+	}
+}
+
+// LeanFault simulates a vacuum leak from at on, raising P0171 as the fuel trims max out.
+func LeanFault(at time.Duration) Fault {
+	return Fault{
+		At:           at,
+		DTC:          "P0171",
+		ConfirmAfter: 30 * time.Second,
+		Effect: func(s *Signals) {
+			s.LongTermFuelTrim += 18
+			s.ShortTermFuelTrim += 6
+		},
+	}
+}
+
+// ThermostatFault simulates a thermostat stuck open from at on, raising P0128 as the engine never warms up.
+func ThermostatFault(at time.Duration) Fault {
+	return Fault{
+		At:           at,
+		DTC:          "P0128",
+		ConfirmAfter: 60 * time.Second,
+		Effect: func(s *Signals) {
+			s.Coolant = min(s.Coolant, 68)
+		},
+	}
+}
+
+// Vehicle and engine constants of the simulation, roughly a two liter compact car.
+const (
+	simulationStep  = 100 * time.Millisecond
+	vehicleMass     = 1400.0 // kg
+	rollingCoeff    = 0.012
+	dragArea        = 0.65 // m², drag coefficient times frontal area
+	airDensity      = 1.2  // kg/m³
+	gravity         = 9.81
+	displacement    = 2.0 // l
+	maxPower        = 110000.0
+	maxPowerRPM     = 5500.0
+	idleRPM         = 780.0
+	idleLoad        = 20.0
+	idleThrottle    = 14.0
+	thermostat      = 90.0 // °C
+	openLoopBelow   = 40.0 // °C, fuel trims are not applied below this coolant temperature
+	stoichiometric  = 14.7
+	fuelDensity     = 740.0 // g/l
+	tankCapacity    = 50.0  // l
+	chargingVoltage = 14.2
+)
+
+// rpmPerKmh is the engine speed per km/h in each gear, first gear first.
+var rpmPerKmh = []float64{110, 65, 45, 34, 28, 23}
+
+// gearUpshift is the speed in km/h above which the next gear is selected.
+var gearUpshift = []float64{15, 30, 50, 70, 90}
+
+// SimulationOption configures a SimulatedVehicle.
+type SimulationOption func(*SimulatedVehicle)
+
+// WithClock makes the simulation follow clock instead of the wall clock, e.g. to step it in tests.
+func WithClock(clock func() time.Time) SimulationOption {
+	return func(v *SimulatedVehicle) {
+		v.clock = clock
+	}
+}
+
+// WithFaults scripts faults that appear during the simulation.
+func WithFaults(faults ...Fault) SimulationOption {
+	return func(v *SimulatedVehicle) {
+		v.faults = append(v.faults, faults...)
+	}
+}
+
+// WithAmbientTemperature sets the outside temperature in °C, which is also the coolant temperature at start.
+func WithAmbientTemperature(celsius float64) SimulationOption {
+	return func(v *SimulatedVehicle) {
+		v.ambient = celsius
+	}
+}
+
+// faultState tracks a scripted fault's trouble code.
+type faultState struct {
+	Fault
+	// detectAt is when the code is raised, it moves when the codes are cleared while the fault is present.
+	detectAt  time.Duration
+	pending   bool
+	confirmed bool
+}
+
+// SimulatedVehicle is a Vehicle on 11-bit 500 kbit/s CAN whose engine control unit reports signals simulated over
+// a drive cycle. Speed, engine speed, load, throttle, air flow, temperatures and fuel trims are derived from one
+// another, and scripted faults raise trouble codes and store freeze frames like a real ECU. The simulation advances
+// with the clock whenever the vehicle is queried. It is safe for concurrent use.
+type SimulatedVehicle struct {
+	mu      sync.Mutex
+	cycle   speedProfile
+	clock   func() time.Time
+	start   time.Time
+	elapsed time.Duration
+	ambient float64
+	faults  []Fault
+	states  []*faultState
+	signals Signals
+	coolant float64 // simulated coolant temperature before fault effects
+	engine  *ECU
+	vehicle *StaticVehicle
+}
+
+// NewSimulatedVehicle creates a vehicle driving cycle, starting with a cold engine. An unknown cycle idles.
+func NewSimulatedVehicle(cycle DriveCycle, opts ...SimulationOption) *SimulatedVehicle {
+	profile, ok := speedProfiles[cycle]
+	if !ok {
+		profile = speedProfiles[CycleIdle]
+	}
+
+	v := &SimulatedVehicle{
+		cycle:   profile,
+		clock:   time.Now,
+		ambient: 20,
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	for _, fault := range v.faults {
+		v.states = append(v.states, &faultState{Fault: fault, detectAt: fault.At})
+	}
+
+	v.start = v.clock()
+	v.coolant = v.ambient
+	v.signals = Signals{RPM: idleRPM, Load: idleLoad, Coolant: v.ambient, FuelLevel: 62}
+	v.engine = &ECU{
+		Address: 0x7E8,
+		InfoTypes: map[byte][]byte{
+			0x02: []byte("1GOBD2SIMULATOR01"),
+			0x0A: []byte("ECM\x00-EngineControl\x00\x00"),
+		},
+		// Catalyst monitor of bank 1: test ID, unit and scaling, value, minimum and maximum.
+		TestResults: map[byte][]byte{
+			0x21: {0x80, 0x3D, 0x02, 0x8C, 0x00, 0x00, 0x04, 0x00},
+		},
+	}
+	v.vehicle = NewStaticVehicle(gobd2.ProtocolCAN11Bit500, v.engine)
+	v.step(0)
+
+	return v
+}
+
+// Protocol returns the protocol the vehicle communicates with.
+func (v *SimulatedVehicle) Protocol() gobd2.Protocol {
+	return gobd2.ProtocolCAN11Bit500
+}
+
+// Handle advances the simulation to the current time and answers request.
+func (v *SimulatedVehicle) Handle(request Request) []Response {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.advance()
+
+	if len(request.Data) > 0 && request.Data[0] == 0x04 {
+		v.clearCodes()
+	}
+
+	return v.vehicle.Handle(request)
+}
+
+// Signals advances the simulation to the current time and returns the simulated signals.
+func (v *SimulatedVehicle) Signals() Signals {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.advance()
+
+	return v.signals
+}
+
+// advance runs the simulation in fixed steps up to the current time.
+func (v *SimulatedVehicle) advance() {
+	target := v.clock().Sub(v.start)
+	for v.elapsed+simulationStep <= target {
+		v.elapsed += simulationStep
+		v.step(simulationStep)
+	}
+}
+
+// step advances the physics by dt and publishes the new signals in the ECU's tables.
+func (v *SimulatedVehicle) step(dt time.Duration) {
+	seconds := dt.Seconds()
+	previous := v.signals
+	s := Signals{Runtime: v.elapsed}
+
+	s.Speed = v.cycle.speedAt(v.elapsed)
+
+	// Power needed to accelerate and to overcome rolling and air resistance.
+	speed := s.Speed / 3.6
+	acceleration := 0.0
+	if seconds > 0 {
+		acceleration = (speed - previous.Speed/3.6) / seconds
+	}
+
+	power := vehicleMass*acceleration*speed + rollingCoeff*vehicleMass*gravity*speed +
+		0.5*airDensity*dragArea*math.Pow(speed, 3)
+
+	s.RPM = idleRPM + 15*math.Sin(v.elapsed.Seconds()*2)
+	if s.Speed >= 3 {
+		s.RPM = max(900, s.Speed*rpmPerKmh[gear(s.Speed)])
+	}
+
+	s.Load = idleLoad
+	s.Throttle = idleThrottle
+
+	switch {
+	case power < 0 && s.Speed >= 3:
+		s.Load = 12 // overrun fuel cut while decelerating
+	case power > 0:
+		available := maxPower * min(s.RPM/maxPowerRPM, 1)
+		s.Load = min(idleLoad+(100-idleLoad)*power/available, 100)
+		s.Throttle = min(idleThrottle+0.8*(s.Load-idleLoad), 100)
+	}
+
+	s.MAP = 25 + 0.75*s.Load
+	s.MAF = displacement * s.RPM / 120 * s.Load / 100 * 1.184
+
+	// The engine warms up faster under load until the thermostat opens.
+	if v.coolant < thermostat {
+		v.coolant = min(v.coolant+seconds*(0.05+0.25*s.Load/100), thermostat)
+	}
+
+	s.Coolant = v.coolant + math.Sin(v.elapsed.Seconds()/20)*math.Max(0, v.coolant-thermostat+1)
+	s.IntakeTemperature = v.ambient + 5 + 10*max(0, 1-s.Speed/120)
+
+	if s.Coolant >= openLoopBelow {
+		s.ShortTermFuelTrim = 3 * math.Sin(v.elapsed.Seconds()*4)
+		s.LongTermFuelTrim = 1.6
+	}
+
+	fuel := s.MAF / stoichiometric / fuelDensity * seconds // l
+	s.FuelLevel = max(previous.FuelLevel-fuel/tankCapacity*100, 0)
+	s.Voltage = chargingVoltage
+	s.Distance = previous.Distance + speed*seconds/1000
+
+	for _, state := range v.states {
+		if v.elapsed >= state.At && state.Effect != nil {
+			state.Effect(&s)
+		}
+	}
+
+	v.signals = s
+	v.updateCodes()
+	v.publish()
+}
+
+// gear returns the index of the gear selected at speed.
+func gear(speed float64) int {
+	for i, upshift := range gearUpshift {
+		if speed < upshift {
+			return i
+		}
+	}
+
+	return len(gearUpshift)
+}
+
+// updateCodes raises the trouble codes of present faults, storing a freeze frame with the first confirmed code.
+func (v *SimulatedVehicle) updateCodes() {
+	for _, state := range v.states {
+		if v.elapsed < state.detectAt {
+			continue
+		}
+
+		state.pending = true
+
+		if !state.confirmed && v.elapsed >= state.detectAt+state.ConfirmAfter {
+			state.confirmed = true
+
+			if v.engine.FreezeFrame == nil {
+				v.engine.FreezeFrame = v.encodeSignals()
+				v.engine.FreezeFrame[0x02] = encodeDTC(state.DTC)
+			}
+		}
+	}
+}
+
+// clearCodes handles mode 04: the codes are cleared, and faults still present are detected again later.
+func (v *SimulatedVehicle) clearCodes() {
+	for _, state := range v.states {
+		state.pending, state.confirmed = false, false
+		if v.elapsed >= state.At {
+			state.detectAt = v.elapsed + 5*time.Second
+		}
+	}
+}
+
+// publish updates the ECU's tables from the current signals and fault states.
+func (v *SimulatedVehicle) publish() {
+	v.engine.PIDs = v.encodeSignals()
+	v.engine.DTCs, v.engine.PendingDTCs = nil, nil
+
+	for _, state := range v.states {
+		if state.pending {
+			v.engine.PendingDTCs = append(v.engine.PendingDTCs, state.DTC)
+		}
+
+		if state.confirmed {
+			v.engine.DTCs = append(v.engine.DTCs, state.DTC)
+
+			if !slices.Contains(v.engine.PermanentDTCs, state.DTC) {
+				v.engine.PermanentDTCs = append(v.engine.PermanentDTCs, state.DTC)
+			}
+		}
+	}
+
+	// Monitor status: MIL and number of confirmed codes, then the supported and completed readiness monitors.
+	status := byte(len(v.engine.DTCs))
+	if len(v.engine.DTCs) > 0 {
+		status |= 0x80
+	}
+
+	v.engine.PIDs[0x01] = []byte{status, 0x07, 0xE5, 0x00}
+}
+
+// encodeSignals encodes the current signals as mode 01 PID data.
+func (v *SimulatedVehicle) encodeSignals() map[byte][]byte {
+	s := v.signals
+
+	return map[byte][]byte{
+		0x04: {percent(s.Load)},
+		0x05: {temperature(s.Coolant)},
+		0x06: {fuelTrim(s.ShortTermFuelTrim)},
+		0x07: {fuelTrim(s.LongTermFuelTrim)},
+		0x0B: {clampByte(s.MAP)},
+		0x0C: word(s.RPM * 4),
+		0x0D: {clampByte(s.Speed)},
+		0x0F: {temperature(s.IntakeTemperature)},
+		0x10: word(s.MAF * 100),
+		0x11: {percent(s.Throttle)},
+		0x1C: {0x03},
+		0x1F: word(s.Runtime.Seconds()),
+		0x2F: {percent(s.FuelLevel)},
+		0x42: word(s.Voltage * 1000),
+		0x46: {temperature(v.ambient)},
+	}
+}
+
+func percent(value float64) byte {
+	return clampByte(value * 255 / 100)
+}
+
+func temperature(celsius float64) byte {
+	return clampByte(celsius + 40)
+}
+
+func fuelTrim(trim float64) byte {
+	return clampByte((trim + 100) * 128 / 100)
+}
+
+func clampByte(value float64) byte {
+	return byte(math.Round(min(max(value, 0), 0xFF)))
+}
+
+func word(value float64) []byte {
+	w := uint16(math.Round(min(max(value, 0), 0xFFFF)))
+
+	return []byte{byte(w >> 8), byte(w)}
+}
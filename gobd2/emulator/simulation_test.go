@@ -0,0 +1,145 @@
+package emulator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+// steppedClock is a clock that only moves when advanced.
+type steppedClock struct {
+	now time.Time
+}
+
+func (c *steppedClock) Now() time.Time {
+	return c.now
+}
+
+func (c *steppedClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newSteppedClock() *steppedClock {
+	return &steppedClock{now: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)}
+}
+
+func TestParseDriveCycle(t *testing.T) {
+	t.Parallel()
+
+	cycle, err := emulator.ParseDriveCycle("Highway")
+	require.NoError(t, err)
+	require.Equal(t, emulator.CycleHighway, cycle)
+	require.Equal(t, "highway", cycle.String())
+
+	_, err = emulator.ParseDriveCycle("autobahn")
+	require.ErrorIs(t, err, emulator.ErrUnknownDriveCycle)
+}
+
+func TestSimulatedVehicle_Idle(t *testing.T) {
+	t.Parallel()
+
+	clock := newSteppedClock()
+	vehicle := emulator.NewSimulatedVehicle(emulator.CycleIdle, emulator.WithClock(clock.Now))
+
+	clock.Advance(10 * time.Minute)
+	signals := vehicle.Signals()
+
+	require.Zero(t, signals.Speed)
+	require.InDelta(t, 780, signals.RPM, 20)
+	require.InDelta(t, 20, signals.Load, 0.01)
+	require.Greater(t, signals.Coolant, 40.0, "the engine warms up while idling")
+	require.Less(t, signals.Coolant, 90.0)
+	require.Less(t, signals.FuelLevel, 62.0)
+}
+
+func TestSimulatedVehicle_Urban(t *testing.T) {
+	t.Parallel()
+
+	clock := newSteppedClock()
+	vehicle := emulator.NewSimulatedVehicle(emulator.CycleUrban, emulator.WithClock(clock.Now))
+
+	clock.Advance(45 * time.Second)
+	accelerating := vehicle.Signals()
+	require.InDelta(t, 37.2, accelerating.Speed, 0.1)
+	require.InDelta(t, 37.2*45, accelerating.RPM, 1, "third gear")
+	require.Greater(t, accelerating.Load, 25.0)
+	require.Greater(t, accelerating.Throttle, 14.0)
+	require.InDelta(t, 2*accelerating.RPM/120*accelerating.Load/100*1.184, accelerating.MAF, 0.01)
+
+	clock.Advance(70 * time.Second)
+	braking := vehicle.Signals()
+	require.Less(t, braking.Speed, accelerating.Speed)
+	require.InDelta(t, 12, braking.Load, 0.01, "fuel is cut while decelerating")
+	require.InDelta(t, 14, braking.Throttle, 0.01)
+
+	clock.Advance(410 * time.Second)
+	require.InDelta(t, 30.75, vehicle.Signals().Speed, 0.01, "the cycle repeats after 420 s")
+}
+
+func TestSimulatedVehicle_UnknownCycle(t *testing.T) {
+	t.Parallel()
+
+	clock := newSteppedClock()
+	vehicle := emulator.NewSimulatedVehicle(emulator.DriveCycle(42), emulator.WithClock(clock.Now))
+
+	clock.Advance(time.Minute)
+	require.Zero(t, vehicle.Signals().Speed, "an unknown cycle idles")
+}
+
+func TestMisfireFault(t *testing.T) {
+	t.Parallel()
+
+	for cylinder, dtc := range map[int]string{1: "P0301", 8: "P0308", 10: "P0310", 12: "P0312", 0: "P0300", 13: "P0300"} {
+		require.Equal(t, dtc, emulator.MisfireFault(0, cylinder).DTC, cylinder)
+	}
+}
+
+func TestSimulatedVehicle_Highway(t *testing.T) {
+	t.Parallel()
+
+	clock := newSteppedClock()
+	highway := emulator.NewSimulatedVehicle(emulator.CycleHighway, emulator.WithClock(clock.Now))
+	idle := emulator.NewSimulatedVehicle(emulator.CycleIdle, emulator.WithClock(clock.Now))
+
+	clock.Advance(time.Hour)
+	signals := highway.Signals()
+
+	require.GreaterOrEqual(t, signals.Speed, 95.0)
+	require.LessOrEqual(t, signals.Speed, 110.0)
+	require.InDelta(t, signals.Speed*23, signals.RPM, 1, "sixth gear")
+	require.InDelta(t, 90, signals.Coolant, 1)
+	require.Greater(t, signals.Distance, 90.0)
+	require.Less(t, signals.FuelLevel, idle.Signals().FuelLevel)
+}
+
+func TestSimulatedVehicle_Faults(t *testing.T) {
+	t.Parallel()
+
+	clock := newSteppedClock()
+	vehicle := emulator.NewSimulatedVehicle(emulator.CycleIdle, emulator.WithClock(clock.Now),
+		emulator.WithFaults(emulator.MisfireFault(30*time.Second, 1)))
+
+	adapter := emulator.New(vehicle)
+	adapter.Execute("ATE0")
+
+	require.Equal(t, "SEARCHING...\r43 00\r\r>", adapter.Execute("03"))
+
+	clock.Advance(35 * time.Second)
+	require.Equal(t, "47 01 03 01\r\r>", adapter.Execute("07"))
+	require.Equal(t, "43 00\r\r>", adapter.Execute("03"), "the code is pending until confirmed")
+
+	clock.Advance(10 * time.Second)
+	require.Equal(t, "43 01 03 01\r\r>", adapter.Execute("03"))
+	require.Equal(t, "41 01 81 07 E5 00\r\r>", adapter.Execute("0101"), "the MIL is on")
+	require.Equal(t, "42 02 00 03 01\r\r>", adapter.Execute("020200"))
+	require.Equal(t, "42 0D 00 00\r\r>", adapter.Execute("020D00"))
+
+	require.Equal(t, "44\r\r>", adapter.Execute("04"))
+	require.Equal(t, "43 00\r\r>", adapter.Execute("03"))
+	require.Equal(t, "NO DATA\r\r>", adapter.Execute("020200"))
+
+	clock.Advance(6 * time.Second)
+	require.Equal(t, "47 01 03 01\r\r>", adapter.Execute("07"), "the misfire is detected again")
+}
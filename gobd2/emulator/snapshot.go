@@ -0,0 +1,90 @@
+package emulator
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+)
+
+// SnapshotVehicle is a Vehicle replaying a snapshot captured from a real vehicle: every ECU answers the requests
+// recorded in the snapshot exactly as it did then, and stays silent on anything else.
+type SnapshotVehicle struct {
+	protocol gobd2.Protocol
+	ecus     []snapshotECU
+}
+
+type snapshotECU struct {
+	ecu       ECU
+	responses map[string][][]byte
+}
+
+// NewSnapshotVehicle creates a vehicle replaying snapshot.
+func NewSnapshotVehicle(snapshot *gobd2.Snapshot) (*SnapshotVehicle, error) {
+	vehicle := &SnapshotVehicle{protocol: snapshot.Protocol}
+
+	for _, captured := range snapshot.ECUs {
+		address, err := strconv.ParseUint(captured.Address, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("ECU %q: invalid address: %w", captured.Address, err)
+		}
+
+		if !snapshot.Protocol.IsCAN() {
+			address &= 0xFF // legacy headers end with the ECU's source address
+		}
+
+		ecu := snapshotECU{ecu: ECU{Address: uint32(address)}, responses: map[string][][]byte{}}
+
+		for request, answers := range captured.Responses {
+			for _, answer := range answers {
+				data, err := hex.DecodeString(answer)
+				if err != nil {
+					return nil, fmt.Errorf("ECU %s: invalid response to %s: %w", captured.Address, request, err)
+				}
+
+				key := strings.ToUpper(request)
+				ecu.responses[key] = append(ecu.responses[key], data)
+			}
+		}
+
+		vehicle.ecus = append(vehicle.ecus, ecu)
+	}
+
+	return vehicle, nil
+}
+
+// LoadSnapshotVehicle creates a vehicle replaying the snapshot file at path.
+func LoadSnapshotVehicle(path string) (*SnapshotVehicle, error) {
+	snapshot, err := gobd2.LoadSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSnapshotVehicle(snapshot)
+}
+
+// Protocol returns the protocol the captured vehicle communicated with.
+func (v *SnapshotVehicle) Protocol() gobd2.Protocol {
+	return v.protocol
+}
+
+// Handle returns the recorded answers to request of every ECU it is addressed to.
+func (v *SnapshotVehicle) Handle(request Request) []Response {
+	key := strings.ToUpper(hex.EncodeToString(request.Data))
+
+	var responses []Response
+
+	for _, captured := range v.ecus {
+		if _, addressed := captured.ecu.addressedBy(v.protocol, request.Header); !addressed {
+			continue
+		}
+
+		for _, data := range captured.responses[key] {
+			responses = append(responses, Response{Header: captured.ecu.Address, Data: data})
+		}
+	}
+
+	return responses
+}
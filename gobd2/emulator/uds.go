@@ -0,0 +1,515 @@
+package emulator
+
+import (
+	"bytes"
+	"slices"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+)
+
+// UDS service identifiers and negative response codes the emulated ECUs use.
+const (
+	serviceDiagnosticSessionControl   byte = 0x10
+	serviceECUReset                   byte = 0x11
+	serviceClearDiagnosticInformation byte = 0x14
+	serviceReadDTCInformation         byte = 0x19
+	serviceReadDataByIdentifier       byte = 0x22
+	serviceSecurityAccess             byte = 0x27
+	serviceWriteDataByIdentifier      byte = 0x2E
+	serviceInputOutputControl         byte = 0x2F
+	serviceRoutineControl             byte = 0x31
+	serviceTesterPresent              byte = 0x3E
+
+	nrcSubFunctionNotSupported byte = 0x12
+	nrcIncorrectMessageLength  byte = 0x13
+	nrcRequestSequenceError    byte = 0x24
+	nrcRequestOutOfRange       byte = 0x31
+	nrcSecurityAccessDenied    byte = 0x33
+	nrcInvalidKey              byte = 0x35
+	nrcExceededAttempts        byte = 0x36
+	nrcTimeDelayNotExpired     byte = 0x37
+	nrcNotSupportedInSession   byte = 0x7F
+)
+
+// Defaults of the SecurityAccess attempt counter.
+const (
+	defaultSecurityAttempts = 3
+	defaultSecurityDelay    = 10 * time.Second
+)
+
+// defaultSession is the diagnostic session an ECU starts in and falls back to.
+const defaultSession byte = 0x01
+
+// suppressPositiveResponse is the sub-function bit asking the ECU not to answer a successful request.
+const suppressPositiveResponse byte = 0x80
+
+// isUDSService reports whether sid is a UDS service rather than an OBD-II mode.
+func isUDSService(sid byte) bool {
+	return sid >= 0x10 && sid < 0x40
+}
+
+// handleUDS answers the UDS services the ECU implements. It reports false for the others, and a nil response for
+// requests the ECU does not answer. ReadDataByIdentifier is only implemented by ECUs with data identifiers.
+func (ecu *ECU) handleUDS(data []byte) ([]byte, bool) {
+	switch data[0] {
+	case serviceDiagnosticSessionControl:
+		return ecu.diagnosticSessionControl(data), true
+	case serviceECUReset:
+		return ecu.ecuReset(data), true
+	case serviceClearDiagnosticInformation:
+		if len(data) != 4 {
+			return negative(data[0], nrcIncorrectMessageLength), true
+		}
+
+		ecu.DTCs, ecu.PendingDTCs, ecu.FreezeFrame = nil, nil, nil
+		if ecu.DTCRecords != nil {
+			ecu.DTCRecords = []DTCRecord{}
+		}
+
+		return []byte{serviceClearDiagnosticInformation + 0x40}, true
+	case serviceReadDTCInformation:
+		if ecu.DTCRecords == nil {
+			return nil, false
+		}
+
+		return ecu.readDTCInformation(data), true
+	case serviceReadDataByIdentifier:
+		if ecu.DataIdentifiers == nil {
+			return nil, false
+		}
+
+		return ecu.readDataByIdentifier(data[1:]), true
+	case serviceWriteDataByIdentifier:
+		if ecu.WritableDataIdentifiers == nil {
+			return nil, false
+		}
+
+		return ecu.writeDataByIdentifier(data), true
+	case serviceSecurityAccess:
+		if ecu.SeedKey == nil {
+			return nil, false
+		}
+
+		return ecu.securityAccess(data, time.Now()), true
+	case serviceRoutineControl:
+		if ecu.Routines == nil {
+			return nil, false
+		}
+
+		return ecu.routineControl(data), true
+	case serviceInputOutputControl:
+		if ecu.IOControls == nil {
+			return nil, false
+		}
+
+		return ecu.inputOutputControl(data), true
+	case serviceTesterPresent:
+		switch {
+		case len(data) != 2:
+			return negative(data[0], nrcIncorrectMessageLength), true
+		case data[1]&^suppressPositiveResponse != 0:
+			return negative(data[0], nrcSubFunctionNotSupported), true
+		}
+
+		return positive(data), true
+	}
+
+	return nil, false
+}
+
+// diagnosticSessionControl switches the ECU to another session and answers with its P2 timing: 50 ms, and 5 s
+// after a "response pending" answer.
+func (ecu *ECU) diagnosticSessionControl(data []byte) []byte {
+	if len(data) != 2 {
+		return negative(data[0], nrcIncorrectMessageLength)
+	}
+
+	session := data[1] &^ suppressPositiveResponse
+	if session < 0x01 || session > 0x04 {
+		return negative(data[0], nrcSubFunctionNotSupported)
+	}
+
+	ecu.changeSession(session)
+
+	return positive(append(data, 0x00, 0x32, 0x01, 0xF4))
+}
+
+// expireSession returns the ECU to the default session if its session timed out before a request received at now.
+func (ecu *ECU) expireSession(now time.Time) {
+	if ecu.SessionTimeout > 0 && !ecu.lastRequest.IsZero() && now.Sub(ecu.lastRequest) > ecu.SessionTimeout {
+		ecu.changeSession(defaultSession)
+	}
+
+	ecu.lastRequest = now
+}
+
+// changeSession switches to session, which locks the security levels again and returns the control of inputs and
+// outputs to the ECU.
+func (ecu *ECU) changeSession(session byte) {
+	ecu.Session = session
+	ecu.security.unlocked = 0
+	ecu.security.seedLevel = 0
+	ecu.adjusted = nil
+}
+
+// ecuReset restarts the ECU for a hard, key off on or soft reset: it returns to the default session, which locks it
+// and returns the control of inputs and outputs, and stops its routines.
+func (ecu *ECU) ecuReset(data []byte) []byte {
+	if len(data) != 2 {
+		return negative(data[0], nrcIncorrectMessageLength)
+	}
+
+	if reset := data[1] &^ suppressPositiveResponse; reset < 0x01 || reset > 0x03 {
+		return negative(data[0], nrcSubFunctionNotSupported)
+	}
+
+	ecu.changeSession(defaultSession)
+	ecu.routines = nil
+
+	return positive(data)
+}
+
+// writeDataByIdentifier changes a writable data record.
+func (ecu *ECU) writeDataByIdentifier(data []byte) []byte {
+	if len(data) < 4 {
+		return negative(data[0], nrcIncorrectMessageLength)
+	}
+
+	did := uint16(data[1])<<8 | uint16(data[2])
+
+	record, ok := ecu.DataIdentifiers[did]
+
+	switch {
+	case !ok || !slices.Contains(ecu.WritableDataIdentifiers, did):
+		return negative(data[0], nrcRequestOutOfRange)
+	case ecu.Session <= defaultSession:
+		return negative(data[0], nrcNotSupportedInSession)
+	case ecu.SeedKey != nil && ecu.security.unlocked == 0:
+		return negative(data[0], nrcSecurityAccessDenied)
+	case len(data[3:]) != len(record):
+		return negative(data[0], nrcIncorrectMessageLength)
+	}
+
+	ecu.DataIdentifiers[did] = slices.Clone(data[3:])
+
+	return []byte{data[0] + 0x40, data[1], data[2]}
+}
+
+// routineControl starts and stops routines and answers with their results. Results can be requested once a routine
+// was started, and only running routines can be stopped.
+func (ecu *ECU) routineControl(data []byte) []byte {
+	switch {
+	case len(data) < 4:
+		return negative(data[0], nrcIncorrectMessageLength)
+	case ecu.Session <= defaultSession:
+		return negative(data[0], nrcNotSupportedInSession)
+	}
+
+	routine := uint16(data[2])<<8 | uint16(data[3])
+
+	results, ok := ecu.Routines[routine]
+	if !ok {
+		return negative(data[0], nrcRequestOutOfRange)
+	}
+
+	running, started := ecu.routines[routine]
+	response := []byte{data[0] + 0x40, data[1], data[2], data[3]}
+
+	switch data[1] {
+	case 0x01:
+		if ecu.routines == nil {
+			ecu.routines = map[uint16]bool{}
+		}
+
+		ecu.routines[routine] = true
+
+		return response
+	case 0x02:
+		if !running {
+			return negative(data[0], nrcRequestSequenceError)
+		}
+
+		ecu.routines[routine] = false
+
+		return response
+	case 0x03:
+		if !started {
+			return negative(data[0], nrcRequestSequenceError)
+		}
+
+		return append(response, results...)
+	}
+
+	return negative(data[0], nrcSubFunctionNotSupported)
+}
+
+// inputOutputControl takes or returns the control of an input or output and answers with its state.
+func (ecu *ECU) inputOutputControl(data []byte) []byte {
+	switch {
+	case len(data) < 4:
+		return negative(data[0], nrcIncorrectMessageLength)
+	case ecu.Session <= defaultSession:
+		return negative(data[0], nrcNotSupportedInSession)
+	}
+
+	did := uint16(data[1])<<8 | uint16(data[2])
+
+	state, ok := ecu.IOControls[did]
+	if !ok {
+		return negative(data[0], nrcRequestOutOfRange)
+	}
+
+	if adjusted, ok := ecu.adjusted[did]; ok {
+		state = adjusted
+	}
+
+	if ecu.adjusted == nil {
+		ecu.adjusted = map[uint16][]byte{}
+	}
+
+	switch data[3] {
+	case 0x00:
+		delete(ecu.adjusted, did)
+		state = ecu.IOControls[did]
+	case 0x01:
+		state = ecu.IOControls[did]
+		ecu.adjusted[did] = state
+	case 0x02:
+		ecu.adjusted[did] = state
+	case 0x03:
+		// The control state may be followed by an enable mask, which the emulated ECU ignores.
+		if len(data) < 4+len(state) {
+			return negative(data[0], nrcIncorrectMessageLength)
+		}
+
+		state = slices.Clone(data[4 : 4+len(state)])
+		ecu.adjusted[did] = state
+	default:
+		return negative(data[0], nrcRequestOutOfRange)
+	}
+
+	return append([]byte{data[0] + 0x40, data[1], data[2], data[3]}, state...)
+}
+
+// securityState is the SecurityAccess state of an ECU.
+type securityState struct {
+	// unlocked is the unlocked security level, zero if none is.
+	unlocked byte
+	// seedLevel is the level whose seed was sent last, waiting for its key.
+	seedLevel byte
+	seed      []byte
+	seeds     uint32
+	failed    int
+	delayed   time.Time
+}
+
+// securityAccess answers a SecurityAccess request at now: odd sub-functions request the seed of a level, even ones
+// send its key. Too many invalid keys delay further attempts.
+func (ecu *ECU) securityAccess(data []byte, now time.Time) []byte {
+	s := &ecu.security
+
+	switch {
+	case len(data) < 2:
+		return negative(data[0], nrcIncorrectMessageLength)
+	case ecu.Session <= defaultSession:
+		return negative(data[0], nrcNotSupportedInSession)
+	case data[1] == 0 || data[1] > 0x7E:
+		return negative(data[0], nrcSubFunctionNotSupported)
+	case data[1]%2 == 1:
+		if len(data) != 2 {
+			return negative(data[0], nrcIncorrectMessageLength)
+		}
+
+		if now.Before(s.delayed) {
+			return negative(data[0], nrcTimeDelayNotExpired)
+		}
+
+		if s.unlocked == data[1] {
+			return []byte{data[0] + 0x40, data[1], 0x00, 0x00, 0x00, 0x00}
+		}
+
+		s.seeds++
+		s.seed = []byte{0x5E, 0xED, byte(s.seeds >> 8), byte(s.seeds)}
+		s.seedLevel = data[1]
+
+		return append([]byte{data[0] + 0x40, data[1]}, s.seed...)
+	}
+
+	level := data[1] - 1
+	if s.seedLevel != level {
+		return negative(data[0], nrcRequestSequenceError)
+	}
+
+	s.seedLevel = 0
+
+	key, err := ecu.SeedKey.Key(gobd2.SecurityLevel(level), s.seed)
+	if err != nil || !bytes.Equal(key, data[2:]) {
+		s.failed++
+
+		attempts := ecu.SecurityAttempts
+		if attempts == 0 {
+			attempts = defaultSecurityAttempts
+		}
+
+		if s.failed < attempts {
+			return negative(data[0], nrcInvalidKey)
+		}
+
+		delay := ecu.SecurityDelay
+		if delay == 0 {
+			delay = defaultSecurityDelay
+		}
+
+		s.failed = 0
+		s.delayed = now.Add(delay)
+
+		return negative(data[0], nrcExceededAttempts)
+	}
+
+	s.failed = 0
+	s.unlocked = level
+
+	return []byte{data[0] + 0x40, data[1]}
+}
+
+// DTCRecord is a trouble code reported by ReadDTCInformation (0x19).
+type DTCRecord struct {
+	// Code is the three byte code, e.g. 0x030100 for P0301 with failure type 00.
+	Code   uint32
+	Status byte
+	// Snapshots holds the values of the data identifiers of each snapshot record, keyed by record number.
+	Snapshots map[byte]map[uint16][]byte
+	// ExtendedData holds the extended data records, keyed by record number.
+	ExtendedData map[byte][]byte
+}
+
+// readDTCInformation answers the ReadDTCInformation sub-functions 0x01, 0x02, 0x04, 0x06 and 0x0A.
+func (ecu *ECU) readDTCInformation(data []byte) []byte {
+	if len(data) < 2 {
+		return negative(data[0], nrcIncorrectMessageLength)
+	}
+
+	availability := ecu.DTCStatusAvailability
+	if availability == 0 {
+		availability = 0xFF
+	}
+
+	response := []byte{data[0] + 0x40, data[1]}
+
+	switch data[1] {
+	case 0x01, 0x02:
+		if len(data) != 3 {
+			return negative(data[0], nrcIncorrectMessageLength)
+		}
+
+		var matching []DTCRecord
+
+		for _, record := range ecu.DTCRecords {
+			if record.Status&availability&data[2] != 0 {
+				matching = append(matching, record)
+			}
+		}
+
+		if data[1] == 0x01 {
+			return append(response, availability, 0x01, byte(len(matching)>>8), byte(len(matching)))
+		}
+
+		return appendDTCRecords(append(response, availability), matching)
+	case 0x0A:
+		return appendDTCRecords(append(response, availability), ecu.DTCRecords)
+	case 0x04, 0x06:
+		if len(data) != 6 {
+			return negative(data[0], nrcIncorrectMessageLength)
+		}
+
+		code := uint32(data[2])<<16 | uint32(data[3])<<8 | uint32(data[4])
+
+		i := slices.IndexFunc(ecu.DTCRecords, func(record DTCRecord) bool { return record.Code == code })
+		if i < 0 {
+			return negative(data[0], nrcRequestOutOfRange)
+		}
+
+		record := ecu.DTCRecords[i]
+		response = appendDTCRecords(response, ecu.DTCRecords[i:i+1])
+
+		var numbers []byte
+		if data[1] == 0x04 {
+			numbers = mapKeys(record.Snapshots)
+		} else {
+			numbers = mapKeys(record.ExtendedData)
+		}
+
+		if data[5] != 0xFF {
+			if !slices.Contains(numbers, data[5]) {
+				return negative(data[0], nrcRequestOutOfRange)
+			}
+
+			numbers = []byte{data[5]}
+		}
+
+		for _, number := range numbers {
+			if data[1] == 0x06 {
+				response = append(append(response, number), record.ExtendedData[number]...)
+
+				continue
+			}
+
+			values := record.Snapshots[number]
+			response = append(response, number, byte(len(values)))
+
+			for _, did := range mapKeys(values) {
+				response = append(append(response, byte(did>>8), byte(did)), values[did]...)
+			}
+		}
+
+		return response
+	}
+
+	return negative(data[0], nrcSubFunctionNotSupported)
+}
+
+// appendDTCRecords appends the codes of records to a ReadDTCInformation answer, each followed by its status.
+func appendDTCRecords(response []byte, records []DTCRecord) []byte {
+	for _, record := range records {
+		response = append(response, byte(record.Code>>16), byte(record.Code>>8), byte(record.Code), record.Status)
+	}
+
+	return response
+}
+
+// readDataByIdentifier answers a request for one or more data identifiers.
+func (ecu *ECU) readDataByIdentifier(identifiers []byte) []byte {
+	if len(identifiers) == 0 || len(identifiers)%2 != 0 {
+		return negative(serviceReadDataByIdentifier, nrcIncorrectMessageLength)
+	}
+
+	response := []byte{serviceReadDataByIdentifier + 0x40}
+
+	for i := 0; i < len(identifiers); i += 2 {
+		record, ok := ecu.DataIdentifiers[uint16(identifiers[i])<<8|uint16(identifiers[i+1])]
+		if !ok {
+			return negative(serviceReadDataByIdentifier, nrcRequestOutOfRange)
+		}
+
+		response = append(response, identifiers[i], identifiers[i+1])
+		response = append(response, record...)
+	}
+
+	return response
+}
+
+// positive returns the positive response to a request with a sub-function, echoing the request's parameters, or
+// nil if the request suppresses it.
+func positive(request []byte) []byte {
+	if request[1]&suppressPositiveResponse != 0 {
+		return nil
+	}
+
+	return append([]byte{request[0] + 0x40}, request[1:]...)
+}
+
+// negative returns a negative response to service.
+func negative(service, code byte) []byte {
+	return []byte{0x7F, service, code}
+}
@@ -0,0 +1,446 @@
+package emulator
+
+import (
+	"cmp"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+)
+
+// Default request headers, used by the adapter until a header is set with ATSH.
+const (
+	FunctionalHeader11Bit uint32 = 0x7DF
+	FunctionalHeader29Bit uint32 = 0x18DB33F1
+	FunctionalHeaderJ1850 uint32 = 0x686AF1
+)
+
+// testerAddress is the source address of the adapter on 29-bit CAN and legacy protocols.
+const testerAddress = 0xF1
+
+// Request is a diagnostic request as sent by the adapter on the vehicle network.
+type Request struct {
+	// Header is the identifier the request is sent with, e.g. 0x7DF or 0x7E0 on 11-bit CAN.
+	Header uint32
+	Data   []byte
+}
+
+// Response is the answer of a single ECU to a Request.
+type Response struct {
+	// Header is the identifier of the answering ECU, e.g. 0x7E8 on 11-bit CAN, 0x18DAF110 on 29-bit CAN or the
+	// ECU's source address on legacy protocols.
+	Header uint32
+	Data   []byte
+}
+
+// Vehicle answers the requests the emulated adapter sends on the vehicle network.
+type Vehicle interface {
+	// Protocol returns the protocol the vehicle communicates with.
+	Protocol() gobd2.Protocol
+	// Handle returns the answers of every ECU that responds to request, none if no ECU answers.
+	Handle(request Request) []Response
+}
+
+// ECU is a control unit answering the standard OBD-II services from its tables.
+type ECU struct {
+	// Address is the identifier the ECU answers with, in the format of Response.Header.
+	Address uint32
+	// PIDs holds the mode 01 data of each supported PID. The "supported PIDs" ranges are derived from it.
+	PIDs map[byte][]byte
+	// InfoTypes holds the mode 09 records, e.g. the VIN or the ECU name, without the leading item count.
+	InfoTypes map[byte][]byte
+	// DTCs lists the stored trouble codes reported by mode 03, e.g. "P0301". Mode 04 clears them.
+	DTCs []string
+	// PendingDTCs lists the pending trouble codes reported by mode 07. Mode 04 clears them.
+	PendingDTCs []string
+	// PermanentDTCs lists the permanent trouble codes reported by mode 0A. Mode 04 does not clear them.
+	PermanentDTCs []string
+	// TestResults holds the mode 06 on-board monitoring test results, keyed by monitor ID.
+	TestResults map[byte][]byte
+	// FreezeFrame holds the mode 02 data of frame 0, keyed by PID. PID 02 is the trouble code that stored the
+	// frame. Mode 04 clears it.
+	FreezeFrame map[byte][]byte
+	// Services holds raw answers keyed by the request in hex, e.g. "22F190". They take precedence over the
+	// standard services.
+	Services map[string][]byte
+	// DataIdentifiers holds the UDS data records read with ReadDataByIdentifier (0x22), keyed by identifier.
+	DataIdentifiers map[uint16][]byte
+	// WritableDataIdentifiers lists the data identifiers WriteDataByIdentifier (0x2E) can change, nil if the ECU
+	// does not support it. Records are written outside the default session only and, if the ECU supports
+	// SecurityAccess, once a security level is unlocked. They keep their length.
+	WritableDataIdentifiers []uint16
+	// Session is the active UDS diagnostic session, changed with DiagnosticSessionControl (0x10). Zero and 0x01
+	// are the default session.
+	Session byte
+	// SessionTimeout is the time without requests after which the ECU falls back to the default session, zero to
+	// stay in the session.
+	SessionTimeout time.Duration
+	// DTCRecords lists the trouble codes reported by ReadDTCInformation (0x19), nil if the ECU does not support
+	// it. ClearDiagnosticInformation (0x14) clears them.
+	DTCRecords []DTCRecord
+	// DTCStatusAvailability holds the status bits the ECU supports, all of them if zero.
+	DTCStatusAvailability byte
+	// SeedKey computes the keys the ECU accepts for SecurityAccess (0x27), nil if the ECU does not support it.
+	// Security levels can only be unlocked outside the default session.
+	SeedKey gobd2.SeedKeyAlgorithm
+	// SecurityAttempts is the number of invalid keys after which the ECU delays SecurityAccess, 3 if zero.
+	SecurityAttempts int
+	// SecurityDelay is how long SecurityAccess is delayed after too many invalid keys, 10 seconds if zero.
+	SecurityDelay time.Duration
+	// Routines holds the results of the routines the ECU runs with RoutineControl (0x31), keyed by identifier, nil
+	// if the ECU does not support it. Routines can only be started outside the default session.
+	Routines map[uint16][]byte
+	// IOControls holds the state of the inputs and outputs controlled with InputOutputControlByIdentifier (0x2F),
+	// keyed by identifier, nil if the ECU does not support it. Control can only be taken outside the default
+	// session and is returned to the ECU when the session changes.
+	IOControls map[uint16][]byte
+	// ResponsePending is the number of "response pending" answers (7F xx 78) the ECU sends before answering a
+	// physically addressed UDS request.
+	ResponsePending int
+
+	lastRequest time.Time
+	security    securityState
+	// routines reports whether each started routine is still running.
+	routines map[uint16]bool
+	// adjusted holds the state of the inputs and outputs under the tester's control.
+	adjusted map[uint16][]byte
+}
+
+// StaticVehicle is a Vehicle made of ECUs with fixed tables. It is safe for concurrent use; Update changes the
+// tables while adapters are connected.
+type StaticVehicle struct {
+	mu       sync.Mutex
+	protocol gobd2.Protocol
+	ecus     []*ECU
+}
+
+// NewStaticVehicle creates a vehicle communicating with protocol, made of the given ECUs.
+func NewStaticVehicle(protocol gobd2.Protocol, ecus ...*ECU) *StaticVehicle {
+	return &StaticVehicle{protocol: protocol, ecus: ecus}
+}
+
+// NewDemoVehicle creates a vehicle on 11-bit 500 kbit/s CAN with an engine and a transmission control unit,
+// reporting plausible values for a warm engine at idle.
+func NewDemoVehicle() *StaticVehicle {
+	engine := &ECU{
+		Address: 0x7E8,
+		PIDs: map[byte][]byte{
+			0x01: {0x00, 0x07, 0xE5, 0x00},
+			0x04: {0x33},
+			0x05: {0x7B},
+			0x0C: {0x0C, 0x80},
+			0x0D: {0x00},
+			0x0F: {0x41},
+			0x10: {0x01, 0x90},
+			0x11: {0x26},
+			0x1C: {0x06},
+			0x2F: {0x99},
+			0x42: {0x38, 0x2A},
+			0x46: {0x3D},
+		},
+		InfoTypes: map[byte][]byte{
+			0x02: []byte("1GOBD2EMULATOR001"),
+			0x0A: []byte("ECM\x00-EngineControl\x00\x00"),
+		},
+		DataIdentifiers: map[uint16][]byte{
+			0xF190: []byte("1GOBD2EMULATOR001"),
+		},
+	}
+
+	transmission := &ECU{
+		Address: 0x7E9,
+		PIDs: map[byte][]byte{
+			0x01: {0x00, 0x00, 0x00, 0x00},
+		},
+		InfoTypes: map[byte][]byte{
+			0x0A: []byte("TCM\x00-TransmissionCtl\x00"),
+		},
+	}
+
+	return NewStaticVehicle(gobd2.ProtocolCAN11Bit500, engine, transmission)
+}
+
+// Protocol returns the protocol the vehicle communicates with.
+func (v *StaticVehicle) Protocol() gobd2.Protocol {
+	return v.protocol
+}
+
+// Handle passes request to every ECU it is addressed to and collects their answers.
+func (v *StaticVehicle) Handle(request Request) []Response {
+	if len(request.Data) == 0 {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var responses []Response
+
+	for _, ecu := range v.ecus {
+		functional, addressed := ecu.addressedBy(v.protocol, request.Header)
+		if !addressed {
+			continue
+		}
+
+		data := ecu.handle(request.Data, functional)
+
+		if data != nil && !functional && isUDSService(request.Data[0]) {
+			for range ecu.ResponsePending {
+				responses = append(responses, Response{Header: ecu.Address, Data: []byte{0x7F, request.Data[0], 0x78}})
+			}
+		}
+
+		switch {
+		case data == nil:
+		case !v.protocol.IsCAN() && isDTCResponse(data):
+			for _, frame := range legacyDTCFrames(data) {
+				responses = append(responses, Response{Header: ecu.Address, Data: frame})
+			}
+		default:
+			responses = append(responses, Response{Header: ecu.Address, Data: data})
+		}
+	}
+
+	return responses
+}
+
+// Update runs fn with exclusive access to the vehicle's ECUs, so their tables can be changed safely.
+func (v *StaticVehicle) Update(fn func(ecus []*ECU)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fn(v.ecus)
+}
+
+// addressedBy reports whether a request sent with header reaches the ECU and whether it was addressed
+// functionally, to all ECUs at once.
+func (ecu *ECU) addressedBy(protocol gobd2.Protocol, header uint32) (functional, addressed bool) {
+	switch {
+	case !protocol.IsCAN():
+		// Legacy protocols are functional only, every ECU sees every request.
+		return true, true
+	case protocol.Is29Bit():
+		if header == FunctionalHeader29Bit {
+			return true, true
+		}
+
+		physical := 0x18DA0000 | (ecu.Address&0xFF)<<8 | testerAddress
+
+		return false, header == physical
+	case header == FunctionalHeader11Bit:
+		return true, true
+	}
+
+	return false, header == ecu.Address-8
+}
+
+// handle answers a request, returning nil if the ECU stays silent.
+func (ecu *ECU) handle(data []byte, functional bool) []byte {
+	ecu.expireSession(time.Now())
+
+	if response, ok := ecu.Services[strings.ToUpper(hex.EncodeToString(data))]; ok {
+		return response
+	}
+
+	mode := data[0]
+
+	switch mode {
+	case 0x01:
+		return ecu.currentData(data[1:])
+	case 0x02:
+		return ecu.freezeFrameData(data[1:])
+	case 0x03:
+		return dtcResponse(0x43, ecu.DTCs)
+	case 0x04:
+		ecu.DTCs, ecu.PendingDTCs, ecu.FreezeFrame = nil, nil, nil
+
+		return []byte{0x44}
+	case 0x06:
+		return ecu.testResults(data[1:])
+	case 0x07:
+		return dtcResponse(0x47, ecu.PendingDTCs)
+	case 0x0A:
+		return dtcResponse(0x4A, ecu.PermanentDTCs)
+	case 0x09:
+		return ecu.vehicleInfo(data[1:])
+	}
+
+	if functional {
+		return nil
+	}
+
+	if response, ok := ecu.handleUDS(data); ok {
+		return response
+	}
+
+	// serviceNotSupported
+	return []byte{0x7F, mode, 0x11}
+}
+
+// currentData answers a mode 01 request for one or more PIDs.
+func (ecu *ECU) currentData(pids []byte) []byte {
+	response := []byte{0x41}
+
+	for _, pid := range pids {
+		if pid%0x20 == 0 {
+			response = append(response, pid)
+			response = append(response, supportedBitmap(pid, mapKeys(ecu.PIDs))...)
+
+			continue
+		}
+
+		if value, ok := ecu.PIDs[pid]; ok {
+			response = append(response, pid)
+			response = append(response, value...)
+		}
+	}
+
+	if len(response) == 1 {
+		return nil
+	}
+
+	return response
+}
+
+// freezeFrameData answers a mode 02 request for a PID of frame 0.
+func (ecu *ECU) freezeFrameData(request []byte) []byte {
+	if len(request) == 0 || ecu.FreezeFrame == nil {
+		return nil
+	}
+
+	pid := request[0]
+	if len(request) > 1 && request[1] != 0x00 {
+		return nil
+	}
+
+	if pid%0x20 == 0 {
+		return append([]byte{0x42, pid, 0x00}, supportedBitmap(pid, mapKeys(ecu.FreezeFrame))...)
+	}
+
+	value, ok := ecu.FreezeFrame[pid]
+	if !ok {
+		return nil
+	}
+
+	return append([]byte{0x42, pid, 0x00}, value...)
+}
+
+// testResults answers a mode 06 request for a monitor ID.
+func (ecu *ECU) testResults(request []byte) []byte {
+	if len(request) == 0 || ecu.TestResults == nil {
+		return nil
+	}
+
+	mid := request[0]
+	if mid%0x20 == 0 {
+		return append([]byte{0x46, mid}, supportedBitmap(mid, mapKeys(ecu.TestResults))...)
+	}
+
+	value, ok := ecu.TestResults[mid]
+	if !ok {
+		return nil
+	}
+
+	return append([]byte{0x46, mid}, value...)
+}
+
+// vehicleInfo answers a mode 09 request.
+func (ecu *ECU) vehicleInfo(request []byte) []byte {
+	if len(request) == 0 {
+		return nil
+	}
+
+	infoType := request[0]
+	if infoType == 0x00 {
+		return append([]byte{0x49, 0x00}, supportedBitmap(0x00, mapKeys(ecu.InfoTypes))...)
+	}
+
+	record, ok := ecu.InfoTypes[infoType]
+	if !ok {
+		return nil
+	}
+
+	return append([]byte{0x49, infoType, 0x01}, record...)
+}
+
+// dtcResponse answers a mode 03 or 07 request in the CAN format, a count followed by two bytes per code.
+func dtcResponse(sid byte, codes []string) []byte {
+	response := []byte{sid, byte(len(codes))}
+	for _, code := range codes {
+		response = append(response, encodeDTC(code)...)
+	}
+
+	return response
+}
+
+// isDTCResponse reports whether data answers a request for trouble codes.
+func isDTCResponse(data []byte) bool {
+	return len(data) >= 2 && (data[0] == 0x43 || data[0] == 0x47 || data[0] == 0x4A)
+}
+
+// legacyDTCFrames converts a trouble code answer from the CAN format to the legacy one: no count, and three codes
+// per frame padded with zeros.
+func legacyDTCFrames(data []byte) [][]byte {
+	codes := data[2:]
+
+	var frames [][]byte
+
+	for i := 0; i == 0 || i < len(codes); i += 6 {
+		frame := append([]byte{data[0]}, codes[i:min(i+6, len(codes))]...)
+		frames = append(frames, append(frame, make([]byte, 7-len(frame))...))
+	}
+
+	return frames
+}
+
+// supportedBitmap encodes the "supported PIDs" bitmap of the range following base. The PID starting the next
+// range is announced if any PID beyond it is supported.
+func supportedBitmap(base byte, pids []byte) []byte {
+	bitmap := make([]byte, 4)
+
+	for _, pid := range pids {
+		if offset := int(pid) - int(base) - 1; offset >= 0 && offset < 32 {
+			bitmap[offset/8] |= 0x80 >> (offset % 8)
+		}
+	}
+
+	if slices.ContainsFunc(pids, func(pid byte) bool { return int(pid) > int(base)+0x20 }) {
+		bitmap[3] |= 0x01
+	}
+
+	return bitmap
+}
+
+// encodeDTC encodes a trouble code such as "P0301" into its two byte representation.
+func encodeDTC(code string) []byte {
+	if len(code) != 5 {
+		return []byte{0x00, 0x00}
+	}
+
+	system := strings.IndexByte("PCBU", code[0])
+	number, err := strconv.ParseUint(code[1:], 16, 16)
+
+	if system < 0 || err != nil || number > 0x3FFF {
+		return []byte{0x00, 0x00}
+	}
+
+	value := uint16(system)<<14 | uint16(number)
+
+	return []byte{byte(value >> 8), byte(value)}
+}
+
+// mapKeys returns the keys of m in ascending order.
+func mapKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
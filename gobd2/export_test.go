@@ -0,0 +1,9 @@
+package gobd2
+
+import dbus "github.com/godbus/dbus/v5"
+
+// NewBluezPort exposes the BlueZ data channel to the tests, over characteristic instead of a device on the system
+// bus.
+func NewBluezPort(characteristic dbus.BusObject, signals chan *dbus.Signal) (SerialPort, error) {
+	return newBluezPort(characteristic, signals, func() error { return nil })
+}
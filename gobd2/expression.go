@@ -0,0 +1,572 @@
+package gobd2
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Errors returned when compiling and evaluating expressions.
+var (
+	ErrInvalidExpression = errors.New("invalid expression")
+	ErrEvaluation        = errors.New("cannot evaluate expression")
+)
+
+// Expression is a compiled formula computing a value from the bytes of an answer and from other values, e.g.
+// "(A*256+B)/4" or "MAF / (speed * 0.001)". It is compiled once and can be evaluated any number of times,
+// concurrently. Expressions cannot do anything but compute a number.
+//
+// The language has:
+//   - numbers, e.g. 42 or 0.5, and hexadecimal numbers, e.g. 0xFF
+//   - the bytes of the answer as the variables A, B, C and so on
+//   - references to other values by name, e.g. speed or [Vehicle speed] for names that are not identifiers
+//   - the operators + - * / and % (remainder), & | << >> on integers, unary minus and parentheses
+//   - bit extraction, {A:7} being bit 7 of A
+//   - the functions abs, min, max, sqrt, pow, round, floor, ceil, log, log10, exp, bit(x, n), and signed,
+//     signed16 and signed32 converting an unsigned value of 8, 16 or 32 bits to a signed one
+type Expression struct {
+	source     string
+	root       node
+	references []string
+}
+
+// ExpressionError describes why an expression does not compile.
+type ExpressionError struct {
+	Source string
+	// Position is the position of the offending character in Source, starting at 1.
+	Position int
+	Message  string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("%q at %d: %s: %v", e.Source, e.Position, e.Message, ErrInvalidExpression)
+}
+
+// Unwrap returns ErrInvalidExpression.
+func (e *ExpressionError) Unwrap() error {
+	return ErrInvalidExpression
+}
+
+// CompileExpression parses source into an expression that can be evaluated many times.
+func CompileExpression(source string) (*Expression, error) {
+	p := &parser{source: source}
+	p.next()
+
+	root, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.token.kind != tokenEnd {
+		return nil, p.errorf("unexpected %q", p.token.text)
+	}
+
+	return &Expression{source: source, root: root, references: p.references}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// References returns the names of the values the expression refers to, in the order they first appear.
+func (e *Expression) References() []string {
+	return slices.Clone(e.references)
+}
+
+// Evaluate computes the value of the expression for data, whose bytes are the variables A, B, C and so on.
+func (e *Expression) Evaluate(data []byte) (float64, error) {
+	return e.EvaluateWith(data, nil)
+}
+
+// EvaluateWith computes the value of the expression for data and the values it refers to, keyed by name.
+func (e *Expression) EvaluateWith(data []byte, values map[string]float64) (float64, error) {
+	value, err := e.root.eval(&environment{data: data, values: values})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", e.source, err)
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%s: result is %v: %w", e.source, value, ErrEvaluation)
+	}
+
+	return value, nil
+}
+
+// environment holds what an expression is evaluated with.
+type environment struct {
+	data   []byte
+	values map[string]float64
+}
+
+// node is an element of a compiled expression.
+type node interface {
+	eval(env *environment) (float64, error)
+}
+
+type number float64
+
+func (n number) eval(*environment) (float64, error) {
+	return float64(n), nil
+}
+
+// byteVariable is the byte of the data at an index, A being the first.
+type byteVariable int
+
+func (v byteVariable) eval(env *environment) (float64, error) {
+	if int(v) >= len(env.data) {
+		return 0, fmt.Errorf("%c is beyond the %d bytes of data: %w", 'A'+rune(v), len(env.data), ErrEvaluation)
+	}
+
+	return float64(env.data[v]), nil
+}
+
+// reference is another value, looked up by name.
+type reference string
+
+func (r reference) eval(env *environment) (float64, error) {
+	value, ok := env.values[string(r)]
+	if !ok {
+		return 0, fmt.Errorf("no value for %q: %w", string(r), ErrEvaluation)
+	}
+
+	return value, nil
+}
+
+type negation struct {
+	operand node
+}
+
+func (n negation) eval(env *environment) (float64, error) {
+	value, err := n.operand.eval(env)
+
+	return -value, err
+}
+
+type binary struct {
+	operator    string
+	left, right node
+}
+
+func (b binary) eval(env *environment) (float64, error) {
+	left, err := b.left.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	right, err := b.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.operator {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "&":
+		return float64(int64(left) & int64(right)), nil
+	case "|":
+		return float64(int64(left) | int64(right)), nil
+	case "<<", ">>":
+		if right < 0 || right > 63 {
+			return 0, fmt.Errorf("shift by %v: %w", right, ErrEvaluation)
+		}
+
+		if b.operator == "<<" {
+			return float64(int64(left) << int64(right)), nil
+		}
+
+		return float64(int64(left) >> int64(right)), nil
+	}
+
+	if right == 0 {
+		return 0, fmt.Errorf("division by zero: %w", ErrEvaluation)
+	}
+
+	if b.operator == "%" {
+		return math.Mod(left, right), nil
+	}
+
+	return left / right, nil
+}
+
+// call is a call of a built-in function.
+type call struct {
+	function function
+	args     []node
+}
+
+func (c call) eval(env *environment) (float64, error) {
+	args := make([]float64, len(c.args))
+
+	for i, arg := range c.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return 0, err
+		}
+
+		args[i] = value
+	}
+
+	return c.function.apply(args)
+}
+
+// function is a built-in function taking between minArgs and maxArgs arguments, no limit if maxArgs is negative.
+type function struct {
+	minArgs, maxArgs int
+	apply            func(args []float64) (float64, error)
+}
+
+func unary(f func(float64) float64) function {
+	return function{1, 1, func(args []float64) (float64, error) { return f(args[0]), nil }}
+}
+
+// signedOf converts an unsigned value of bits bits to a signed one.
+func signedOf(bits uint) function {
+	return unary(func(x float64) float64 {
+		value := int64(x) & (1<<bits - 1)
+		if value >= 1<<(bits-1) {
+			value -= 1 << bits
+		}
+
+		return float64(value)
+	})
+}
+
+var functions = map[string]function{
+	"abs":      unary(math.Abs),
+	"sqrt":     unary(math.Sqrt),
+	"round":    unary(math.Round),
+	"floor":    unary(math.Floor),
+	"ceil":     unary(math.Ceil),
+	"log":      unary(math.Log),
+	"log10":    unary(math.Log10),
+	"exp":      unary(math.Exp),
+	"signed":   signedOf(8),
+	"signed16": signedOf(16),
+	"signed32": signedOf(32),
+	"pow":      {2, 2, func(args []float64) (float64, error) { return math.Pow(args[0], args[1]), nil }},
+	"min":      {1, -1, func(args []float64) (float64, error) { return slices.Min(args), nil }},
+	"max":      {1, -1, func(args []float64) (float64, error) { return slices.Max(args), nil }},
+	"bit": {2, 2, func(args []float64) (float64, error) {
+		if args[1] < 0 || args[1] > 63 {
+			return 0, fmt.Errorf("bit %v: %w", args[1], ErrEvaluation)
+		}
+
+		return float64(int64(args[0]) >> int64(args[1]) & 1), nil
+	}},
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenIdentifier
+	tokenName
+	tokenOperator
+	tokenInvalid
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+}
+
+// operators lists the operators and punctuation, two character ones first.
+var operators = []string{"<<", ">>", "+", "-", "*", "/", "%", "&", "|", "(", ")", "{", "}", ":", ","}
+
+// parser is a recursive descent parser for expressions.
+type parser struct {
+	source     string
+	position   int
+	token      token
+	references []string
+}
+
+// next reads the next token.
+func (p *parser) next() {
+	for p.position < len(p.source) && (p.source[p.position] == ' ' || p.source[p.position] == '\t') {
+		p.position++
+	}
+
+	start := p.position
+	if start == len(p.source) {
+		p.token = token{kind: tokenEnd, position: start}
+
+		return
+	}
+
+	c := p.source[start]
+
+	switch {
+	case isDigit(c) || c == '.':
+		for p.position < len(p.source) && (isLetter(p.source[p.position]) || isDigit(p.source[p.position]) ||
+			p.source[p.position] == '.') {
+			p.position++
+		}
+
+		p.token = token{kind: tokenNumber, text: p.source[start:p.position], position: start}
+	case isLetter(c):
+		for p.position < len(p.source) && (isLetter(p.source[p.position]) || isDigit(p.source[p.position])) {
+			p.position++
+		}
+
+		p.token = token{kind: tokenIdentifier, text: p.source[start:p.position], position: start}
+	case c == '[':
+		end := strings.IndexByte(p.source[start:], ']')
+		if end < 0 {
+			p.position = len(p.source)
+			p.token = token{kind: tokenInvalid, text: p.source[start:], position: start}
+
+			return
+		}
+
+		p.position += end + 1
+		p.token = token{kind: tokenName, text: strings.TrimSpace(p.source[start+1 : start+end]), position: start}
+	default:
+		for _, operator := range operators {
+			if strings.HasPrefix(p.source[start:], operator) {
+				p.position += len(operator)
+				p.token = token{kind: tokenOperator, text: operator, position: start}
+
+				return
+			}
+		}
+
+		p.position++
+		p.token = token{kind: tokenInvalid, text: string(c), position: start}
+	}
+}
+
+// errorf returns an error about the current token.
+func (p *parser) errorf(format string, args ...any) error {
+	return &ExpressionError{Source: p.source, Position: p.token.position + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+// expect consumes the operator text, failing if the current token is another one.
+func (p *parser) expect(text string) error {
+	if p.token.kind != tokenOperator || p.token.text != text {
+		return p.errorf("missing %s", text)
+	}
+
+	p.next()
+
+	return nil
+}
+
+// levels lists the binary operators by increasing precedence.
+var levels = [][]string{{"|"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%"}}
+
+// parseExpression parses a complete expression.
+func (p *parser) parseExpression() (node, error) {
+	return p.parseLevel(0)
+}
+
+// parseLevel parses operands separated by the operators of a precedence level.
+func (p *parser) parseLevel(level int) (node, error) {
+	if level == len(levels) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseLevel(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.token.kind == tokenOperator && slices.Contains(levels[level], p.token.text) {
+		operator := p.token.text
+		p.next()
+
+		right, err := p.parseLevel(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseUnary parses an operand, possibly negated.
+func (p *parser) parseUnary() (node, error) {
+	if p.token.kind == tokenOperator && p.token.text == "-" {
+		p.next()
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return negation{operand}, nil
+	}
+
+	return p.parseOperand()
+}
+
+// parseOperand parses a number, a variable, a reference, a function call, a bit extraction or a parenthesized
+// expression.
+func (p *parser) parseOperand() (node, error) {
+	current := p.token
+
+	switch current.kind {
+	case tokenNumber:
+		value, err := parseNumber(current.text)
+		if err != nil {
+			return nil, p.errorf("malformed number %q", current.text)
+		}
+
+		p.next()
+
+		return number(value), nil
+	case tokenIdentifier:
+		p.next()
+
+		if p.token.kind == tokenOperator && p.token.text == "(" {
+			return p.parseCall(current)
+		}
+
+		if len(current.text) == 1 && current.text[0] >= 'A' && current.text[0] <= 'Z' {
+			return byteVariable(current.text[0] - 'A'), nil
+		}
+
+		return p.reference(current.text), nil
+	case tokenName:
+		if current.text == "" {
+			return nil, p.errorf("empty name")
+		}
+
+		p.next()
+
+		return p.reference(current.text), nil
+	case tokenEnd:
+		return nil, p.errorf("unexpected end")
+	case tokenInvalid:
+		if current.text[0] == '[' {
+			return nil, p.errorf("missing ]")
+		}
+	case tokenOperator:
+		switch current.text {
+		case "(":
+			p.next()
+
+			inner, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+
+			return inner, p.expect(")")
+		case "{":
+			return p.parseBit()
+		}
+	}
+
+	return nil, p.errorf("unexpected %q", current.text)
+}
+
+// parseCall parses the arguments of a call of the function name, the current token being the opening parenthesis.
+func (p *parser) parseCall(name token) (node, error) {
+	f, ok := functions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, &ExpressionError{Source: p.source, Position: name.position + 1, Message: fmt.Sprintf("unknown function %q", name.text)}
+	}
+
+	p.next()
+
+	var args []node
+
+	for p.token.kind != tokenOperator || p.token.text != ")" {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	if len(args) < f.minArgs || f.maxArgs >= 0 && len(args) > f.maxArgs {
+		return nil, &ExpressionError{Source: p.source, Position: name.position + 1,
+			Message: fmt.Sprintf("%s takes %s, not %d", name.text, arity(f), len(args))}
+	}
+
+	p.next()
+
+	return call{function: f, args: args}, nil
+}
+
+// arity describes the number of arguments a function takes.
+func arity(f function) string {
+	switch {
+	case f.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", f.minArgs)
+	case f.minArgs == 1 && f.maxArgs == 1:
+		return "1 argument"
+	}
+
+	return fmt.Sprintf("%d arguments", f.minArgs)
+}
+
+// parseBit parses a bit extraction like {A:7}, the current token being the opening brace.
+func (p *parser) parseBit() (node, error) {
+	p.next()
+
+	operand, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	value, err := parseNumber(p.token.text)
+	if p.token.kind != tokenNumber || err != nil || value != math.Trunc(value) || value < 0 || value > 63 {
+		return nil, p.errorf("bit number must be an integer from 0 to 63")
+	}
+
+	p.next()
+
+	return call{function: functions["bit"], args: []node{operand, number(value)}}, p.expect("}")
+}
+
+// reference records a reference to the value name.
+func (p *parser) reference(name string) node {
+	if !slices.Contains(p.references, name) {
+		p.references = append(p.references, name)
+	}
+
+	return reference(name)
+}
+
+// parseNumber parses a decimal or hexadecimal number.
+func parseNumber(text string) (float64, error) {
+	if len(text) > 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') {
+		value, err := strconv.ParseUint(text[2:], 16, 64)
+
+		return float64(value), err
+	}
+
+	return strconv.ParseFloat(text, 64)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
@@ -0,0 +1,128 @@
+package gobd2_test
+
+import (
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpression_Evaluate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		source   string
+		data     []byte
+		expected float64
+	}{
+		{"(A*256+B)/4", []byte{0x1A, 0xF8}, 1726},
+		{"A-40", []byte{0x7B}, 83},
+		{"A*100/128-100", []byte{0x80}, 0},
+		{"-A + 2 * (B - 1)", []byte{3, 5}, 5},
+		{"0.5 * A", []byte{9}, 4.5},
+		{"42", nil, 42},
+		{"0x10 + 0XfF", nil, 271},
+		{"signed(A)", []byte{0xF6}, -10},
+		{"signed16(A*256+B)/100", []byte{0xFF, 0x38}, -2},
+		{"signed32(0xFFFFFFFF)", nil, -1},
+		{"{A:7} + {A:0}*2", []byte{0x81}, 3},
+		{"bit(B, 1)", []byte{0x00, 0x02}, 1},
+		{"(A & 0xF0) >> 4 | 1 << 4", []byte{0xA5}, 26},
+		{"A % 7", []byte{30}, 2},
+		{"max(A, B, 3) - min(A, B)", []byte{1, 2}, 2},
+		{"sqrt(pow(A, 2) + pow(B, 2))", []byte{3, 4}, 5},
+		{"round(A/3) + floor(2.7) + ceil(0.2) + abs(-1)", []byte{10}, 7},
+		{"log10(1000) + exp(0) + log(1)", nil, 4},
+		{"Round(A / 4)", []byte{10}, 3},
+	}
+
+	for _, tt := range tests {
+		expression, err := gobd2.CompileExpression(tt.source)
+		require.NoError(t, err, tt.source)
+		require.Equal(t, tt.source, expression.String())
+
+		value, err := expression.Evaluate(tt.data)
+		require.NoError(t, err, tt.source)
+		require.InDelta(t, tt.expected, value, 1e-9, tt.source)
+	}
+}
+
+func TestCompileExpression_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"(A*256+B/4":  `"(A*256+B/4" at 11: missing ): invalid expression`,
+		"A*":          `"A*" at 3: unexpected end: invalid expression`,
+		"A $ B":       `"A $ B" at 3: unexpected "$": invalid expression`,
+		"1.2.3":       `"1.2.3" at 1: malformed number "1.2.3": invalid expression`,
+		"A B":         `"A B" at 3: unexpected "B": invalid expression`,
+		"foo(A)":      `"foo(A)" at 1: unknown function "foo": invalid expression`,
+		"pow(A)":      `"pow(A)" at 1: pow takes 2 arguments, not 1: invalid expression`,
+		"min()":       `"min()" at 1: min takes at least 1 arguments, not 0: invalid expression`,
+		"abs(A, B)":   `"abs(A, B)" at 1: abs takes 1 argument, not 2: invalid expression`,
+		"max(A B)":    `"max(A B)" at 7: missing ,: invalid expression`,
+		"{A:8.5}":     `"{A:8.5}" at 4: bit number must be an integer from 0 to 63: invalid expression`,
+		"{A:7":        `"{A:7" at 5: missing }: invalid expression`,
+		"[Engine RPM": `"[Engine RPM" at 1: missing ]: invalid expression`,
+		"[ ] * 2":     `"[ ] * 2" at 1: empty name: invalid expression`,
+	}
+
+	for source, message := range tests {
+		_, err := gobd2.CompileExpression(source)
+		require.ErrorIs(t, err, gobd2.ErrInvalidExpression, source)
+		require.EqualError(t, err, message)
+	}
+}
+
+func TestExpression_Evaluate_Errors(t *testing.T) {
+	t.Parallel()
+
+	expression, err := gobd2.CompileExpression("(A*256+B)/4")
+	require.NoError(t, err)
+
+	_, err = expression.Evaluate([]byte{0x1A})
+	require.ErrorIs(t, err, gobd2.ErrEvaluation)
+	require.EqualError(t, err, "(A*256+B)/4: B is beyond the 1 bytes of data: cannot evaluate expression")
+
+	expression, err = gobd2.CompileExpression("100/A")
+	require.NoError(t, err)
+
+	_, err = expression.Evaluate([]byte{0})
+	require.ErrorIs(t, err, gobd2.ErrEvaluation)
+}
+
+func TestExpressionError(t *testing.T) {
+	t.Parallel()
+
+	_, err := gobd2.CompileExpression("A + * B")
+
+	var expressionErr *gobd2.ExpressionError
+	require.ErrorAs(t, err, &expressionErr)
+	require.Equal(t, gobd2.ExpressionError{Source: "A + * B", Position: 5, Message: `unexpected "*"`}, *expressionErr)
+}
+
+func TestExpression_References(t *testing.T) {
+	t.Parallel()
+
+	expression, err := gobd2.CompileExpression("MAF / ([Vehicle speed] * 0.001) + MAF * 0")
+	require.NoError(t, err)
+	require.Equal(t, []string{"MAF", "Vehicle speed"}, expression.References())
+
+	value, err := expression.EvaluateWith(nil, map[string]float64{"MAF": 4, "Vehicle speed": 50})
+	require.NoError(t, err)
+	require.InDelta(t, 80, value, 1e-9)
+
+	_, err = expression.EvaluateWith(nil, map[string]float64{"MAF": 4})
+	require.ErrorIs(t, err, gobd2.ErrEvaluation)
+	require.ErrorContains(t, err, `no value for "Vehicle speed"`)
+
+	_, err = expression.EvaluateWith(nil, map[string]float64{"MAF": 4, "Vehicle speed": 0})
+	require.ErrorContains(t, err, "division by zero")
+
+	expression, err = gobd2.CompileExpression("exp(Boost)")
+	require.NoError(t, err)
+
+	_, err = expression.EvaluateWith(nil, map[string]float64{"Boost": 1000})
+	require.ErrorIs(t, err, gobd2.ErrEvaluation)
+	require.ErrorContains(t, err, "result is +Inf")
+}
@@ -0,0 +1,322 @@
+package gobd2
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ChipFamily identifies the chip an adapter is built around.
+type ChipFamily int
+
+// Chip families recognized by Fingerprint.
+const (
+	ChipUnknown ChipFamily = iota
+	ChipGenuineELM327
+	ChipELM327Clone
+	ChipSTN
+	ChipVLinker
+)
+
+func (f ChipFamily) String() string {
+	switch f {
+	case ChipGenuineELM327:
+		return "ELM327"
+	case ChipELM327Clone:
+		return "ELM327 clone"
+	case ChipSTN:
+		return "STN"
+	case ChipVLinker:
+		return "vLinker"
+	case ChipUnknown:
+	}
+
+	return "unknown"
+}
+
+// Capabilities is a set of optional features an adapter supports.
+type Capabilities uint32
+
+// Optional adapter features.
+const (
+	// CapabilityProgrammableParameters means the adapter supports programmable parameters (ATPP).
+	CapabilityProgrammableParameters Capabilities = 1 << iota
+	// CapabilityVoltage means the adapter reports the supply voltage (ATRV).
+	CapabilityVoltage
+	// CapabilityAdaptiveTiming means the adapter supports adaptive timing (ATAT).
+	CapabilityAdaptiveTiming
+	// CapabilityCANReceiveFilter means the adapter honors CAN receive address filters (ATCRA).
+	CapabilityCANReceiveFilter
+	// CapabilityBaudRateDivisor means the adapter can switch to a higher baud rate (ATBRD).
+	CapabilityBaudRateDivisor
+	// CapabilityMultiPID means the adapter forwards requests for several PIDs at once.
+	CapabilityMultiPID
+	// CapabilitySTN means the adapter understands the STN extended command set (ST commands).
+	CapabilitySTN
+)
+
+// Has reports whether all capabilities in c are present.
+func (caps Capabilities) Has(c Capabilities) bool {
+	return caps&c == c
+}
+
+// CapabilityProvider is implemented by connectors that know the capabilities of their adapter. Library features
+// that depend on optional adapter support consult it: features clones often lack, like multi-PID requests, are only
+// used once a fingerprint confirms them, and features used by default, like receive filters, are only dropped when
+// a fingerprint rules them out.
+type CapabilityProvider interface {
+	Capabilities() Capabilities
+	// Adapter returns the fingerprint the capabilities were derived from, nil if the adapter was not identified.
+	Adapter() *AdapterFingerprint
+}
+
+// adapterCapabilities returns the capabilities of the adapter behind connector, and false if they are unknown
+// because the connector does not provide them or the adapter was not fingerprinted.
+func adapterCapabilities(connector Connector) (Capabilities, bool) {
+	provider, ok := connector.(CapabilityProvider)
+	if !ok || provider.Adapter() == nil {
+		return 0, false
+	}
+
+	return provider.Capabilities(), true
+}
+
+// AdapterFingerprint describes an adapter identified by Fingerprint.
+type AdapterFingerprint struct {
+	Family ChipFamily
+	// Identification is the answer to ATI, e.g. "ELM327 v1.5".
+	Identification string
+	// Version is the firmware version claimed in Identification, e.g. "1.5".
+	Version string
+	// Description is the answer to AT@1, empty if the adapter does not support it.
+	Description string
+	// STNIdentification is the answer to STI on STN based adapters, e.g. "STN1155 v4.2.0".
+	STNIdentification string
+	// Device is the answer to STDI on STN based adapters, e.g. "OBDLink MX+ r1.2".
+	Device       string
+	Capabilities Capabilities
+}
+
+// genuineVersions lists the firmware versions released by ELM Electronics. Notably there never was a v1.5.
+var genuineVersions = []string{
+	"1.0", "1.0a", "1.1", "1.2", "1.2a", "1.3", "1.3a", "1.4", "1.4a", "1.4b", "2.0", "2.1", "2.2", "2.3",
+}
+
+const genuineDescription = "OBDII to RS232 Interpreter"
+
+var versionPattern = regexp.MustCompile(`v(\d+\.\d+[a-z]?)`)
+
+// Fingerprint runs a series of probes against the adapter to identify its chip family and the optional features
+// it really supports. Some probes need a vehicle that answers; without one, features that can only be verified
+// against a vehicle are assumed from the chip family and firmware version.
+func (e *ELM327) Fingerprint() (*AdapterFingerprint, error) {
+	id, err := e.Identify()
+	if err != nil {
+		return nil, err
+	}
+
+	fp := &AdapterFingerprint{Identification: id}
+	if match := versionPattern.FindStringSubmatch(id); match != nil {
+		fp.Version = match[1]
+	}
+
+	if fp.Description, err = e.optionalQuery("AT@1"); err != nil {
+		return nil, err
+	}
+
+	if fp.STNIdentification, err = e.optionalQuery("STI"); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(fp.STNIdentification, "STN") {
+		if fp.Device, err = e.optionalQuery("STDI"); err != nil {
+			return nil, err
+		}
+	} else {
+		fp.STNIdentification = ""
+	}
+
+	if err := e.probeCapabilities(fp); err != nil {
+		return nil, err
+	}
+
+	fp.Family = classify(fp)
+
+	if err := e.probeVehicleCapabilities(fp); err != nil {
+		return nil, err
+	}
+
+	return fp, nil
+}
+
+// probeCapabilities checks the features that can be verified without a vehicle. The adaptive timing probe
+// enables the default mode, so a mode chosen by the init profile is restored afterwards.
+func (e *ELM327) probeCapabilities(fp *AdapterFingerprint) error {
+	if timing := restoreSetting(e.connector, settingAdaptiveTiming, "ATAT1"); timing != "ATAT1" {
+		defer e.connector.SendCommand(timing) //nolint:errcheck
+	}
+
+	probes := []struct {
+		command    CommandCode
+		capability Capabilities
+	}{
+		{"ATPPS", CapabilityProgrammableParameters},
+		{"ATRV", CapabilityVoltage},
+		{"ATAT1", CapabilityAdaptiveTiming},
+	}
+
+	for _, probe := range probes {
+		response, err := e.optionalQuery(probe.command)
+		if err != nil {
+			return err
+		}
+
+		if response != "" {
+			fp.Capabilities |= probe.capability
+		}
+	}
+
+	if fp.STNIdentification != "" {
+		fp.Capabilities |= CapabilitySTN
+	}
+
+	return nil
+}
+
+// classify derives the chip family from the answers collected so far.
+func classify(fp *AdapterFingerprint) ChipFamily {
+	ids := strings.ToUpper(fp.Identification + " " + fp.Description + " " + fp.Device)
+
+	switch {
+	case fp.Capabilities.Has(CapabilitySTN):
+		return ChipSTN
+	case strings.Contains(ids, "VLINKER") || strings.Contains(ids, "VGATE"):
+		return ChipVLinker
+	case !strings.HasPrefix(fp.Identification, "ELM327"):
+		return ChipUnknown
+	case slices.Contains(genuineVersions, fp.Version) && fp.Description == genuineDescription &&
+		fp.Capabilities.Has(CapabilityProgrammableParameters):
+		return ChipGenuineELM327
+	}
+
+	return ChipELM327Clone
+}
+
+// probeVehicleCapabilities checks the features that only show their real behavior against a vehicle. Clones
+// often acknowledge commands they silently ignore, so their answers alone cannot be trusted.
+func (e *ELM327) probeVehicleCapabilities(fp *AdapterFingerprint) error {
+	// Firmware versions compare correctly as strings, from "1.0" to "2.3".
+	switch {
+	case fp.Family == ChipELM327Clone:
+	case fp.Family != ChipGenuineELM327:
+		fp.Capabilities |= CapabilityMultiPID | CapabilityCANReceiveFilter | CapabilityBaudRateDivisor
+	default:
+		fp.Capabilities |= CapabilityMultiPID
+		if fp.Version >= "1.2" {
+			fp.Capabilities |= CapabilityBaudRateDivisor
+		}
+
+		if fp.Version >= "1.3" {
+			fp.Capabilities |= CapabilityCANReceiveFilter
+		}
+	}
+
+	response, err := e.connector.SendCommand(SupportedPIDsCommand1_20)
+	if err != nil {
+		return err
+	}
+
+	if responseError(response) != nil {
+		return nil // no vehicle, keep the assumptions
+	}
+
+	protocol, err := e.Protocol()
+	if err != nil {
+		return err
+	}
+
+	if protocol.IsCAN() {
+		filtered, err := e.receiveFilterHonored()
+		if err != nil {
+			return err
+		}
+
+		fp.Capabilities = setCapability(fp.Capabilities, CapabilityCANReceiveFilter, filtered)
+	}
+
+	multiPID, err := e.multiPIDAnswered()
+	if err != nil {
+		return err
+	}
+
+	fp.Capabilities = setCapability(fp.Capabilities, CapabilityMultiPID, multiPID)
+
+	return nil
+}
+
+// receiveFilterHonored filters on an address no ECU uses and checks that the vehicle's answer is suppressed. The
+// previous filter is restored afterwards.
+func (e *ELM327) receiveFilterHonored() (bool, error) {
+	restore := restoreSetting(e.connector, settingReceiveFilter, "ATCRA")
+
+	if _, err := e.connector.SendCommand("ATCRA7FF"); err != nil {
+		return false, err
+	}
+	defer e.connector.SendCommand(restore) //nolint:errcheck
+
+	response, err := e.connector.SendCommand(SupportedPIDsCommand1_20)
+	if err != nil {
+		return false, err
+	}
+
+	return errors.Is(responseError(response), ErrNoData), nil
+}
+
+// multiPIDAnswered requests two PIDs at once and checks that the answer carries both of them. The previous header
+// setting is restored afterwards.
+func (e *ELM327) multiPIDAnswered() (bool, error) {
+	restore := restoreSetting(e.connector, settingHeaders, "ATH0")
+
+	if _, err := e.connector.SendCommand("ATH1"); err != nil {
+		return false, err
+	}
+	defer e.connector.SendCommand(restore) //nolint:errcheck
+
+	response, err := e.connector.SendCommand("01000C")
+	if err != nil {
+		return false, err
+	}
+
+	frames, err := parseFrames(response)
+	if err != nil {
+		return false, nil //nolint:nilerr // an unusable answer means the request is not supported
+	}
+
+	for _, message := range assembleMessages(frames) {
+		// "41 00 xx xx xx xx" alone is the answer to the first PID only.
+		if len(message.Data) > 6 && message.Data[0] == modeCurrentData|positiveResponseBit {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// optionalQuery sends an informational command and returns an empty answer if the adapter does not know it.
+func (e *ELM327) optionalQuery(command CommandCode) (string, error) {
+	response, err := e.query(command)
+	if errors.Is(err, ErrUnknownCommand) {
+		return "", nil
+	}
+
+	return response, err
+}
+
+func setCapability(caps, c Capabilities, present bool) Capabilities {
+	if present {
+		return caps | c
+	}
+
+	return caps &^ c
+}
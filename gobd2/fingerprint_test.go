@@ -0,0 +1,147 @@
+package gobd2_test
+
+import (
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+func TestELM327_Fingerprint_CloneWithoutVehicle(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATI")).Return("ELM327 v1.5", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("AT@1")).Return("OBDII to RS232 Interpreter", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STI")).Return("?", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATPPS")).Return("?", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATRV")).Return("12.1V", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATAT1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand1_20).Return("SEARCHING...\rUNABLE TO CONNECT", nil)
+
+	fp, err := gobd2.NewELM327(mockConnector).Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, gobd2.ChipELM327Clone, fp.Family)
+	require.Equal(t, "1.5", fp.Version)
+	require.Equal(t, gobd2.CapabilityVoltage|gobd2.CapabilityAdaptiveTiming, fp.Capabilities)
+	mockConnector.AssertExpectations(t)
+}
+
+func TestELM327_Fingerprint_CloneIgnoringReceiveFilter(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATI")).Return("ELM327 v2.1", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("AT@1")).Return("?", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STI")).Return("?", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATPPS")).Return("?", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATRV")).Return("12.1V", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATAT1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand1_20).Return("41 00 BE 1F A8 13", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATDPN")).Return("A6", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATCRA7FF")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATCRA")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("01000C")).Return("7E8 06 41 00 BE 1F A8 13", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH0")).Return("OK", nil)
+
+	fp, err := gobd2.NewELM327(mockConnector).Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, gobd2.ChipELM327Clone, fp.Family)
+	require.False(t, fp.Capabilities.Has(gobd2.CapabilityCANReceiveFilter))
+	require.False(t, fp.Capabilities.Has(gobd2.CapabilityMultiPID))
+	mockConnector.AssertExpectations(t)
+}
+
+func TestELM327_Fingerprint_Genuine(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATI")).Return("ELM327 v2.2", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("AT@1")).Return("OBDII to RS232 Interpreter", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STI")).Return("?", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATPPS")).Return("00:FF F  01:FF F", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATRV")).Return("12.1V", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATAT1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand1_20).Return("41 00 BE 1F A8 13", nil).Once()
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATDPN")).Return("A6", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATCRA7FF")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand1_20).Return("NO DATA", nil).Once()
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATCRA")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("01000C")).Return(
+		"7E8 10 09 41 00 BE 1F A8 13\r7E8 21 0C 1A F8 00 00 00 00", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATH0")).Return("OK", nil)
+
+	fp, err := gobd2.NewELM327(mockConnector).Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, gobd2.ChipGenuineELM327, fp.Family)
+	require.True(t, fp.Capabilities.Has(gobd2.CapabilityCANReceiveFilter|gobd2.CapabilityMultiPID|
+		gobd2.CapabilityBaudRateDivisor|gobd2.CapabilityProgrammableParameters))
+	require.False(t, fp.Capabilities.Has(gobd2.CapabilitySTN))
+	mockConnector.AssertExpectations(t)
+}
+
+func TestELM327_Fingerprint_STN(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATI")).Return("ELM327 v1.4b", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("AT@1")).Return("OBDLink MX+", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STI")).Return("STN2255 v5.6.19", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STDI")).Return("OBDLink MX+ r1.2", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATPPS")).Return("00:FF F", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATRV")).Return("12.1V", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATAT1")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.SupportedPIDsCommand1_20).Return("NO DATA", nil)
+
+	fp, err := gobd2.NewELM327(mockConnector).Fingerprint()
+	require.NoError(t, err)
+	require.Equal(t, gobd2.ChipSTN, fp.Family)
+	require.Equal(t, "OBDLink MX+ r1.2", fp.Device)
+	require.True(t, fp.Capabilities.Has(gobd2.CapabilitySTN|gobd2.CapabilityBaudRateDivisor))
+	mockConnector.AssertExpectations(t)
+}
+
+func TestSerialConnector_Fingerprint_KeepsProfile(t *testing.T) {
+	t.Parallel()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+	profile.Headers = gobd2.ToggleOn
+	profile.AdaptiveTiming = gobd2.AdaptiveTimingAggressive
+	profile.ExtraCommands = []gobd2.CommandCode{"ATCRA7E8"}
+
+	connector := gobd2.NewSerialConnector("emulator", 38400, &emulator.Opener{Vehicle: emulator.NewDemoVehicle()},
+		gobd2.WithInitProfile(profile), gobd2.WithFingerprint())
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	require.True(t, connector.Capabilities().Has(gobd2.CapabilityCANReceiveFilter|gobd2.CapabilityMultiPID))
+
+	for name, expected := range map[string]gobd2.CommandCode{"ATH": "ATH1", "ATAT": "ATAT2", "ATCRA": "ATCRA7E8"} {
+		setting, ok := connector.AdapterSetting(name)
+		require.True(t, ok, name)
+		require.Equal(t, expected, setting, name)
+	}
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "7E8 03 41 0D 00", response, "headers and the receive filter stay as the profile set them")
+}
+
+// fingerprintedConnector is a mock connector for an adapter fingerprinted with the given capabilities.
+type fingerprintedConnector struct {
+	*MockConnector
+	capabilities gobd2.Capabilities
+}
+
+func (c fingerprintedConnector) Capabilities() gobd2.Capabilities {
+	return c.capabilities
+}
+
+func (c fingerprintedConnector) Adapter() *gobd2.AdapterFingerprint {
+	return &gobd2.AdapterFingerprint{Family: gobd2.ChipELM327Clone, Capabilities: c.capabilities}
+}
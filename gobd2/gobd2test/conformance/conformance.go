@@ -0,0 +1,212 @@
+// Package conformance provides a test suite verifying that a gobd2.Connector behaves like every gobd2 connector
+// must, against an ELM327 adapter emulated by the emulator package. It is kept apart from gobd2test so that the fake
+// connector does not pull the emulator and testify into the tests of its users.
+package conformance
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceTimeout is the command timeout connectors under test are configured with.
+const conformanceTimeout = 500 * time.Millisecond
+
+// Backend is the emulated adapter a connector under conformance test talks to.
+type Backend struct {
+	// Vehicle is the vehicle the adapter is connected to.
+	Vehicle emulator.Vehicle
+	// Options configure the adapter, e.g. with a fault injector.
+	Options []emulator.Option
+	// Timeout is the command timeout the connector must be configured with.
+	Timeout time.Duration
+}
+
+// ConnectorFactory creates a connector, not connected yet, that reaches an adapter emulated as described by
+// backend through the transport under test.
+type ConnectorFactory func(t *testing.T, backend Backend) gobd2.Connector
+
+// Run verifies that the connectors created by newConnector behave like every gobd2 connector must: connecting and
+// closing are idempotent, concurrent commands are serialized, commands time out without breaking later ones,
+// adapter errors and resets are reported alike and multi-frame answers arrive complete.
+func Run(t *testing.T, newConnector ConnectorFactory) {
+	t.Helper()
+
+	t.Run("ConnectClose", func(t *testing.T) {
+		t.Parallel()
+
+		connector := newConnector(t, Backend{Vehicle: emulator.NewDemoVehicle(), Timeout: conformanceTimeout})
+
+		require.NoError(t, connector.Close(), "closing before connecting")
+
+		_, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+		require.ErrorIs(t, err, gobd2.ErrNotConnected)
+
+		require.NoError(t, connector.Connect())
+		require.NoError(t, connector.Connect(), "connecting twice")
+		searchProtocol(t, connector)
+		requireResponse(t, connector, gobd2.VehicleSpeedCommand, "41 0D 00")
+
+		require.NoError(t, connector.Close())
+		require.NoError(t, connector.Close(), "closing twice")
+
+		_, err = connector.SendCommand(gobd2.VehicleSpeedCommand)
+		require.ErrorIs(t, err, gobd2.ErrNotConnected)
+
+		require.NoError(t, connector.Connect(), "reconnecting")
+		searchProtocol(t, connector)
+		requireResponse(t, connector, gobd2.VehicleSpeedCommand, "41 0D 00")
+		require.NoError(t, connector.Close())
+	})
+
+	t.Run("Commands", func(t *testing.T) {
+		t.Parallel()
+
+		connector := connectBackend(t, newConnector, Backend{Vehicle: emulator.NewDemoVehicle(), Timeout: conformanceTimeout})
+
+		requireResponse(t, connector, "ATI", "ELM327 v2.1")
+		requireResponse(t, connector, gobd2.EngineRPMCommand, "41 0C 0C 80")
+		requireResponse(t, connector, gobd2.VehicleSpeedCommand, "41 0D 00")
+	})
+
+	t.Run("ConcurrentUse", func(t *testing.T) {
+		t.Parallel()
+
+		connector := connectBackend(t, newConnector, Backend{Vehicle: emulator.NewDemoVehicle(), Timeout: conformanceTimeout})
+		expected := map[gobd2.CommandCode]string{gobd2.EngineRPMCommand: "41 0C 0C 80", gobd2.VehicleSpeedCommand: "41 0D 00"}
+
+		// require must not be called from the workers, their failures are collected and checked afterwards.
+		failures := make(chan error, 8*10)
+
+		var wg sync.WaitGroup
+
+		for worker := range 8 {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for i := range 10 {
+					command := gobd2.EngineRPMCommand
+					if (worker+i)%2 == 1 {
+						command = gobd2.VehicleSpeedCommand
+					}
+
+					response, err := connector.SendCommand(command)
+
+					switch {
+					case err != nil:
+						failures <- fmt.Errorf("%s: %w", command, err)
+					case response != expected[command]:
+						failures <- fmt.Errorf("%s answered %q, expected %q", command, response, expected[command])
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+		close(failures)
+
+		for err := range failures {
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+
+		injector := emulator.NewFaultInjector(1)
+		connector := connectBackend(t, newConnector, Backend{
+			Vehicle: emulator.NewDemoVehicle(),
+			Options: []emulator.Option{emulator.WithFaultInjector(injector)},
+			Timeout: conformanceTimeout,
+		})
+
+		injector.Inject(emulator.FaultDroppedPrompt)
+
+		_, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+		require.ErrorIs(t, err, gobd2.ErrTimeout)
+
+		requireResponse(t, connector, gobd2.VehicleSpeedCommand, "41 0D 00")
+	})
+
+	t.Run("AdapterErrors", func(t *testing.T) {
+		t.Parallel()
+
+		injector := emulator.NewFaultInjector(1)
+		connector := connectBackend(t, newConnector, Backend{
+			Vehicle: emulator.NewDemoVehicle(),
+			Options: []emulator.Option{emulator.WithFaultInjector(injector)},
+			Timeout: conformanceTimeout,
+		})
+
+		injector.Inject(emulator.FaultNoData, emulator.FaultCANError, emulator.FaultGarbage)
+		requireResponse(t, connector, gobd2.VehicleSpeedCommand, "NO DATA")
+		requireResponse(t, connector, gobd2.VehicleSpeedCommand, "CAN ERROR")
+		requireResponse(t, connector, gobd2.VehicleSpeedCommand, "41 0D 00")
+
+		injector.Inject(emulator.FaultLowVoltageReset)
+
+		_, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+		require.ErrorIs(t, err, gobd2.ErrAdapterReset)
+
+		response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(response, "41 0D 00"), "the adapter is initialized again: %q", response)
+	})
+
+	t.Run("MultiFrame", func(t *testing.T) {
+		t.Parallel()
+
+		connector := connectBackend(t, newConnector, Backend{Vehicle: emulator.NewDemoVehicle(), Timeout: conformanceTimeout})
+
+		requireResponse(t, connector, gobd2.SupportedPIDsCommand1_20, "41 00 98 1B 80 11\r41 00 80 00 00 00")
+		requireResponse(t, connector, "0902", strings.Join([]string{
+			"014", "0: 49 02 01 31 47 4F", "1: 42 44 32 45 4D 55 4C", "2: 41 54 4F 52 30 30 31",
+		}, "\r"))
+
+		topology, err := gobd2.NewCommander(connector).DiscoverTopology()
+		require.NoError(t, err)
+		require.Len(t, topology.ECUs, 2)
+		require.Equal(t, "ECM-EngineControl", topology.ECUs[0].Name)
+		require.Equal(t, "TCM-TransmissionCtl", topology.ECUs[1].Name)
+	})
+}
+
+// connectBackend creates and connects a connector, closing it when the test ends.
+func connectBackend(t *testing.T, newConnector ConnectorFactory, backend Backend) gobd2.Connector {
+	t.Helper()
+
+	connector := newConnector(t, backend)
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	searchProtocol(t, connector)
+
+	return connector
+}
+
+// searchProtocol sends the first request after connecting, which makes the adapter search for the protocol.
+func searchProtocol(t *testing.T, connector gobd2.Connector) {
+	t.Helper()
+
+	response, err := connector.SendCommand(gobd2.SupportedPIDsCommand1_20)
+	require.NoError(t, err)
+	require.Contains(t, response, "41 00", "protocol search")
+}
+
+// requireResponse sends command and requires the connector to return expected.
+func requireResponse(t *testing.T, connector gobd2.Connector, command gobd2.CommandCode, expected string) {
+	t.Helper()
+
+	response, err := connector.SendCommand(command)
+	require.NoError(t, err, "sending %s", command)
+	require.Equal(t, expected, response, "answer to %s", command)
+}
@@ -0,0 +1,81 @@
+package gobd2test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+)
+
+// ErrUnexpectedCommand is returned by a Connector for commands no expectation matches.
+var ErrUnexpectedCommand = errors.New("unexpected command")
+
+// Connector is a fake gobd2.Connector answering commands with scripted responses:
+//
+//	connector := gobd2test.NewConnector()
+//	connector.On(gobd2.EngineRPMCommand).Return("41 0C 1A F8")
+//	connector.OnMatch(`^01`).Return("NO DATA")
+//
+// Commands no expectation matches fail with ErrUnexpectedCommand. Connector is safe for concurrent use.
+type Connector struct {
+	script
+	stateMu    sync.Mutex
+	connected  bool
+	connectErr error
+}
+
+// NewConnector creates a connector without expectations.
+func NewConnector() *Connector {
+	return &Connector{}
+}
+
+// FailConnect makes Connect fail with err.
+func (c *Connector) FailConnect(err error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.connectErr = err
+}
+
+// Connect connects the fake, unless FailConnect was called.
+func (c *Connector) Connect() error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.connectErr != nil {
+		return c.connectErr
+	}
+
+	c.connected = true
+
+	return nil
+}
+
+// Close disconnects the fake.
+func (c *Connector) Close() error {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.connected = false
+
+	return nil
+}
+
+// Connected reports whether the fake is connected.
+func (c *Connector) Connected() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.connected
+}
+
+// SendCommand records command and returns the answer of the first expectation matching it.
+func (c *Connector) SendCommand(command gobd2.CommandCode) (string, error) {
+	expectation := c.answer(command)
+	if expectation == nil {
+		return "", fmt.Errorf("%s: %w", command, ErrUnexpectedCommand)
+	}
+
+	return expectation.response, expectation.err
+}
@@ -0,0 +1,113 @@
+package gobd2test_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/gobd2test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnector(t *testing.T) {
+	t.Parallel()
+
+	connector := gobd2test.NewConnector()
+	connector.On(gobd2.EngineRPMCommand).Return("41 0C 1A F8").Once()
+	connector.On(gobd2.EngineRPMCommand).ReturnError(gobd2.ErrTimeout)
+	connector.OnMatch(`^01[0-9A-F]{2}$`).Return("NO DATA")
+
+	require.NoError(t, connector.Connect())
+	require.True(t, connector.Connected())
+
+	commander := gobd2.NewCommander(connector)
+
+	response, err := commander.ExecuteCommand(gobd2.EngineRPMCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0C 1A F8", response)
+
+	_, err = commander.ExecuteCommand(gobd2.EngineRPMCommand)
+	require.ErrorIs(t, err, gobd2.ErrTimeout, "the first expectation is used up")
+
+	_, err = commander.ExecuteCommand(gobd2.VehicleSpeedCommand)
+	require.ErrorIs(t, err, gobd2.ErrNoData)
+
+	_, err = commander.ExecuteCommand("0902")
+	require.ErrorIs(t, err, gobd2test.ErrUnexpectedCommand)
+
+	require.Equal(t, []gobd2.CommandCode{gobd2.EngineRPMCommand, gobd2.EngineRPMCommand, gobd2.VehicleSpeedCommand, "0902"}, connector.Calls())
+	require.Equal(t, 2, connector.CallCount(gobd2.EngineRPMCommand))
+	require.True(t, connector.AssertCalled(t, gobd2.VehicleSpeedCommand))
+	require.True(t, connector.AssertNotCalled(t, "03"))
+	require.True(t, connector.AssertExpectations(t))
+
+	require.NoError(t, connector.Close())
+	require.False(t, connector.Connected())
+}
+
+func TestConnector_FailedAssertions(t *testing.T) {
+	t.Parallel()
+
+	connector := gobd2test.NewConnector()
+	connector.On("03").Return("43 00")
+	connector.On("04").Return("44").Times(2)
+
+	_, err := connector.SendCommand("04")
+	require.NoError(t, err)
+
+	recorder := &testing.T{}
+	require.False(t, connector.AssertExpectations(recorder))
+	require.False(t, connector.AssertCalled(recorder, "03"))
+	require.False(t, connector.AssertNotCalled(recorder, "04"))
+	require.True(t, recorder.Failed())
+}
+
+func TestConnector_FailConnect(t *testing.T) {
+	t.Parallel()
+
+	failure := errors.New("no adapter")
+
+	connector := gobd2test.NewConnector()
+	connector.FailConnect(failure)
+
+	require.ErrorIs(t, connector.Connect(), failure)
+	require.False(t, connector.Connected())
+}
+
+func TestConnector_ReturnECUs(t *testing.T) {
+	t.Parallel()
+
+	connector := gobd2test.NewConnector()
+	connector.On("ATH1").Return("OK")
+	connector.On("ATH0").Return("OK")
+	connector.On("ATDP").Return("AUTO, ISO 15765-4 (CAN 11/500)")
+	connector.On(gobd2.SupportedPIDsCommand1_20).ReturnECUs(
+		gobd2test.ECUResponse{Address: "7E8", Data: []byte{0x41, 0x00, 0x00, 0x18, 0x00, 0x00}},
+		gobd2test.ECUResponse{Address: "7E9", Data: []byte{0x41, 0x00, 0x00, 0x08, 0x00, 0x00}},
+	)
+	connector.On("0900").ReturnECUs(gobd2test.ECUResponse{Address: "7E8", Data: []byte{0x49, 0x00, 0x40, 0x40, 0x00, 0x00}})
+	connector.On(gobd2.ECUNameCommand).ReturnECUs(gobd2test.ECUResponse{
+		Address: "7E8",
+		Data:    append([]byte{0x49, 0x0A, 0x01}, "ECM\x00-EngineControl\x00\x00"...),
+	})
+
+	topology, err := gobd2.NewCommander(connector).DiscoverTopology()
+	require.NoError(t, err)
+	require.Equal(t, "ISO 15765-4 (CAN 11/500)", topology.Protocol)
+	require.Len(t, topology.ECUs, 2)
+	require.Equal(t, "ECM-EngineControl", topology.ECUs[0].Name)
+	require.Equal(t, []gobd2.CommandCode{"010C", "010D"}, topology.ECUs[0].SupportedPIDs)
+	require.Equal(t, []gobd2.CommandCode{"010D"}, topology.ECUs[1].SupportedPIDs)
+	require.Equal(t, []gobd2.CommandCode{"0902", "090A"}, topology.ECUs[0].SupportedInfoTypes)
+}
+
+func TestECUResponse_Legacy(t *testing.T) {
+	t.Parallel()
+
+	connector := gobd2test.NewConnector()
+	connector.On(gobd2.VehicleSpeedCommand).ReturnECUs(gobd2test.ECUResponse{Address: "486B10", Data: []byte{0x41, 0x0D, 0x32}})
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "48 6B 10 41 0D 32 43", response)
+}
@@ -0,0 +1,4 @@
+// Package gobd2test provides test doubles for code using gobd2: a scriptable fake Connector and a fake SerialPort
+// that frames its answers like an ELM327 adapter, for testing a SerialConnector or anything built on it without
+// hardware.
+package gobd2test
@@ -0,0 +1,247 @@
+package gobd2test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+)
+
+// Expectation is a scripted answer to the commands it matches. It is created by On or OnMatch and configured with
+// its Return and Times methods before the commands it answers are sent.
+type Expectation struct {
+	description string
+	match       func(command gobd2.CommandCode) bool
+	response    string
+	err         error
+	times       int
+	calls       int
+}
+
+// Return makes the expectation answer with lines, joined by carriage returns like the adapter separates them.
+func (e *Expectation) Return(lines ...string) *Expectation {
+	e.response = strings.Join(lines, "\r")
+
+	return e
+}
+
+// ReturnECUs makes the expectation answer with the messages of several ECUs, formatted like the adapter does with
+// headers enabled. See ECUResponse.
+func (e *Expectation) ReturnECUs(responses ...ECUResponse) *Expectation {
+	var lines []string
+
+	for _, response := range responses {
+		lines = append(lines, response.lines()...)
+	}
+
+	return e.Return(lines...)
+}
+
+// ReturnError makes the expectation fail with err.
+func (e *Expectation) ReturnError(err error) *Expectation {
+	e.err = err
+
+	return e
+}
+
+// Times limits the expectation to n matching commands. Later commands are matched against the other
+// expectations. By default an expectation answers any number of commands.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+
+	return e
+}
+
+// Once limits the expectation to a single matching command.
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+// exhausted reports whether the expectation answered as many commands as it was limited to.
+func (e *Expectation) exhausted() bool {
+	return e.times > 0 && e.calls >= e.times
+}
+
+// ECUResponse is a message sent by a single ECU in a canned response.
+type ECUResponse struct {
+	// Address is the header the ECU answers with: "7E8" on 11-bit CAN, "18DAF110" on 29-bit CAN or "486B10" on
+	// legacy protocols.
+	Address string
+	// Data is the message payload, e.g. []byte{0x41, 0x0D, 0x32}.
+	Data []byte
+}
+
+// lines formats the response as the adapter shows it with headers enabled: CAN messages are split into ISO-TP
+// frames, legacy messages are followed by their checksum.
+func (r ECUResponse) lines() []string {
+	if len(r.Address) == 6 {
+		checksum := byte(0)
+
+		frame := append(mustDecodeHeader(r.Address), r.Data...)
+		for _, b := range frame {
+			checksum += b
+		}
+
+		return []string{formatBytes(append(frame, checksum))}
+	}
+
+	if len(r.Data) <= 7 {
+		return []string{r.Address + " " + formatBytes(append([]byte{byte(len(r.Data))}, r.Data...))}
+	}
+
+	length := len(r.Data)
+	lines := []string{r.Address + " " + formatBytes(append([]byte{0x10 | byte(length>>8), byte(length)}, r.Data[:6]...))}
+
+	for offset, sequence := 6, 1; offset < length; offset, sequence = offset+7, sequence+1 {
+		frame := append([]byte{0x20 | byte(sequence&0x0F)}, r.Data[offset:min(offset+7, length)]...)
+		lines = append(lines, r.Address+" "+formatBytes(frame))
+	}
+
+	return lines
+}
+
+func mustDecodeHeader(header string) []byte {
+	decoded, err := hex.DecodeString(header)
+	if err != nil {
+		panic(fmt.Sprintf("gobd2test: invalid header %q: %v", header, err))
+	}
+
+	return decoded
+}
+
+func formatBytes(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// script holds the expectations and the commands received by a fake. Its methods are shared by Connector and
+// SerialPort.
+type script struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []gobd2.CommandCode
+}
+
+// On adds an expectation for command. Expectations are matched in the order they were added.
+func (s *script) On(command gobd2.CommandCode) *Expectation {
+	return s.expect(string(command), func(received gobd2.CommandCode) bool { return received == command })
+}
+
+// OnMatch adds an expectation for the commands matching the regular expression pattern, e.g. `^01[0-9A-F]{2}$`.
+// It panics if pattern is invalid.
+func (s *script) OnMatch(pattern string) *Expectation {
+	re := regexp.MustCompile(pattern)
+
+	return s.expect("/"+pattern+"/", func(received gobd2.CommandCode) bool { return re.MatchString(string(received)) })
+}
+
+func (s *script) expect(description string, match func(gobd2.CommandCode) bool) *Expectation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expectation := &Expectation{description: description, match: match}
+	s.expectations = append(s.expectations, expectation)
+
+	return expectation
+}
+
+// answer records command and returns the first expectation matching it, or nil if there is none.
+func (s *script) answer(command gobd2.CommandCode) *Expectation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, command)
+
+	for _, expectation := range s.expectations {
+		if !expectation.exhausted() && expectation.match(command) {
+			expectation.calls++
+
+			return expectation
+		}
+	}
+
+	return nil
+}
+
+// Calls returns the commands received so far, in order.
+func (s *script) Calls() []gobd2.CommandCode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.calls)
+}
+
+// CallCount returns how often command was received.
+func (s *script) CallCount(command gobd2.CommandCode) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+
+	for _, call := range s.calls {
+		if call == command {
+			count++
+		}
+	}
+
+	return count
+}
+
+// AssertCalled fails the test if command was not received.
+func (s *script) AssertCalled(t testing.TB, command gobd2.CommandCode) bool {
+	t.Helper()
+
+	if s.CallCount(command) == 0 {
+		t.Errorf("expected %s to be sent, received %v", command, s.Calls())
+
+		return false
+	}
+
+	return true
+}
+
+// AssertNotCalled fails the test if command was received.
+func (s *script) AssertNotCalled(t testing.TB, command gobd2.CommandCode) bool {
+	t.Helper()
+
+	if count := s.CallCount(command); count > 0 {
+		t.Errorf("expected %s not to be sent, received it %d times", command, count)
+
+		return false
+	}
+
+	return true
+}
+
+// AssertExpectations fails the test if an expectation was never matched, or matched fewer times than it was
+// limited to.
+func (s *script) AssertExpectations(t testing.TB) bool {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ok := true
+
+	for _, expectation := range s.expectations {
+		switch {
+		case expectation.times > 0 && expectation.calls != expectation.times:
+			t.Errorf("expected %s to be sent %d times, received it %d times", expectation.description, expectation.times, expectation.calls)
+			ok = false
+		case expectation.calls == 0:
+			t.Errorf("expected %s to be sent, it was not", expectation.description)
+			ok = false
+		}
+	}
+
+	return ok
+}
@@ -0,0 +1,193 @@
+package gobd2test
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/tarm/serial"
+)
+
+// identification is the answer of the fake adapter to ATZ and ATI.
+const identification = "ELM327 v1.5"
+
+// SerialPort is a fake gobd2.SerialPort behaving like an ELM327 adapter: every command written to it, terminated
+// by a carriage return, is answered with the echo of the command if enabled, the response and the ">" prompt.
+//
+// Responses come from the expectations added with On and OnMatch. Without a matching expectation, ATZ and ATI
+// answer with the adapter identification, other AT commands with "OK" and OBD requests with "NO DATA". ATE and
+// ATL switch echo and linefeeds like on the real adapter. An expectation returning an error makes the next Read
+// fail with it instead of answering.
+//
+// Reads return io.EOF when there is nothing to read, like a serial port whose read timeout expired.
+type SerialPort struct {
+	script
+	portMu    sync.Mutex
+	input     bytes.Buffer
+	output    bytes.Buffer
+	readErr   error
+	echo      bool
+	linefeeds bool
+	closed    bool
+}
+
+// NewSerialPort creates a port for an adapter with its power-on settings: echo on, linefeeds off.
+func NewSerialPort() *SerialPort {
+	return &SerialPort{echo: true}
+}
+
+// Read reads the adapter's answers.
+func (p *SerialPort) Read(b []byte) (int, error) {
+	p.portMu.Lock()
+	defer p.portMu.Unlock()
+
+	if p.readErr != nil {
+		err := p.readErr
+		p.readErr = nil
+
+		return 0, err
+	}
+
+	if p.output.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return p.output.Read(b)
+}
+
+// Write sends commands to the adapter, answering each one as soon as its carriage return is written.
+func (p *SerialPort) Write(b []byte) (int, error) {
+	p.portMu.Lock()
+	defer p.portMu.Unlock()
+
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	p.input.Write(b)
+
+	for {
+		line, err := p.input.ReadString('\r')
+		if err != nil {
+			p.input.WriteString(line) // incomplete command
+
+			return len(b), nil
+		}
+
+		p.execute(strings.TrimSuffix(line, "\r"))
+	}
+}
+
+// Close closes the port. Opening it again through a PortOpener reopens it.
+func (p *SerialPort) Close() error {
+	p.portMu.Lock()
+	defer p.portMu.Unlock()
+
+	p.closed = true
+
+	return nil
+}
+
+// Closed reports whether the port is closed.
+func (p *SerialPort) Closed() bool {
+	p.portMu.Lock()
+	defer p.portMu.Unlock()
+
+	return p.closed
+}
+
+// execute answers a single command.
+func (p *SerialPort) execute(line string) {
+	command := gobd2.CommandCode(strings.ToUpper(strings.ReplaceAll(line, " ", "")))
+	eol := p.eol()
+
+	if p.echo {
+		p.output.WriteString(line + eol)
+	}
+
+	var response string
+
+	if expectation := p.answer(command); expectation != nil {
+		if expectation.err != nil {
+			p.readErr = expectation.err
+
+			return
+		}
+
+		response = expectation.response
+	} else {
+		response = p.defaultResponse(command)
+	}
+
+	eol = p.eol() // ATL answers with the new line ending
+	p.output.WriteString(strings.ReplaceAll(response, "\r", eol) + eol + eol + ">")
+}
+
+// eol returns the line ending the adapter uses.
+func (p *SerialPort) eol() string {
+	if p.linefeeds {
+		return "\r\n"
+	}
+
+	return "\r"
+}
+
+// defaultResponse answers commands no expectation matches and applies the settings they change.
+func (p *SerialPort) defaultResponse(command gobd2.CommandCode) string {
+	switch command {
+	case "ATZ", "ATWS":
+		p.echo, p.linefeeds = true, false
+
+		return "\r" + identification
+	case "ATI":
+		return identification
+	case "ATE0", "ATE1":
+		p.echo = command == "ATE1"
+	case "ATL0", "ATL1":
+		p.linefeeds = command == "ATL1"
+	}
+
+	if strings.HasPrefix(string(command), "AT") {
+		return "OK"
+	}
+
+	return "NO DATA"
+}
+
+// PortOpener is a fake gobd2.SerialPortOpener opening Port, or failing with Err if it is set.
+type PortOpener struct {
+	Port *SerialPort
+	Err  error
+
+	mu      sync.Mutex
+	configs []serial.Config
+}
+
+// OpenPort records config and opens Port.
+func (o *PortOpener) OpenPort(config *serial.Config) (gobd2.SerialPort, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.configs = append(o.configs, *config)
+
+	if o.Err != nil {
+		return nil, o.Err
+	}
+
+	o.Port.portMu.Lock()
+	o.Port.closed = false
+	o.Port.portMu.Unlock()
+
+	return o.Port, nil
+}
+
+// Configs returns the configurations of every port opened so far.
+func (o *PortOpener) Configs() []serial.Config {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return slices.Clone(o.configs)
+}
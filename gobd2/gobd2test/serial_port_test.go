@@ -0,0 +1,118 @@
+package gobd2test_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/gobd2test"
+	"github.com/stretchr/testify/require"
+	"github.com/tarm/serial"
+)
+
+func TestSerialPort(t *testing.T) {
+	t.Parallel()
+
+	port := gobd2test.NewSerialPort()
+	port.On(gobd2.EngineRPMCommand).Return("41 0C 1A F8")
+	port.On(gobd2.ECUNameCommand).ReturnECUs(gobd2test.ECUResponse{Address: "7E8", Data: append([]byte{0x49, 0x0A, 0x01}, "ECM"...)})
+
+	opener := &gobd2test.PortOpener{Port: port}
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	connector := gobd2.NewSerialConnector("/dev/ttyUSB0", 38400, opener, gobd2.WithInitProfile(profile))
+	require.NoError(t, connector.Connect())
+
+	response, err := connector.SendCommand(gobd2.EngineRPMCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0C 1A F8", response)
+
+	response, err = connector.SendCommand(gobd2.ECUNameCommand)
+	require.NoError(t, err)
+	require.Equal(t, "7E8 06 49 0A 01 45 43 4D", response)
+
+	response, err = connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "NO DATA", response)
+
+	require.Equal(t, []gobd2.CommandCode{"ATZ", "ATE0", "ATL0", "ATSP0", gobd2.EngineRPMCommand, gobd2.ECUNameCommand, gobd2.VehicleSpeedCommand}, port.Calls())
+	require.True(t, port.AssertExpectations(t))
+	require.Equal(t, []serial.Config{{Name: "/dev/ttyUSB0", Baud: 38400}}, opener.Configs())
+
+	require.NoError(t, connector.Close())
+	require.True(t, port.Closed())
+}
+
+func TestSerialPort_Framing(t *testing.T) {
+	t.Parallel()
+
+	port := gobd2test.NewSerialPort()
+	port.On(gobd2.VehicleSpeedCommand).Return("7E8 03 41 0D 32", "7E9 03 41 0D 32")
+
+	read := func() string {
+		t.Helper()
+
+		buf := make([]byte, 256)
+		n, err := port.Read(buf)
+		require.NoError(t, err)
+
+		return string(buf[:n])
+	}
+
+	_, err := port.Write([]byte("01"))
+	require.NoError(t, err)
+
+	_, err = port.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "incomplete commands are not answered")
+
+	_, err = port.Write([]byte("0d\r"))
+	require.NoError(t, err)
+	require.Equal(t, "010d\r7E8 03 41 0D 32\r7E9 03 41 0D 32\r\r>", read(), "commands are echoed and matched case-insensitively")
+
+	_, err = port.Write([]byte("ATL1\rATE0\r"))
+	require.NoError(t, err)
+	require.Equal(t, "ATL1\rOK\r\n\r\n>ATE0\r\nOK\r\n\r\n>", read())
+
+	_, err = port.Write([]byte("010D\r"))
+	require.NoError(t, err)
+	require.Equal(t, "7E8 03 41 0D 32\r\n7E9 03 41 0D 32\r\n\r\n>", read())
+
+	_, err = port.Write([]byte("ATZ\r"))
+	require.NoError(t, err)
+	require.Equal(t, "\rELM327 v1.5\r\r>", read())
+
+	_, err = port.Write([]byte("ATI\r"))
+	require.NoError(t, err)
+	require.Equal(t, "ATI\rELM327 v1.5\r\r>", read(), "a reset enables echo and disables linefeeds")
+}
+
+func TestSerialPort_ReadError(t *testing.T) {
+	t.Parallel()
+
+	failure := errors.New("device disconnected")
+
+	port := gobd2test.NewSerialPort()
+	port.On(gobd2.EngineRPMCommand).ReturnError(failure)
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	connector := gobd2.NewSerialConnector("COM3", 9600, &gobd2test.PortOpener{Port: port}, gobd2.WithInitProfile(profile),
+		gobd2.WithTimeout(time.Second))
+	require.NoError(t, connector.Connect())
+
+	_, err := connector.SendCommand(gobd2.EngineRPMCommand)
+	require.ErrorIs(t, err, failure)
+}
+
+func TestPortOpener_Error(t *testing.T) {
+	t.Parallel()
+
+	failure := errors.New("permission denied")
+
+	connector := gobd2.NewSerialConnector("COM3", 9600, &gobd2test.PortOpener{Err: failure})
+	require.ErrorIs(t, connector.Connect(), failure)
+}
@@ -0,0 +1,170 @@
+package gobd2
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Toggle is an on/off adapter setting that can also be left at the adapter's default.
+type Toggle int8
+
+// Toggle values.
+const (
+	ToggleUnchanged Toggle = iota
+	ToggleOn
+	ToggleOff
+)
+
+// AdaptiveTiming selects the ELM327 adaptive timing mode (ATAT).
+type AdaptiveTiming int8
+
+// Adaptive timing modes.
+const (
+	AdaptiveTimingUnchanged AdaptiveTiming = iota
+	AdaptiveTimingOff
+	AdaptiveTimingNormal
+	AdaptiveTimingAggressive
+)
+
+// maxResponseTimeout is the longest timeout ATST accepts, 255 steps of 4 ms.
+const maxResponseTimeout = 255 * 4 * time.Millisecond
+
+// defaultResponseTimeout restores the adapter's default timeout of 200 ms.
+const defaultResponseTimeout CommandCode = "ATST32"
+
+// responseTimeoutCommand returns the ATST command making the adapter wait timeout for a response, rounded up to
+// the next 4 ms step and capped at maxResponseTimeout.
+func responseTimeoutCommand(timeout time.Duration) CommandCode {
+	units := (min(timeout, maxResponseTimeout) + 4*time.Millisecond - 1) / (4 * time.Millisecond)
+
+	return CommandCode(fmt.Sprintf("ATST%02X", int(units)))
+}
+
+// ErrUnexpectedResponse is returned when the adapter answers a command with something other than expected.
+var ErrUnexpectedResponse = errors.New("unexpected response")
+
+// InitProfile describes the settings applied to an ELM327 adapter when connecting. Settings left unchanged are
+// not sent to the adapter.
+type InitProfile struct {
+	// Reset restarts the adapter (ATZ) before applying the settings.
+	Reset bool
+	// Echo controls whether the adapter echoes commands back (ATE).
+	Echo Toggle
+	// Linefeeds controls whether lines end with a linefeed after the carriage return (ATL).
+	Linefeeds Toggle
+	// Spaces controls whether bytes in responses are separated by spaces (ATS).
+	Spaces Toggle
+	// Headers controls whether responses include the header of each frame (ATH).
+	Headers Toggle
+	// CANAutoFormatting controls whether the adapter handles CAN PCI bytes itself (ATCAF).
+	CANAutoFormatting Toggle
+	// AdaptiveTiming selects how the adapter adapts its response timeout to the vehicle (ATAT).
+	AdaptiveTiming AdaptiveTiming
+	// Timeout sets how long the adapter waits for a response (ATST), up to 1020 ms. Zero keeps the default.
+	Timeout time.Duration
+	// ExtraCommands are sent after all other settings. Each of them must be answered with "OK".
+	ExtraCommands []CommandCode
+	// StepDelay is the pause after each command, giving the adapter time to apply it.
+	StepDelay time.Duration
+}
+
+// InitError reports the initialization step the adapter failed or rejected.
+type InitError struct {
+	Step     CommandCode
+	Response string
+	Err      error
+}
+
+func (e *InitError) Error() string {
+	if e.Response != "" {
+		return fmt.Sprintf("ELM327 initialization step %s failed: %v (response %q)", e.Step, e.Err, e.Response)
+	}
+
+	return fmt.Sprintf("ELM327 initialization step %s failed: %v", e.Step, e.Err)
+}
+
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultInitProfile returns the profile used when none is configured: reset the adapter and disable echo and
+// linefeeds.
+func DefaultInitProfile() InitProfile {
+	return InitProfile{
+		Reset:     true,
+		Echo:      ToggleOff,
+		Linefeeds: ToggleOff,
+		StepDelay: 100 * time.Millisecond,
+	}
+}
+
+// initStep is a single command of the initialization sequence.
+type initStep struct {
+	command  CommandCode
+	expectOK bool
+}
+
+// steps returns the commands applying the profile, in the order they are sent.
+func (p InitProfile) steps() ([]initStep, error) {
+	var steps []initStep
+
+	if p.Reset {
+		steps = append(steps, initStep{command: "ATZ"})
+	}
+
+	for _, toggle := range []struct {
+		command string
+		value   Toggle
+	}{
+		{"ATE", p.Echo},
+		{"ATL", p.Linefeeds},
+		{"ATS", p.Spaces},
+		{"ATH", p.Headers},
+		{"ATCAF", p.CANAutoFormatting},
+	} {
+		switch toggle.value {
+		case ToggleUnchanged:
+		case ToggleOn:
+			steps = append(steps, initStep{command: CommandCode(toggle.command + "1"), expectOK: true})
+		case ToggleOff:
+			steps = append(steps, initStep{command: CommandCode(toggle.command + "0"), expectOK: true})
+		}
+	}
+
+	if p.AdaptiveTiming != AdaptiveTimingUnchanged {
+		steps = append(steps, initStep{command: CommandCode(fmt.Sprintf("ATAT%d", p.AdaptiveTiming-1)), expectOK: true})
+	}
+
+	if p.Timeout > 0 {
+		if p.Timeout > maxResponseTimeout {
+			return nil, fmt.Errorf("response timeout %s exceeds %s", p.Timeout, maxResponseTimeout)
+		}
+
+		steps = append(steps, initStep{command: responseTimeoutCommand(p.Timeout), expectOK: true})
+	}
+
+	for _, command := range p.ExtraCommands {
+		steps = append(steps, initStep{command: command, expectOK: true})
+	}
+
+	return steps, nil
+}
+
+// checkStepResponse validates the adapter's answer to an initialization step.
+func checkStepResponse(step initStep, response string) error {
+	if err := responseError(response); err != nil {
+		return &InitError{Step: step.command, Response: response, Err: err}
+	}
+
+	lines := responseLines(response)
+
+	switch {
+	case step.expectOK && (len(lines) == 0 || lines[len(lines)-1] != "OK"):
+		return &InitError{Step: step.command, Response: response, Err: ErrUnexpectedResponse}
+	case !step.expectOK && len(lines) == 0:
+		return &InitError{Step: step.command, Response: response, Err: ErrUnexpectedResponse}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package gobd2_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func connectWithProfile(t *testing.T, profile gobd2.InitProfile, responses string) (*MockSerialPort, error) {
+	t.Helper()
+
+	mockPort := &MockSerialPort{}
+	mockPort.buf.WriteString(responses)
+
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(mockPort, nil)
+
+	connector := gobd2.NewSerialConnector("COM1", 38400, mockOpener, gobd2.WithInitProfile(profile))
+
+	return mockPort, connector.Connect()
+}
+
+func TestSerialConnector_Connect_InitProfile(t *testing.T) {
+	t.Parallel()
+
+	profile := gobd2.InitProfile{
+		Echo:              gobd2.ToggleOff,
+		Spaces:            gobd2.ToggleOff,
+		Headers:           gobd2.ToggleOn,
+		CANAutoFormatting: gobd2.ToggleOn,
+		AdaptiveTiming:    gobd2.AdaptiveTimingAggressive,
+		Timeout:           200 * time.Millisecond,
+		ExtraCommands:     []gobd2.CommandCode{"ATCRA7E8"},
+	}
+
+	mockPort, err := connectWithProfile(t, profile, "ATE0\rOK\r>OK\r>OK\r>OK\r>OK\r>OK\r>OK\r>OK\r>")
+	require.NoError(t, err)
+	// The first command is consumed together with the responses, as reads and writes share the mock buffer.
+	require.Equal(t, "ATS0\rATH1\rATCAF1\rATAT2\rATST32\rATCRA7E8\rATSP0\r", mockPort.buf.String())
+}
+
+func TestSerialConnector_Connect_InitProfileRejectedStep(t *testing.T) {
+	t.Parallel()
+
+	profile := gobd2.InitProfile{
+		Echo:           gobd2.ToggleOff,
+		AdaptiveTiming: gobd2.AdaptiveTimingNormal,
+	}
+
+	_, err := connectWithProfile(t, profile, "OK\r>?\r>")
+	require.ErrorIs(t, err, gobd2.ErrUnknownCommand)
+
+	var initErr *gobd2.InitError
+	require.ErrorAs(t, err, &initErr)
+	require.Equal(t, gobd2.CommandCode("ATAT1"), initErr.Step)
+}
+
+func TestSerialConnector_Connect_InitProfileUnexpectedResponse(t *testing.T) {
+	t.Parallel()
+
+	_, err := connectWithProfile(t, gobd2.InitProfile{Linefeeds: gobd2.ToggleOff}, "ELM327 v1.5\r>")
+	require.ErrorIs(t, err, gobd2.ErrUnexpectedResponse)
+	require.ErrorContains(t, err, "ATL0")
+}
+
+func TestSerialConnector_Connect_InitProfileTimeoutTooLong(t *testing.T) {
+	t.Parallel()
+
+	_, err := connectWithProfile(t, gobd2.InitProfile{Timeout: 2 * time.Second}, "")
+	require.ErrorContains(t, err, "exceeds")
+}
@@ -0,0 +1,550 @@
+package gobd2
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by a PIDRegistry.
+var (
+	ErrUnknownPID   = errors.New("unknown PID")
+	ErrInvalidPID   = errors.New("invalid PID")
+	ErrDuplicatePID = errors.New("PID already registered")
+)
+
+// PID describes a value read from the vehicle with a single request and how to decode it from the answer.
+//
+// A PID without a command is a virtual sensor: its value is computed by its expression from the values of the PIDs
+// it refers to by name or short name, e.g. "MAP - BARO" for the boost pressure.
+type PID struct {
+	// Name identifies the PID in a registry, e.g. "Engine RPM".
+	Name string `json:"name"`
+	// ShortName is an abbreviation of the name for narrow displays, e.g. "RPM".
+	ShortName string `json:"shortName,omitempty"`
+	// Command is the request, e.g. "010C" for a standard PID or "22F40D" for a manufacturer data identifier. It is
+	// empty for a virtual sensor.
+	Command CommandCode `json:"command"`
+	// Header is the request header, e.g. "7E0" to address the engine ECU only. Empty sends the request to all
+	// ECUs.
+	Header string `json:"header,omitempty"`
+	// Offset is the position of the value's first byte in the answer, after the echoed service and identifier.
+	Offset int `json:"offset,omitempty"`
+	// Length is the number of bytes of the value, zero for all bytes from Offset on.
+	Length int `json:"length,omitempty"`
+	// Expression computes the value from its bytes, the variables A, B, C and so on, e.g. "(A*256+B)/4", or, for a
+	// virtual sensor, from the values of other PIDs.
+	Expression string `json:"expression"`
+	// Unit is the unit of the value, e.g. "rpm".
+	Unit string `json:"unit,omitempty"`
+	// Min and Max are the expected range of the value, for scaling gauges. Both are zero if unknown.
+	Min float64 `json:"min,omitempty"`
+	Max float64 `json:"max,omitempty"`
+
+	compiled *Expression
+}
+
+// Reading is a value read from the vehicle.
+type Reading struct {
+	PID   string    `json:"pid"`
+	Value float64   `json:"value"`
+	Unit  string    `json:"unit,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// String formats the value with its unit, e.g. "3200 rpm".
+func (r Reading) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%.6g %s", r.Value, r.Unit))
+}
+
+// Virtual reports whether the PID is a virtual sensor, computed from other PIDs instead of read from the vehicle.
+func (p *PID) Virtual() bool {
+	return p.Command == ""
+}
+
+// compile validates the PID and compiles its expression.
+func (p *PID) compile() error {
+	request, err := hex.DecodeString(string(p.Command))
+
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("PID %s has no name: %w", p.Command, ErrInvalidPID)
+	case !p.Virtual() && (err != nil || len(request) < 2):
+		return fmt.Errorf("PID %q: malformed command %q: %w", p.Name, p.Command, ErrInvalidPID)
+	case p.Offset < 0 || p.Length < 0:
+		return fmt.Errorf("PID %q: negative offset or length: %w", p.Name, ErrInvalidPID)
+	}
+
+	expression, err := CompileExpression(p.Expression)
+	if err != nil {
+		return fmt.Errorf("PID %q: %w", p.Name, err)
+	}
+
+	switch references := expression.References(); {
+	case p.Virtual() && len(references) == 0:
+		return fmt.Errorf("virtual sensor %q refers to no PID: %w", p.Name, ErrInvalidPID)
+	case !p.Virtual() && len(references) > 0:
+		return fmt.Errorf("PID %q refers to %q, only virtual sensors can refer to other PIDs: %w", p.Name,
+			references[0], ErrInvalidPID)
+	}
+
+	p.compiled = expression
+
+	return nil
+}
+
+// Decode computes the value from the data of an answer to the PID's request, starting with the response service
+// identifier, e.g. 41 0C 1A F8.
+func (p *PID) Decode(data []byte) (float64, error) {
+	if p.compiled == nil {
+		if err := p.compile(); err != nil {
+			return 0, err
+		}
+	}
+
+	if p.Virtual() {
+		return 0, fmt.Errorf("virtual sensor %q is computed, not read: %w", p.Name, ErrInvalidPID)
+	}
+
+	request, _ := hex.DecodeString(string(p.Command))
+	if len(data) < len(request) || data[0] != request[0]+positiveResponseOffset || !slices.Equal(data[1:len(request)], request[1:]) {
+		return 0, fmt.Errorf("PID %q answered % X: %w", p.Name, data, ErrUnexpectedResponse)
+	}
+
+	value := data[len(request):]
+
+	switch {
+	case p.Offset > len(value) || p.Length > 0 && p.Offset+p.Length > len(value):
+		return 0, fmt.Errorf("PID %q answered %d bytes: %w", p.Name, len(value), ErrUnexpectedResponse)
+	case p.Length > 0:
+		value = value[p.Offset : p.Offset+p.Length]
+	default:
+		value = value[p.Offset:]
+	}
+
+	return p.compiled.Evaluate(value)
+}
+
+// Compute computes the value of a virtual sensor from the values of the PIDs it refers to, keyed by the names its
+// expression uses.
+func (p *PID) Compute(values map[string]float64) (float64, error) {
+	if p.compiled == nil {
+		if err := p.compile(); err != nil {
+			return 0, err
+		}
+	}
+
+	if !p.Virtual() {
+		return 0, fmt.Errorf("PID %q is read, not computed: %w", p.Name, ErrInvalidPID)
+	}
+
+	return p.compiled.EvaluateWith(nil, values)
+}
+
+// references returns the names of the PIDs a virtual sensor refers to.
+func (p *PID) references() []string {
+	if p.compiled == nil {
+		return nil
+	}
+
+	return p.compiled.References()
+}
+
+// PIDRegistry holds the PIDs that can be read by name. It is safe for concurrent use.
+type PIDRegistry struct {
+	mu   sync.RWMutex
+	pids []PID
+}
+
+// NewPIDRegistry creates a registry holding the standard mode 01 PIDs and the virtual sensors computed from them.
+func NewPIDRegistry() *PIDRegistry {
+	registry := &PIDRegistry{}
+
+	if err := registry.Register(append(slices.Clone(standardPIDs), standardVirtualSensors...)...); err != nil {
+		panic(err)
+	}
+
+	return registry
+}
+
+// Register adds PIDs to the registry after compiling their expressions. Nothing is added if one of them is invalid,
+// its name or short name is taken by the name or short name of another PID, or it is a virtual sensor referring to
+// an unknown PID or depending on itself.
+func (r *PIDRegistry) Register(pids ...PID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	registered := len(r.pids)
+
+	for _, pid := range pids {
+		if err := pid.compile(); err != nil {
+			r.pids = r.pids[:registered]
+
+			return err
+		}
+
+		if slices.ContainsFunc(r.pids, pid.collides) {
+			r.pids = r.pids[:registered]
+
+			return fmt.Errorf("%q: %w", pid.Name, ErrDuplicatePID)
+		}
+
+		r.pids = append(r.pids, pid)
+	}
+
+	if _, err := r.resolve(r.pids[registered:]); err != nil {
+		r.pids = r.pids[:registered]
+
+		return err
+	}
+
+	return nil
+}
+
+// collides reports whether the name or short name of p is taken by the name or short name of other.
+func (p *PID) collides(other PID) bool {
+	names := []string{other.Name, other.ShortName}
+
+	return slices.Contains(names, p.Name) || p.ShortName != "" && slices.Contains(names, p.ShortName)
+}
+
+// Lookup returns the PID registered as name, or else the one registered with name as short name.
+func (r *PIDRegistry) Lookup(name string) (PID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := r.index(name)
+	if i < 0 {
+		return PID{}, fmt.Errorf("%q: %w", name, ErrUnknownPID)
+	}
+
+	return r.pids[i], nil
+}
+
+// Resolve returns pids together with the PIDs the virtual sensors among them are computed from, directly or through
+// other sensors. Each PID is returned once, after every PID it depends on.
+func (r *PIDRegistry) Resolve(pids ...PID) ([]PID, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pids = slices.Clone(pids)
+
+	for i := range pids {
+		if err := pids[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.resolve(pids)
+}
+
+// PIDs returns every registered PID in the order they were registered.
+func (r *PIDRegistry) PIDs() []PID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.pids)
+}
+
+func (r *PIDRegistry) index(name string) int {
+	if i := slices.IndexFunc(r.pids, func(p PID) bool { return p.Name == name }); i >= 0 {
+		return i
+	}
+
+	return slices.IndexFunc(r.pids, func(p PID) bool { return p.ShortName != "" && p.ShortName == name })
+}
+
+// resolve orders pids and their dependencies with a depth first search, failing on unknown PIDs and cycles.
+func (r *PIDRegistry) resolve(pids []PID) ([]PID, error) {
+	var (
+		ordered []PID
+		done    = map[string]bool{}
+		visit   func(pid PID, path []string) error
+	)
+
+	visit = func(pid PID, path []string) error {
+		if done[pid.Name] {
+			return nil
+		}
+
+		if slices.Contains(path, pid.Name) {
+			return fmt.Errorf("virtual sensor %q depends on itself through %s: %w", pid.Name,
+				strings.Join(append(path, pid.Name), " -> "), ErrInvalidPID)
+		}
+
+		for _, name := range pid.references() {
+			i := r.index(name)
+			if i < 0 {
+				return fmt.Errorf("virtual sensor %q refers to %q: %w", pid.Name, name, ErrUnknownPID)
+			}
+
+			if err := visit(r.pids[i], append(path, pid.Name)); err != nil {
+				return err
+			}
+		}
+
+		done[pid.Name] = true
+		ordered = append(ordered, pid)
+
+		return nil
+	}
+
+	for _, pid := range pids {
+		if err := visit(pid, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// ReadPID requests pid from the vehicle and decodes the answer of the first ECU that sends one. Requests with a
+// header are sent with it and the adapter's header is restored afterwards: switched back to functional addressing
+// unless the connector tracks the adapter's settings and another header was set.
+func (cmd *Commander) ReadPID(pid PID) (_ Reading, err error) {
+	if pid.Virtual() {
+		return Reading{}, fmt.Errorf("virtual sensor %q is computed, not read: %w", pid.Name, ErrInvalidPID)
+	}
+
+	if pid.Header != "" {
+		restore := restoreHeaderCommands(cmd.connector, pid.Header)
+
+		if err := sendSettings(cmd.connector, headerCommands(pid.Header)); err != nil {
+			return Reading{}, fmt.Errorf("PID %q: setting header: %w", pid.Name, err)
+		}
+
+		defer func() {
+			if restoreErr := sendSettings(cmd.connector, restore); restoreErr != nil {
+				err = errors.Join(err, fmt.Errorf("PID %q: restoring header: %w", pid.Name, restoreErr))
+			}
+		}()
+	}
+
+	response, err := cmd.connector.SendCommand(pid.Command)
+	if err != nil {
+		return Reading{}, fmt.Errorf("PID %q: %w", pid.Name, err)
+	}
+
+	data, err := pidAnswer(response, pid.Command)
+	if err != nil {
+		return Reading{}, fmt.Errorf("PID %q: %w", pid.Name, err)
+	}
+
+	if len(data) >= 3 && data[0] == negativeResponse {
+		return Reading{}, &NegativeResponseError{Service: data[1], Code: NegativeResponseCode(data[2])}
+	}
+
+	value, err := pid.Decode(data)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	return Reading{PID: pid.Name, Value: value, Unit: pid.Unit, Time: time.Now()}, nil
+}
+
+// maxPIDsPerRequest is the number of mode 01 PIDs SAE J1979 allows in a single request.
+const maxPIDsPerRequest = 6
+
+// currentDataLengths holds the data length of the mode 01 PIDs defined by SAE J1979, needed to split the answer to
+// a request for several PIDs.
+var currentDataLengths = map[byte]int{
+	0x00: 4, 0x01: 4, 0x02: 2, 0x03: 2, 0x04: 1, 0x05: 1, 0x06: 1, 0x07: 1, 0x08: 1, 0x09: 1, 0x0A: 1, 0x0B: 1,
+	0x0C: 2, 0x0D: 1, 0x0E: 1, 0x0F: 1, 0x10: 2, 0x11: 1, 0x12: 1, 0x13: 1, 0x14: 2, 0x15: 2, 0x16: 2, 0x17: 2,
+	0x18: 2, 0x19: 2, 0x1A: 2, 0x1B: 2, 0x1C: 1, 0x1D: 1, 0x1E: 1, 0x1F: 2, 0x20: 4, 0x21: 2, 0x22: 2, 0x23: 2,
+	0x24: 4, 0x25: 4, 0x26: 4, 0x27: 4, 0x28: 4, 0x29: 4, 0x2A: 4, 0x2B: 4, 0x2C: 1, 0x2D: 1, 0x2E: 1, 0x2F: 1,
+	0x30: 1, 0x31: 2, 0x32: 2, 0x33: 1, 0x34: 4, 0x35: 4, 0x36: 4, 0x37: 4, 0x38: 4, 0x39: 4, 0x3A: 4, 0x3B: 4,
+	0x3C: 2, 0x3D: 2, 0x3E: 2, 0x3F: 2, 0x40: 4, 0x41: 4, 0x42: 2, 0x43: 2, 0x44: 2, 0x45: 1, 0x46: 1, 0x47: 1,
+	0x48: 1, 0x49: 1, 0x4A: 1, 0x4B: 1, 0x4C: 1, 0x4D: 2, 0x4E: 2, 0x4F: 4, 0x50: 4, 0x51: 1, 0x52: 1, 0x53: 2,
+	0x54: 2, 0x55: 2, 0x56: 2, 0x57: 2, 0x58: 2, 0x59: 2, 0x5A: 1, 0x5B: 1, 0x5C: 1, 0x5D: 2, 0x5E: 2, 0x5F: 1,
+}
+
+// batchable reports whether the PID can be requested together with others: a mode 01 PID of known length sent to
+// all ECUs.
+func (p *PID) batchable() bool {
+	request, err := hex.DecodeString(string(p.Command))
+	if err != nil || len(request) != 2 || request[0] != modeCurrentData || p.Header != "" {
+		return false
+	}
+
+	_, ok := currentDataLengths[request[1]]
+
+	return ok
+}
+
+// readPIDs requests up to six batchable PIDs at once and decodes the answer of the first ECU that sends one. Only
+// the PIDs found in the answer are returned, keyed by name.
+func (cmd *Commander) readPIDs(pids []PID) (map[string]Reading, error) {
+	command := CommandCode(fmt.Sprintf("%02X", modeCurrentData))
+	for _, pid := range pids {
+		command += pid.Command[2:]
+	}
+
+	response, err := cmd.connector.SendCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := pidAnswer(response, command)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || data[0] != modeCurrentData|positiveResponseBit {
+		return nil, fmt.Errorf("request for %s answered % X: %w", command, data, ErrUnexpectedResponse)
+	}
+
+	answers := map[byte][]byte{}
+
+	for rest := data[1:]; len(rest) > 0; {
+		length, ok := currentDataLengths[rest[0]]
+		if !ok || len(rest) < 1+length {
+			break
+		}
+
+		answers[rest[0]] = append([]byte{data[0]}, rest[:1+length]...)
+		rest = rest[1+length:]
+	}
+
+	readings := make(map[string]Reading, len(pids))
+
+	for _, pid := range pids {
+		request, _ := hex.DecodeString(string(pid.Command))
+
+		answer, ok := answers[request[1]]
+		if !ok {
+			continue
+		}
+
+		if value, err := pid.Decode(answer); err == nil {
+			readings[pid.Name] = Reading{PID: pid.Name, Value: value, Unit: pid.Unit, Time: time.Now()}
+		}
+	}
+
+	return readings, nil
+}
+
+// functionalHeader returns the header addressing all ECUs on the bus a request header belongs to.
+func functionalHeader(header string) string {
+	switch len(header) {
+	case 3:
+		return "7DF"
+	case 8:
+		return "18DB33F1"
+	}
+
+	return "686AF1"
+}
+
+// pidAnswer returns the data of the first answer to command in a response, received with or without headers. An
+// answer without headers starts with the response to the requested service; otherwise the response is parsed into
+// frames and reassembled, like the UDS client does, and the first message answering the service is used.
+func pidAnswer(response string, command CommandCode) ([]byte, error) {
+	request, _ := hex.DecodeString(string(command))
+
+	data, err := answerData(response)
+	if isAdapterError(err) || err == nil && answersRequest(data, request) {
+		return data, err
+	}
+
+	if frames, frameErr := parseFrames(response); frameErr == nil {
+		for _, message := range assembleMessages(frames) {
+			if answersRequest(message.Data, request) {
+				return message.Data, nil
+			}
+		}
+	}
+
+	return data, err
+}
+
+// answersRequest reports whether data is a positive or negative response to the service of request.
+func answersRequest(data, request []byte) bool {
+	if len(request) == 0 || len(data) < 2 {
+		return false
+	}
+
+	return data[0] == request[0]+positiveResponseOffset || data[0] == negativeResponse && data[1] == request[0]
+}
+
+// answerData returns the data of the first answer in a response received without headers. Segmented answers,
+// printed as their length followed by numbered lines, are reassembled.
+func answerData(response string) ([]byte, error) {
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+
+	lines := responseLines(response)
+	if len(lines) == 0 {
+		return nil, ErrNoData
+	}
+
+	compact := strings.ReplaceAll(lines[0], " ", "")
+	if len(compact) != 3 || len(lines) < 2 || !strings.Contains(lines[1], ":") {
+		data, err := hex.DecodeString(compact)
+		if err != nil {
+			return nil, fmt.Errorf("malformed answer %q: %w", lines[0], ErrUnexpectedResponse)
+		}
+
+		return data, nil
+	}
+
+	var length int
+	if _, err := fmt.Sscanf(compact, "%03X", &length); err != nil {
+		return nil, fmt.Errorf("malformed answer length %q: %w", lines[0], ErrUnexpectedResponse)
+	}
+
+	var data []byte
+
+	for _, line := range lines[1:] {
+		_, payload, ok := strings.Cut(line, ":")
+		if !ok {
+			break
+		}
+
+		chunk, err := hex.DecodeString(strings.ReplaceAll(payload, " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("malformed answer %q: %w", line, ErrUnexpectedResponse)
+		}
+
+		data = append(data, chunk...)
+	}
+
+	if len(data) < length {
+		return nil, fmt.Errorf("answer of %d bytes is truncated to %d: %w", length, len(data), ErrUnexpectedResponse)
+	}
+
+	return data[:length], nil
+}
+
+// standardPIDs are the mode 01 PIDs every registry starts with, decoded as defined by SAE J1979.
+var standardPIDs = []PID{
+	{Name: "Engine load", ShortName: "LOAD", Command: EngineLoadCommand, Expression: "A*100/255", Unit: "%"},
+	{Name: "Coolant temperature", ShortName: "ECT", Command: CoolantTemperatureCommand, Expression: "A-40", Unit: "°C"},
+	{Name: "Short term fuel trim bank 1", ShortName: "STFT1", Command: ShortTermFuelTrimBank1Command, Expression: "A*100/128-100", Unit: "%"},
+	{Name: "Long term fuel trim bank 1", ShortName: "LTFT1", Command: LongTermFuelTrimBank1Command, Expression: "A*100/128-100", Unit: "%"},
+	{Name: "Intake manifold pressure", ShortName: "MAP", Command: IntakeManifoldPressureCommand, Expression: "A", Unit: "kPa"},
+	{Name: "Engine RPM", ShortName: "RPM", Command: EngineRPMCommand, Expression: "(A*256+B)/4", Unit: "rpm"},
+	{Name: "Vehicle speed", ShortName: "SPEED", Command: VehicleSpeedCommand, Expression: "A", Unit: "km/h"},
+	{Name: "Timing advance", ShortName: "TIMING", Command: TimingAdvanceCommand, Expression: "A/2-64", Unit: "°"},
+	{Name: "Intake air temperature", ShortName: "IAT", Command: IntakeAirTemperatureCommand, Expression: "A-40", Unit: "°C"},
+	{Name: "MAF air flow rate", ShortName: "MAF", Command: MAFCommand, Expression: "(A*256+B)/100", Unit: "g/s"},
+	{Name: "Throttle position", ShortName: "TPS", Command: ThrottlePositionCommand, Expression: "A*100/255", Unit: "%"},
+	{Name: "Run time since engine start", ShortName: "RUNTIME", Command: RunTimeSinceEngineStartCommand, Expression: "A*256+B", Unit: "s"},
+	{Name: "Fuel tank level", ShortName: "FUEL", Command: FuelTankLevelInputCommand, Expression: "A*100/255", Unit: "%"},
+	{Name: "Barometric pressure", ShortName: "BARO", Command: BarometricPressureCommand, Expression: "A", Unit: "kPa"},
+	{Name: "Control module voltage", ShortName: "VPWR", Command: ControlModuleVoltageCommand, Expression: "(A*256+B)/1000", Unit: "V"},
+	{Name: "Ambient air temperature", ShortName: "AAT", Command: AmbientAirTemperatureCommand, Expression: "A-40", Unit: "°C"},
+}
+
+// standardVirtualSensors are the virtual sensors every registry starts with. The fuel consumption assumes a
+// gasoline engine running at the stoichiometric air/fuel ratio of 14.7 with fuel weighing 740 g/l. The fuel
+// economy is undefined at standstill, where its computation fails with ErrEvaluation.
+var standardVirtualSensors = []PID{
+	{Name: "Boost pressure", ShortName: "BOOST", Expression: "MAP - BARO", Unit: "kPa"},
+	{Name: "Fuel rate", ShortName: "FUELRATE", Expression: "MAF * 3600 / (14.7 * 740)", Unit: "l/h"},
+	{Name: "Fuel economy", ShortName: "ECONOMY", Expression: "FUELRATE * 100 / SPEED", Unit: "l/100km"},
+}
@@ -0,0 +1,200 @@
+package gobd2_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+const didDefinitions = `{
+  "header": "7E0",
+  "dids": [
+    {"did": "1A2B", "name": "Oil life", "expression": "A*100/255", "unit": "%"},
+    {"did": "1A30", "name": "Transmission temperature", "offset": 1, "length": 2, "expression": "(A*256+B)/10-40", "unit": "°C"},
+    {"did": "1A31", "name": "Battery SOC", "header": "7E1", "expression": "A/2", "unit": "%"}
+  ]
+}`
+
+func TestPIDRegistry(t *testing.T) {
+	t.Parallel()
+
+	registry := gobd2.NewPIDRegistry()
+
+	rpm, err := registry.Lookup("Engine RPM")
+	require.NoError(t, err)
+	require.Equal(t, gobd2.EngineRPMCommand, rpm.Command)
+
+	value, err := rpm.Decode([]byte{0x41, 0x0C, 0x1A, 0xF8})
+	require.NoError(t, err)
+	require.InDelta(t, 1726, value, 1e-9)
+
+	_, err = rpm.Decode([]byte{0x41, 0x0D, 0x1A, 0xF8})
+	require.ErrorIs(t, err, gobd2.ErrUnexpectedResponse, "the answer is for another PID")
+
+	pids, err := gobd2.ReadDIDDefinitions(strings.NewReader(didDefinitions))
+	require.NoError(t, err)
+	require.NoError(t, registry.Register(pids...))
+
+	oilLife, err := registry.Lookup("Oil life")
+	require.NoError(t, err)
+	require.Equal(t, gobd2.CommandCode("221A2B"), oilLife.Command)
+	require.Equal(t, "7E0", oilLife.Header)
+
+	require.ErrorIs(t, registry.Register(pids[0]), gobd2.ErrDuplicatePID)
+	require.ErrorIs(t, registry.Register(gobd2.PID{Name: "Bad", Command: "0105", Expression: "A-"}), gobd2.ErrInvalidExpression)
+	require.ErrorIs(t, registry.Register(gobd2.PID{Name: "Bad", Command: "XY", Expression: "A"}), gobd2.ErrInvalidPID)
+
+	_, err = registry.Lookup("Bad")
+	require.ErrorIs(t, err, gobd2.ErrUnknownPID, "invalid PIDs are not registered")
+}
+
+func TestReadDIDDefinitions_Errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := gobd2.ReadDIDDefinitions(strings.NewReader(`{"dids": [{"did": "1A", "name": "Short", "expression": "A"}]}`))
+	require.ErrorIs(t, err, gobd2.ErrInvalidPID)
+
+	_, err = gobd2.ReadDIDDefinitions(strings.NewReader(`{"dids": [{"did": "1A2B", "name": "Typo", "expresion": "A"}]}`))
+	require.ErrorContains(t, err, "unknown field")
+}
+
+func TestCommander_ReadPID(t *testing.T) {
+	t.Parallel()
+
+	engine := &emulator.ECU{
+		Address: 0x7E8,
+		PIDs:    map[byte][]byte{0x0C: {0x1A, 0xF8}},
+		DataIdentifiers: map[uint16][]byte{
+			0x1A2B: {0xB4},
+			0x1A30: {0xFF, 0x0B, 0xB8, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+	}
+	transmission := &emulator.ECU{Address: 0x7E9, DataIdentifiers: map[uint16][]byte{0x1A31: {0x97}}}
+	connector := connectVehicle(t, emulator.NewStaticVehicle(gobd2.ProtocolCAN11Bit500, engine, transmission))
+	commander := gobd2.NewCommander(connector)
+
+	registry := gobd2.NewPIDRegistry()
+	pids, err := gobd2.ReadDIDDefinitions(strings.NewReader(didDefinitions))
+	require.NoError(t, err)
+	require.NoError(t, registry.Register(pids...))
+
+	expected := map[string]string{
+		"Engine RPM":               "1726 rpm",
+		"Oil life":                 "70.5882 %",
+		"Transmission temperature": "260 °C",
+		"Battery SOC":              "75.5 %",
+	}
+
+	for name, value := range expected {
+		pid, err := registry.Lookup(name)
+		require.NoError(t, err)
+
+		reading, err := commander.ReadPID(pid)
+		require.NoError(t, err, name)
+		require.Equal(t, name, reading.PID)
+		require.Equal(t, value, reading.String(), name)
+	}
+
+	response, err := connector.SendCommand(gobd2.EngineRPMCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0C 1A F8", response, "functional addressing is restored")
+
+	_, err = commander.ReadPID(gobd2.PID{Name: "Unknown", Command: "221A2C", Header: "7E0", Expression: "A"})
+
+	var negative *gobd2.NegativeResponseError
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCRequestOutOfRange, negative.Code)
+}
+
+func TestCommander_ReadPID_RestoresHeader(t *testing.T) {
+	t.Parallel()
+
+	engine := &emulator.ECU{Address: 0x7E8, DataIdentifiers: map[uint16][]byte{0x1A30: {0xFF, 0x0B}}}
+	connector := connectVehicle(t, emulator.NewStaticVehicle(gobd2.ProtocolCAN11Bit500, engine))
+	commander := gobd2.NewCommander(connector)
+
+	_, err := connector.SendCommand("ATSH7E0")
+	require.NoError(t, err)
+
+	temperature := gobd2.PID{Name: "Transmission temperature", Command: "221A30", Header: "7E1", Expression: "A"}
+	_, err = commander.ReadPID(temperature)
+	require.ErrorIs(t, err, gobd2.ErrNoData)
+
+	header, ok := connector.AdapterSetting("ATSH")
+	require.True(t, ok)
+	require.Equal(t, gobd2.CommandCode("ATSH7E0"), header, "the header set before the read is kept")
+
+	mockConnector := new(MockConnector)
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATSH7E0")).Return("OK", nil).Once()
+	mockConnector.On("SendCommand", gobd2.CommandCode("221A30")).Return("62 1A 30 FF", nil).Once()
+	mockConnector.On("SendCommand", gobd2.CommandCode("ATSH7DF")).Return("?", nil).Once()
+
+	temperature.Header = "7E0"
+	_, err = gobd2.NewCommander(mockConnector).ReadPID(temperature)
+	require.ErrorContains(t, err, "restoring header")
+	mockConnector.AssertExpectations(t)
+}
+
+func TestCommander_ReadPID_Headers(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		protocol gobd2.Protocol
+		address  uint32
+		header   string
+	}{
+		"11-bit CAN": {gobd2.ProtocolCAN11Bit500, 0x7E8, "7E0"},
+		"29-bit CAN": {gobd2.ProtocolCAN29Bit500, 0x18DAF110, "18DA10F1"},
+		"legacy":     {gobd2.ProtocolISO9141, 0x10, ""},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			engine := &emulator.ECU{
+				Address:         tt.address,
+				PIDs:            map[byte][]byte{0x0C: {0x1A, 0xF8}},
+				DataIdentifiers: map[uint16][]byte{0x1A30: {0xFF, 0x0B, 0xB8, 0x00, 0x00, 0x00, 0x00, 0x00}},
+			}
+			connector := connectVehicle(t, emulator.NewStaticVehicle(tt.protocol, engine))
+			commander := gobd2.NewCommander(connector)
+
+			_, err := connector.SendCommand("ATH1")
+			require.NoError(t, err)
+
+			rpm, err := gobd2.NewPIDRegistry().Lookup("RPM")
+			require.NoError(t, err)
+
+			reading, err := commander.ReadPID(rpm)
+			require.NoError(t, err)
+			require.Equal(t, "1726 rpm", reading.String())
+
+			if tt.header == "" {
+				return
+			}
+
+			temperature := gobd2.PID{
+				Name:       "Transmission temperature",
+				Command:    "221A30",
+				Header:     tt.header,
+				Offset:     1,
+				Length:     2,
+				Expression: "(A*256+B)/10-40",
+				Unit:       "°C",
+			}
+
+			reading, err = commander.ReadPID(temperature)
+			require.NoError(t, err, "segmented answers are reassembled")
+			require.Equal(t, "260 °C", reading.String())
+
+			_, err = commander.ReadPID(gobd2.PID{Name: "Unknown", Command: "221A2C", Header: tt.header, Expression: "A"})
+
+			var negative *gobd2.NegativeResponseError
+			require.ErrorAs(t, err, &negative)
+		})
+	}
+}
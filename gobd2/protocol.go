@@ -0,0 +1,246 @@
+package gobd2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Protocol identifies an OBD-II protocol by its ELM327 protocol number.
+type Protocol byte
+
+// Protocols supported by ELM327-compatible adapters.
+const (
+	ProtocolAuto            Protocol = 0x0
+	ProtocolJ1850PWM        Protocol = 0x1
+	ProtocolJ1850VPW        Protocol = 0x2
+	ProtocolISO9141         Protocol = 0x3
+	ProtocolKWPSlow         Protocol = 0x4
+	ProtocolKWPFast         Protocol = 0x5
+	ProtocolCAN11Bit500     Protocol = 0x6
+	ProtocolCAN29Bit500     Protocol = 0x7
+	ProtocolCAN11Bit250     Protocol = 0x8
+	ProtocolCAN29Bit250     Protocol = 0x9
+	ProtocolJ1939           Protocol = 0xA
+	ProtocolUserCAN11Bit125 Protocol = 0xB
+	ProtocolUserCAN11Bit50  Protocol = 0xC
+	protocolCount           Protocol = 0xD
+)
+
+// protocolNumberCommand asks the adapter for the number of the protocol in use. Protocols found by the automatic
+// search are reported with an "A" prefix.
+const protocolNumberCommand CommandCode = "ATDPN"
+
+var protocolNames = [protocolCount]string{
+	"auto",
+	"j1850-pwm",
+	"j1850-vpw",
+	"iso9141-2",
+	"kwp2000-slow",
+	"kwp2000-fast",
+	"can-11bit-500k",
+	"can-29bit-500k",
+	"can-11bit-250k",
+	"can-29bit-250k",
+	"j1939",
+	"user-can-11bit-125k",
+	"user-can-11bit-50k",
+}
+
+var protocolDescriptions = [protocolCount]string{
+	"AUTO",
+	"SAE J1850 PWM",
+	"SAE J1850 VPW",
+	"ISO 9141-2",
+	"ISO 14230-4 (KWP 5BAUD)",
+	"ISO 14230-4 (KWP FAST)",
+	"ISO 15765-4 (CAN 11/500)",
+	"ISO 15765-4 (CAN 29/500)",
+	"ISO 15765-4 (CAN 11/250)",
+	"ISO 15765-4 (CAN 29/250)",
+	"SAE J1939 (CAN 29/250)",
+	"USER1 (CAN 11/125)",
+	"USER2 (CAN 11/50)",
+}
+
+// ErrUnknownProtocol is returned when a protocol number or name is not recognized.
+var ErrUnknownProtocol = errors.New("unknown protocol")
+
+// String returns the short name of the protocol.
+func (p Protocol) String() string {
+	if p >= protocolCount {
+		return "protocol-" + strconv.Itoa(int(p))
+	}
+
+	return protocolNames[p]
+}
+
+// Description returns the description an ELM327 reports for the protocol (ATDP), e.g. "ISO 15765-4 (CAN 11/500)".
+func (p Protocol) Description() string {
+	if p >= protocolCount {
+		return p.String()
+	}
+
+	return protocolDescriptions[p]
+}
+
+// Is29Bit reports whether the protocol uses 29-bit CAN identifiers.
+func (p Protocol) Is29Bit() bool {
+	return p == ProtocolCAN29Bit500 || p == ProtocolCAN29Bit250 || p == ProtocolJ1939
+}
+
+// IsCAN reports whether the protocol runs on a CAN bus.
+func (p Protocol) IsCAN() bool {
+	return p >= ProtocolCAN11Bit500 && p < protocolCount
+}
+
+// MarshalText encodes the protocol as its short name.
+func (p Protocol) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a protocol from its short name or ELM327 protocol number.
+func (p *Protocol) UnmarshalText(text []byte) error {
+	protocol, err := ParseProtocol(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = protocol
+
+	return nil
+}
+
+// ParseProtocol parses a protocol short name such as "can-11bit-500k" or an ELM327 protocol number as reported by
+// ATDPN. A leading "A", which ATDPN uses to mark an automatically detected protocol, is ignored.
+func ParseProtocol(s string) (Protocol, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+
+	for i, name := range protocolNames {
+		if value == name {
+			return Protocol(i), nil
+		}
+	}
+
+	if len(value) == 2 && strings.HasPrefix(value, "a") {
+		value = value[1:]
+	}
+
+	number, err := strconv.ParseUint(value, 16, 8)
+	if err != nil || number >= uint64(protocolCount) {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownProtocol, s)
+	}
+
+	return Protocol(number), nil
+}
+
+// DetectProtocol makes the adapter negotiate a protocol with the vehicle, if it has not done so yet, and reports
+// the protocol in use.
+func DetectProtocol(connector Connector) (Protocol, error) {
+	response, err := connector.SendCommand(SupportedPIDsCommand1_20)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := responseError(response); err != nil && !errors.Is(err, ErrNoData) {
+		return 0, err
+	}
+
+	return queryProtocol(connector)
+}
+
+// queryProtocol asks the adapter which protocol it currently uses.
+func queryProtocol(connector Connector) (Protocol, error) {
+	response, err := connector.SendCommand(protocolNumberCommand)
+	if err != nil {
+		return 0, err
+	}
+
+	lines := responseLines(response)
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("%w: empty response", ErrUnknownProtocol)
+	}
+
+	return ParseProtocol(lines[len(lines)-1])
+}
+
+// ProtocolStore remembers the protocol negotiated with each vehicle, so that later connections can skip the
+// protocol search.
+type ProtocolStore interface {
+	LoadProtocol(vehicleID string) (Protocol, bool, error)
+	SaveProtocol(vehicleID string, protocol Protocol) error
+}
+
+// FileProtocolStore is a ProtocolStore backed by a JSON file.
+type FileProtocolStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileProtocolStore creates a store persisting protocols in the JSON file at path.
+func NewFileProtocolStore(path string) *FileProtocolStore {
+	return &FileProtocolStore{path: path}
+}
+
+// LoadProtocol returns the protocol stored for the vehicle, if any.
+func (s *FileProtocolStore) LoadProtocol(vehicleID string) (Protocol, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	protocols, err := s.read()
+	if err != nil {
+		return 0, false, err
+	}
+
+	protocol, ok := protocols[vehicleID]
+
+	return protocol, ok, nil
+}
+
+// SaveProtocol stores the protocol for the vehicle.
+func (s *FileProtocolStore) SaveProtocol(vehicleID string, protocol Protocol) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	protocols, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	protocols[vehicleID] = protocol
+
+	data, err := json.MarshalIndent(protocols, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path, data, 0o600)
+}
+
+func (s *FileProtocolStore) read() (map[string]Protocol, error) {
+	protocols := map[string]Protocol{}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return protocols, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &protocols); err != nil {
+		return nil, fmt.Errorf("failed to parse protocol store %s: %w", s.path, err)
+	}
+
+	return protocols, nil
+}
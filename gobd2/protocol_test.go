@@ -0,0 +1,103 @@
+package gobd2_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProtocol(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input    string
+		expected gobd2.Protocol
+	}{
+		{"6", gobd2.ProtocolCAN11Bit500},
+		{"A6", gobd2.ProtocolCAN11Bit500},
+		{"a", gobd2.ProtocolJ1939},
+		{"0", gobd2.ProtocolAuto},
+		{"j1850-vpw", gobd2.ProtocolJ1850VPW},
+		{"KWP2000-FAST", gobd2.ProtocolKWPFast},
+		{"C", gobd2.ProtocolUserCAN11Bit50},
+		{"user-can-11bit-125k", gobd2.ProtocolUserCAN11Bit125},
+	}
+
+	for _, tt := range tests {
+		protocol, err := gobd2.ParseProtocol(tt.input)
+		require.NoError(t, err, tt.input)
+		require.Equal(t, tt.expected, protocol, tt.input)
+	}
+
+	_, err := gobd2.ParseProtocol("D")
+	require.ErrorIs(t, err, gobd2.ErrUnknownProtocol)
+}
+
+func TestProtocol_Is29Bit(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, gobd2.ProtocolCAN29Bit500.Is29Bit())
+	require.True(t, gobd2.ProtocolJ1939.Is29Bit())
+	require.False(t, gobd2.ProtocolCAN11Bit250.Is29Bit())
+	require.False(t, gobd2.ProtocolUserCAN11Bit125.Is29Bit())
+	require.False(t, gobd2.ProtocolUserCAN11Bit50.Is29Bit())
+	require.Equal(t, "USER2 (CAN 11/50)", gobd2.ProtocolUserCAN11Bit50.Description())
+}
+
+func TestFileProtocolStore(t *testing.T) {
+	t.Parallel()
+
+	store := gobd2.NewFileProtocolStore(filepath.Join(t.TempDir(), "gobd2", "protocols.json"))
+
+	_, ok, err := store.LoadProtocol("car")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, store.SaveProtocol("car", gobd2.ProtocolISO9141))
+
+	protocol, ok, err := store.LoadProtocol("car")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, gobd2.ProtocolISO9141, protocol)
+}
+
+func TestSerialConnector_Connect_StoredProtocol(t *testing.T) {
+	t.Parallel()
+
+	store := gobd2.NewFileProtocolStore(filepath.Join(t.TempDir(), "protocols.json"))
+	require.NoError(t, store.SaveProtocol("car", gobd2.ProtocolKWPFast))
+
+	mockPort := &MockSerialPort{}
+	mockPort.buf.WriteString("ELM327 v1.5\r>OK\r>OK\r>OK\r>BUS INIT: ...OK\r41 00 BE 1F A8 13\r>A5\r>")
+
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(mockPort, nil)
+
+	connector := gobd2.NewSerialConnector("COM1", 38400, mockOpener, gobd2.WithProtocolStore(store, "car"))
+	require.NoError(t, connector.Connect())
+
+	// The stored protocol is tried first, commands are appended to the shared buffer after the responses.
+	require.Contains(t, mockPort.buf.String(), "ATTPA5\r0100\rATDPN\r")
+
+	protocol, ok, err := store.LoadProtocol("car")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, gobd2.ProtocolKWPFast, protocol)
+}
+
+func TestSerialConnector_Connect_ForcedProtocol(t *testing.T) {
+	t.Parallel()
+
+	mockPort := &MockSerialPort{}
+	mockPort.buf.WriteString("ELM327 v1.5\r>OK\r>OK\r>OK\r>")
+
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(mockPort, nil)
+
+	connector := gobd2.NewSerialConnector("COM1", 38400, mockOpener, gobd2.WithProtocol(gobd2.ProtocolJ1850PWM))
+	require.NoError(t, connector.Connect())
+	require.Contains(t, mockPort.buf.String(), "ATSP1\r")
+}
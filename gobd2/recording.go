@@ -0,0 +1,359 @@
+package gobd2
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by a ReplayConnector.
+var (
+	ErrReplayMismatch  = errors.New("command does not match the recording")
+	ErrReplayExhausted = errors.New("recording exhausted")
+)
+
+// Exchange is a single command sent through a RecordingConnector, together with its outcome.
+type Exchange struct {
+	// Time is when the command was sent.
+	Time time.Time `json:"time"`
+	// Command is the command as sent to the connector.
+	Command CommandCode `json:"command"`
+	// Response is the response the connector returned.
+	Response string `json:"response"`
+	// Duration is how long the connector took to answer.
+	Duration time.Duration `json:"duration"`
+	// Error is the message of the error the connector returned, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// RecordingConnector wraps a Connector and writes every command, response, timing and error passing through it to
+// a recording, one JSON encoded Exchange per line. A ReplayConnector serves a recording back.
+//
+// The streaming, capabilities and settings tracking of the wrapped connector are passed through, so recording does
+// not change how the library talks to the adapter. Streamed output is recorded as one exchange once it ends.
+type RecordingConnector struct {
+	Connector
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewRecordingConnector creates a connector recording the commands sent through connector to w.
+func NewRecordingConnector(connector Connector, w io.Writer) *RecordingConnector {
+	return &RecordingConnector{Connector: connector, encoder: json.NewEncoder(w)}
+}
+
+// CreateRecording creates a connector recording the commands sent through connector to the file at path. The file
+// is closed together with the connector.
+func CreateRecording(connector Connector, path string) (*RecordingConnector, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := NewRecordingConnector(connector, file)
+	rc.closer = file
+
+	return rc, nil
+}
+
+// SendCommand sends command through the wrapped connector and records the exchange.
+func (rc *RecordingConnector) SendCommand(command CommandCode) (string, error) {
+	sent := time.Now()
+	response, err := rc.Connector.SendCommand(command)
+
+	return response, rc.record(sent, command, response, err)
+}
+
+// Stream streams the output of a monitoring command through the wrapped connector, if it supports streaming, and
+// records the lines passed to handle as the response of one exchange.
+func (rc *RecordingConnector) Stream(ctx context.Context, command CommandCode, handle func(line string)) error {
+	streamer, ok := rc.Connector.(StreamingConnector)
+	if !ok {
+		return ErrStreamingUnsupported
+	}
+
+	var lines []string
+
+	sent := time.Now()
+	err := streamer.Stream(ctx, command, func(line string) {
+		lines = append(lines, line)
+		handle(line)
+	})
+
+	return rc.record(sent, command, strings.Join(lines, "\r"), err)
+}
+
+// record writes an exchange to the recording and returns the connector's error, or else the error writing it.
+func (rc *RecordingConnector) record(sent time.Time, command CommandCode, response string, err error) error {
+	exchange := Exchange{Time: sent.UTC(), Command: command, Response: response, Duration: time.Since(sent)}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if encodeErr := rc.encoder.Encode(exchange); encodeErr != nil && err == nil {
+		return fmt.Errorf("failed to record %s: %w", command, encodeErr)
+	}
+
+	return err
+}
+
+// Capabilities returns the capabilities of the wrapped connector's adapter, none if it does not know them.
+func (rc *RecordingConnector) Capabilities() Capabilities {
+	if provider, ok := rc.Connector.(CapabilityProvider); ok {
+		return provider.Capabilities()
+	}
+
+	return 0
+}
+
+// Adapter returns the adapter identified by the wrapped connector, nil if it does not know it.
+func (rc *RecordingConnector) Adapter() *AdapterFingerprint {
+	if provider, ok := rc.Connector.(CapabilityProvider); ok {
+		return provider.Adapter()
+	}
+
+	return nil
+}
+
+// AdapterSetting returns the setting tracked by the wrapped connector, if it tracks settings.
+func (rc *RecordingConnector) AdapterSetting(name string) (CommandCode, bool) {
+	if tracker, ok := rc.Connector.(SettingsTracker); ok {
+		return tracker.AdapterSetting(name)
+	}
+
+	return "", false
+}
+
+// Close closes the wrapped connector and the recording file, if the connector created it.
+func (rc *RecordingConnector) Close() error {
+	err := rc.Connector.Close()
+
+	if rc.closer != nil {
+		err = errors.Join(err, rc.closer.Close())
+	}
+
+	return err
+}
+
+// ReadRecording reads the exchanges written by a RecordingConnector.
+func ReadRecording(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		exchanges = append(exchanges, exchange)
+	}
+
+	return exchanges, scanner.Err()
+}
+
+// LoadRecording reads the recording file at path.
+func LoadRecording(path string) ([]Exchange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	exchanges, err := ReadRecording(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse recording %s: %w", path, err)
+	}
+
+	return exchanges, nil
+}
+
+// ReplayOrder selects how a ReplayConnector picks the exchange answering a command.
+type ReplayOrder int
+
+const (
+	// ReplayStrict expects the commands in exactly the recorded order and fails on the first one that differs.
+	ReplayStrict ReplayOrder = iota
+	// ReplayByCommand answers each command with the next exchange recorded for the same command, regardless of
+	// the order of other commands, and starts over once all of them were served.
+	ReplayByCommand
+)
+
+// ReplayOption configures optional behavior of a ReplayConnector.
+type ReplayOption func(*ReplayConnector)
+
+// WithReplayOrder selects how commands are matched to the recording. The default is ReplayStrict.
+func WithReplayOrder(order ReplayOrder) ReplayOption {
+	return func(rc *ReplayConnector) {
+		rc.order = order
+	}
+}
+
+// WithReplaySpeed makes the connector take as long to answer as the recorded connector did, and keeps the recorded
+// pauses between commands, divided by speed: 1 replays in real time, 2 twice as fast. Time the caller spends between
+// commands counts towards the pauses. By default answers are returned immediately.
+func WithReplaySpeed(speed float64) ReplayOption {
+	return func(rc *ReplayConnector) {
+		rc.speed = speed
+	}
+}
+
+// ReplayConnector is a Connector serving the exchanges of a recording back instead of talking to an adapter.
+type ReplayConnector struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	order     ReplayOrder
+	speed     float64
+	next      int
+	served    map[CommandCode]int
+	// previous is the index of the exchange served last, -1 before the first, answered at the time in answered.
+	previous int
+	answered time.Time
+}
+
+// NewReplayConnector creates a connector replaying exchanges.
+func NewReplayConnector(exchanges []Exchange, opts ...ReplayOption) *ReplayConnector {
+	rc := &ReplayConnector{exchanges: exchanges, served: map[CommandCode]int{}, previous: -1}
+
+	for _, opt := range opts {
+		opt(rc)
+	}
+
+	return rc
+}
+
+// Connect rewinds the recording to its beginning.
+func (rc *ReplayConnector) Connect() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.next = 0
+	rc.served = map[CommandCode]int{}
+	rc.previous = -1
+
+	return nil
+}
+
+// Close does nothing, the recording is kept in memory.
+func (rc *ReplayConnector) Close() error {
+	return nil
+}
+
+// SendCommand returns the recorded response and error of the exchange matching command.
+func (rc *ReplayConnector) SendCommand(command CommandCode) (string, error) {
+	exchange, delay, err := rc.serve(command)
+	if err != nil {
+		return "", err
+	}
+
+	time.Sleep(delay)
+
+	return exchange.Response, replayedError(exchange.Error)
+}
+
+// serve picks the exchange answering command and returns how long to wait before answering.
+func (rc *ReplayConnector) serve(command CommandCode) (Exchange, time.Duration, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	index, err := rc.match(command)
+	if err != nil {
+		return Exchange{}, 0, err
+	}
+
+	exchange := rc.exchanges[index]
+	if rc.speed <= 0 {
+		return exchange, 0, nil
+	}
+
+	var delay time.Duration
+
+	// Only the exchange recorded right after the one served last is paused for. Exchanges replayed out of order, or
+	// recorded without times, are answered without a pause.
+	if rc.previous >= 0 && index == rc.previous+1 && !rc.exchanges[rc.previous].Time.IsZero() {
+		previous := rc.exchanges[rc.previous]
+		pause := exchange.Time.Sub(previous.Time.Add(previous.Duration))
+		delay = max(time.Duration(float64(pause)/rc.speed)-time.Since(rc.answered), 0)
+	}
+
+	delay += time.Duration(float64(exchange.Duration) / rc.speed)
+
+	rc.previous = index
+	rc.answered = time.Now().Add(delay)
+
+	return exchange, delay, nil
+}
+
+// match returns the index of the exchange answering command according to the replay order, with the connector
+// locked.
+func (rc *ReplayConnector) match(command CommandCode) (int, error) {
+	if rc.order == ReplayByCommand {
+		var recorded []int
+
+		for i, exchange := range rc.exchanges {
+			if exchange.Command == command {
+				recorded = append(recorded, i)
+			}
+		}
+
+		if len(recorded) == 0 {
+			return -1, fmt.Errorf("%s: %w", command, ErrReplayMismatch)
+		}
+
+		index := recorded[rc.served[command]%len(recorded)]
+		rc.served[command]++
+
+		return index, nil
+	}
+
+	if rc.next >= len(rc.exchanges) {
+		return -1, fmt.Errorf("%s: %w", command, ErrReplayExhausted)
+	}
+
+	exchange := rc.exchanges[rc.next]
+	if exchange.Command != command {
+		return -1, fmt.Errorf("%s sent, %s recorded at position %d: %w", command, exchange.Command, rc.next, ErrReplayMismatch)
+	}
+
+	rc.next++
+
+	return rc.next - 1, nil
+}
+
+// replayableErrors are the errors a replayed error is matched against, so that errors.Is works on it as it did on
+// the recorded error.
+var replayableErrors = []error{
+	ErrTimeout, ErrAdapterReset, ErrNoData, ErrUnableToConnect, ErrCANError, ErrBusError, ErrUnknownCommand, ErrBufferFull,
+}
+
+// replayedError recreates a recorded error from its message.
+func replayedError(message string) error {
+	if message == "" {
+		return nil
+	}
+
+	for _, err := range replayableErrors {
+		if prefix, found := strings.CutSuffix(message, err.Error()); found {
+			return fmt.Errorf("%s%w", prefix, err)
+		}
+	}
+
+	return errors.New(message)
+}
@@ -0,0 +1,265 @@
+package gobd2_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingConnector(t *testing.T) {
+	t.Parallel()
+
+	injector := emulator.NewFaultInjector(1)
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	opener := &emulator.Opener{Vehicle: emulator.NewDemoVehicle(), Options: []emulator.Option{emulator.WithFaultInjector(injector)}}
+	connector := gobd2.NewSerialConnector("emulator", 38400, opener, gobd2.WithInitProfile(profile))
+	require.NoError(t, connector.Connect())
+
+	path := filepath.Join(t.TempDir(), "drive.jsonl")
+
+	recorder, err := gobd2.CreateRecording(connector, path)
+	require.NoError(t, err)
+
+	commander := gobd2.NewCommander(recorder)
+
+	rpm, err := commander.ExecuteCommand(gobd2.EngineRPMCommand)
+	require.NoError(t, err)
+
+	_, err = commander.ExecuteCommand("0902")
+	require.NoError(t, err)
+
+	injector.Inject(emulator.FaultLowVoltageReset)
+
+	_, err = commander.ExecuteCommand(gobd2.VehicleSpeedCommand)
+	require.ErrorIs(t, err, gobd2.ErrAdapterReset)
+
+	require.NoError(t, recorder.Close())
+
+	exchanges, err := gobd2.LoadRecording(path)
+	require.NoError(t, err)
+	require.Len(t, exchanges, 3)
+	require.Equal(t, gobd2.EngineRPMCommand, exchanges[0].Command)
+	require.Equal(t, rpm, exchanges[0].Response)
+	require.Positive(t, exchanges[0].Duration)
+	require.False(t, exchanges[0].Time.IsZero())
+	require.Empty(t, exchanges[0].Error)
+	require.Equal(t, "010D: adapter reset", exchanges[2].Error)
+
+	// The replay answers like the vehicle did, errors included.
+	replay := gobd2.NewReplayConnector(exchanges)
+	require.NoError(t, replay.Connect())
+
+	response, err := replay.SendCommand(gobd2.EngineRPMCommand)
+	require.NoError(t, err)
+	require.Equal(t, rpm, response)
+
+	_, err = replay.SendCommand("0902")
+	require.NoError(t, err)
+
+	_, err = replay.SendCommand(gobd2.VehicleSpeedCommand)
+	require.ErrorIs(t, err, gobd2.ErrAdapterReset)
+	require.EqualError(t, err, exchanges[2].Error)
+}
+
+func TestRecordingConnector_PassesThrough(t *testing.T) {
+	t.Parallel()
+
+	port := &MonitoringSerialPort{ScriptedSerialPort: newScriptedSerialPort(map[string]string{
+		"STI":      "STN2255 v5.6.19",
+		"STCSEGT1": "OK",
+		"ATH1":     "OK",
+	})}
+
+	mockOpener := new(MockPortOpener)
+	mockOpener.On("OpenPort", mock.Anything).Return(port, nil)
+
+	connector := gobd2.NewSerialConnector("COM1", 115200, mockOpener, gobd2.WithTimeout(time.Second),
+		gobd2.WithSTNExtensions())
+	require.NoError(t, connector.Connect())
+
+	var recording bytes.Buffer
+
+	recorder := gobd2.NewRecordingConnector(connector, &recording)
+	require.True(t, recorder.Capabilities().Has(gobd2.CapabilitySTN))
+
+	_, err := recorder.SendCommand("ATH1")
+	require.NoError(t, err)
+
+	setting, ok := recorder.AdapterSetting("ATH")
+	require.True(t, ok)
+	require.Equal(t, gobd2.CommandCode("ATH1"), setting)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var frames []gobd2.Frame
+
+	require.NoError(t, gobd2.NewELM327(recorder).MonitorAll(ctx, func(frame gobd2.Frame) {
+		frames = append(frames, frame)
+		if len(frames) == 2 {
+			cancel()
+		}
+	}))
+
+	exchanges, err := gobd2.ReadRecording(&recording)
+	require.NoError(t, err)
+	require.Len(t, exchanges, 2)
+	require.Equal(t, gobd2.CommandCode("ATMA"), exchanges[1].Command)
+	require.True(t, strings.HasPrefix(exchanges[1].Response, "7E8 03 41 0D 20\r7E8 03 41 0D 20"))
+	require.True(t, strings.HasSuffix(exchanges[1].Response, "STOPPED"))
+
+	err = gobd2.NewELM327(gobd2.NewRecordingConnector(new(MockConnector), &recording)).MonitorAll(ctx,
+		func(gobd2.Frame) {})
+	require.ErrorIs(t, err, gobd2.ErrStreamingUnsupported)
+}
+
+func TestReplayConnector_Strict(t *testing.T) {
+	t.Parallel()
+
+	replay := gobd2.NewReplayConnector([]gobd2.Exchange{
+		{Command: "010C", Response: "41 0C 0C 80"},
+		{Command: "010D", Response: "41 0D 20"},
+	})
+
+	_, err := replay.SendCommand("010D")
+	require.ErrorIs(t, err, gobd2.ErrReplayMismatch)
+
+	response, err := replay.SendCommand("010C")
+	require.NoError(t, err)
+	require.Equal(t, "41 0C 0C 80", response)
+
+	response, err = replay.SendCommand("010D")
+	require.NoError(t, err)
+	require.Equal(t, "41 0D 20", response)
+
+	_, err = replay.SendCommand("010C")
+	require.ErrorIs(t, err, gobd2.ErrReplayExhausted)
+
+	require.NoError(t, replay.Connect(), "connecting rewinds the recording")
+
+	response, err = replay.SendCommand("010C")
+	require.NoError(t, err)
+	require.Equal(t, "41 0C 0C 80", response)
+}
+
+func TestReplayConnector_ByCommand(t *testing.T) {
+	t.Parallel()
+
+	recording := strings.Join([]string{
+		`{"command":"010C","response":"41 0C 0C 80","duration":0}`,
+		`{"command":"010D","response":"41 0D 20","duration":0}`,
+		`{"command":"010C","response":"41 0C 1A F8","duration":0}`,
+		`{"command":"010D","error":"010D: timeout waiting for adapter","duration":0}`,
+	}, "\n")
+
+	exchanges, err := gobd2.ReadRecording(strings.NewReader(recording))
+	require.NoError(t, err)
+
+	replay := gobd2.NewReplayConnector(exchanges, gobd2.WithReplayOrder(gobd2.ReplayByCommand))
+
+	for _, expected := range []string{"41 0C 0C 80", "41 0C 1A F8", "41 0C 0C 80"} {
+		response, err := replay.SendCommand("010C")
+		require.NoError(t, err)
+		require.Equal(t, expected, response)
+	}
+
+	response, err := replay.SendCommand("010D")
+	require.NoError(t, err)
+	require.Equal(t, "41 0D 20", response)
+
+	_, err = replay.SendCommand("010D")
+	require.ErrorIs(t, err, gobd2.ErrTimeout)
+
+	_, err = replay.SendCommand("0105")
+	require.ErrorIs(t, err, gobd2.ErrReplayMismatch)
+}
+
+func TestReplayConnector_Speed(t *testing.T) {
+	t.Parallel()
+
+	exchanges := []gobd2.Exchange{{Command: "010C", Response: "41 0C 0C 80", Duration: 200 * time.Millisecond}}
+
+	for _, test := range []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{speed: 0, min: 0, max: 50 * time.Millisecond},
+		{speed: 1, min: 200 * time.Millisecond, max: time.Second},
+		{speed: 10, min: 20 * time.Millisecond, max: 150 * time.Millisecond},
+	} {
+		replay := gobd2.NewReplayConnector(exchanges, gobd2.WithReplaySpeed(test.speed))
+
+		start := time.Now()
+		_, err := replay.SendCommand("010C")
+		elapsed := time.Since(start)
+
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, elapsed, test.min, "speed %v", test.speed)
+		require.Less(t, elapsed, test.max, "speed %v", test.speed)
+	}
+}
+
+func TestReplayConnector_Pauses(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	exchanges := []gobd2.Exchange{
+		{Time: start, Command: "010C", Response: "41 0C 0C 80", Duration: 100 * time.Millisecond},
+		{Time: start.Add(400 * time.Millisecond), Command: "010D", Response: "41 0D 20", Duration: 100 * time.Millisecond},
+	}
+
+	for _, test := range []struct {
+		speed    float64
+		thinking time.Duration
+		min, max time.Duration
+	}{
+		{speed: 1, min: 380 * time.Millisecond, max: 600 * time.Millisecond},
+		{speed: 2, min: 180 * time.Millisecond, max: 300 * time.Millisecond},
+		{speed: 1, thinking: 200 * time.Millisecond, min: 380 * time.Millisecond, max: 600 * time.Millisecond},
+	} {
+		replay := gobd2.NewReplayConnector(exchanges, gobd2.WithReplaySpeed(test.speed))
+
+		_, err := replay.SendCommand("010C")
+		require.NoError(t, err)
+
+		answered := time.Now()
+
+		time.Sleep(test.thinking)
+
+		_, err = replay.SendCommand("010D")
+		require.NoError(t, err)
+
+		elapsed := time.Since(answered)
+		require.GreaterOrEqual(t, elapsed, test.min, "speed %v", test.speed)
+		require.Less(t, elapsed, test.max, "speed %v", test.speed)
+	}
+
+	exchanges = append(exchanges, gobd2.Exchange{Time: start.Add(2 * time.Second), Command: "0105", Response: "41 05 5A"})
+	replay := gobd2.NewReplayConnector(exchanges, gobd2.WithReplayOrder(gobd2.ReplayByCommand), gobd2.WithReplaySpeed(1))
+
+	_, err := replay.SendCommand("010C")
+	require.NoError(t, err)
+
+	answered := time.Now()
+
+	_, err = replay.SendCommand("0105")
+	require.NoError(t, err)
+	require.Less(t, time.Since(answered), 300*time.Millisecond, "skipping ahead in the recording is not paused for")
+}
+
+func TestReadRecording_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := gobd2.ReadRecording(bytes.NewBufferString("{\"command\":\"010C\"}\nnot json\n"))
+	require.ErrorContains(t, err, "line 2")
+}
@@ -0,0 +1,209 @@
+package gobd2
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors reported by the adapter in place of a vehicle response.
+var (
+	ErrNoData          = errors.New("no data")
+	ErrUnableToConnect = errors.New("unable to connect")
+	ErrCANError        = errors.New("can error")
+	ErrBusError        = errors.New("bus error")
+	ErrUnknownCommand  = errors.New("unknown command")
+	ErrBufferFull      = errors.New("buffer full")
+	ErrAdapterReset    = errors.New("adapter reset")
+)
+
+// adapterErrors maps ELM327 status messages to the errors they represent.
+var adapterErrors = map[string]error{
+	"NO DATA":           ErrNoData,
+	"UNABLE TO CONNECT": ErrUnableToConnect,
+	"CAN ERROR":         ErrCANError,
+	"BUS ERROR":         ErrBusError,
+	"?":                 ErrUnknownCommand,
+	"BUFFER FULL":       ErrBufferFull,
+	"LV RESET":          ErrAdapterReset,
+}
+
+// Frame is a single line of an ELM327 response received with headers enabled.
+type Frame struct {
+	// Header is the hex encoded header of the frame, e.g. "7E8", "18DAF110" or "486B10".
+	Header string
+	// Data holds the frame payload without header and checksum.
+	Data []byte
+}
+
+// ECUMessage is a complete message received from a single ECU.
+type ECUMessage struct {
+	// Address is the header the ECU answered with.
+	Address string
+	// Data holds the message payload, reassembled from all frames of the message.
+	Data []byte
+}
+
+// responseLines splits a raw response into its lines, dropping blank lines and adapter progress messages.
+func responseLines(response string) []string {
+	fields := strings.FieldsFunc(response, func(r rune) bool { return r == '\r' || r == '\n' })
+
+	lines := make([]string, 0, len(fields))
+
+	for _, field := range fields {
+		line := strings.TrimSpace(field)
+		if line == "" || line == ">" || strings.HasPrefix(line, "SEARCHING") || strings.HasPrefix(line, "BUS INIT") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// responseError returns the error the adapter reported in response, or nil if there is none.
+func responseError(response string) error {
+	for _, line := range responseLines(response) {
+		if err, ok := adapterErrors[line]; ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isAdapterError reports whether err is one of the errors the adapter reports in place of a vehicle response.
+func isAdapterError(err error) bool {
+	for _, adapterErr := range adapterErrors {
+		if errors.Is(err, adapterErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseFrames parses a response received with headers enabled into frames.
+//
+// Spaces between bytes are optional. The header format is derived from the line itself: an odd number of hex
+// digits means an 11-bit CAN identifier, a line starting with 18DA or 18DB carries a 29-bit CAN identifier, and
+// anything else is treated as a legacy (J1850/ISO 9141/KWP) frame with a three byte header and trailing checksum.
+func parseFrames(response string) ([]Frame, error) {
+	if err := responseError(response); err != nil {
+		return nil, err
+	}
+
+	lines := responseLines(response)
+	frames := make([]Frame, 0, len(lines))
+
+	for _, line := range lines {
+		frame, err := parseFrame(strings.ReplaceAll(line, " ", ""))
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
+
+func parseFrame(line string) (Frame, error) {
+	var (
+		header  string
+		payload string
+		legacy  bool
+	)
+
+	switch {
+	case len(line) < 3:
+		return Frame{}, fmt.Errorf("malformed frame %q", line)
+	case len(line)%2 == 1:
+		header, payload = line[:3], line[3:]
+	case strings.HasPrefix(line, "18DA") || strings.HasPrefix(line, "18DB"):
+		if len(line) < 8 {
+			return Frame{}, fmt.Errorf("malformed frame %q", line)
+		}
+
+		header, payload = line[:8], line[8:]
+	default:
+		if len(line) < 8 {
+			return Frame{}, fmt.Errorf("malformed frame %q", line)
+		}
+
+		header, payload, legacy = line[:6], line[6:], true
+	}
+
+	data, err := hex.DecodeString(payload)
+	if err != nil {
+		return Frame{}, fmt.Errorf("malformed frame %q: %w", line, err)
+	}
+
+	if legacy {
+		data = data[:len(data)-1]
+	}
+
+	return Frame{Header: header, Data: data}, nil
+}
+
+// isCAN reports whether the frame was received on a CAN bus.
+func (f Frame) isCAN() bool {
+	return len(f.Header) == 3 || len(f.Header) == 8
+}
+
+// assembleMessages groups frames by the ECU that sent them and reassembles ISO-TP segmented CAN messages.
+// Messages are returned in the order their first frame was received. Legacy frames are returned as
+// individual messages.
+func assembleMessages(frames []Frame) []ECUMessage {
+	var messages []ECUMessage
+
+	pending := map[string]int{} // index of the message still being reassembled for each CAN header
+	remaining := map[string]int{}
+
+	for _, frame := range frames {
+		if !frame.isCAN() || len(frame.Data) == 0 {
+			messages = append(messages, ECUMessage{Address: frame.Header, Data: frame.Data})
+
+			continue
+		}
+
+		pci := frame.Data[0] >> 4
+
+		switch {
+		case pci == 0x0:
+			length := min(int(frame.Data[0]&0x0F), len(frame.Data)-1)
+			messages = append(messages, ECUMessage{Address: frame.Header, Data: frame.Data[1 : 1+length]})
+		case pci == 0x1 && len(frame.Data) >= 2:
+			length := int(frame.Data[0]&0x0F)<<8 | int(frame.Data[1])
+			data := append([]byte{}, frame.Data[2:]...)
+			if len(data) > length {
+				data = data[:length]
+			}
+
+			pending[frame.Header] = len(messages)
+			remaining[frame.Header] = length - len(data)
+			messages = append(messages, ECUMessage{Address: frame.Header, Data: data})
+		case pci == 0x2:
+			index, ok := pending[frame.Header]
+			if !ok {
+				continue
+			}
+
+			chunk := frame.Data[1:]
+			if len(chunk) > remaining[frame.Header] {
+				chunk = chunk[:remaining[frame.Header]]
+			}
+
+			messages[index].Data = append(messages[index].Data, chunk...)
+			remaining[frame.Header] -= len(chunk)
+
+			if remaining[frame.Header] <= 0 {
+				delete(pending, frame.Header)
+			}
+		}
+	}
+
+	return messages
+}
@@ -0,0 +1,267 @@
+package gobd2
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Snapshot captures everything a vehicle exposes through the standard OBD-II services, so that it can be inspected
+// later or replayed by an emulator.
+type Snapshot struct {
+	CapturedAt time.Time `json:"capturedAt"`
+	// Protocol is the protocol the vehicle communicated with.
+	Protocol Protocol `json:"protocol"`
+	// ECUs lists every ECU that answered, ordered by address.
+	ECUs []ECUSnapshot `json:"ecus"`
+}
+
+// ECUSnapshot is the part of a Snapshot captured from a single ECU.
+type ECUSnapshot struct {
+	// Address is the header the ECU answered with, e.g. "7E8".
+	Address string `json:"address"`
+	// Name is the ECU name reported by mode 09 info type 0A, if supported.
+	Name string `json:"name,omitempty"`
+	// VIN is the vehicle identification number reported by mode 09 info type 02, if supported.
+	VIN string `json:"vin,omitempty"`
+	// DTCs, PendingDTCs and PermanentDTCs are the trouble codes reported by modes 03, 07 and 0A.
+	DTCs          []string `json:"dtcs,omitempty"`
+	PendingDTCs   []string `json:"pendingDtcs,omitempty"`
+	PermanentDTCs []string `json:"permanentDtcs,omitempty"`
+	// Responses holds the ECU's raw answers to every request sent during the capture, keyed by request, e.g.
+	// "010C": ["410C1AF8"]. Legacy protocols may answer a request with several messages.
+	Responses map[string][]string `json:"responses"`
+}
+
+const (
+	modeFreezeFrame byte = 0x02
+	modeTestResults byte = 0x06
+	infoTypeVIN     byte = 0x02
+
+	storedDTCsCommand    CommandCode = "03"
+	pendingDTCsCommand   CommandCode = "07"
+	permanentDTCsCommand CommandCode = "0A"
+)
+
+// CaptureSnapshot queries every PID, freeze frame PID, info type and on-board test result the vehicle's ECUs
+// support, together with their trouble codes, and records all answers.
+//
+// Headers are enabled on the adapter for the duration of the capture and restored to their previous setting
+// afterwards, which is off unless the connector tracks the adapter's settings.
+func (cmd *Commander) CaptureSnapshot() (*Snapshot, error) {
+	recorder := &snapshotRecorder{Connector: cmd.connector, responses: map[string]map[string][]string{}}
+	capture := NewCommander(recorder)
+
+	topology, err := capture.DiscoverTopology()
+	if err != nil {
+		return nil, err
+	}
+
+	restore := restoreSetting(cmd.connector, settingHeaders, "ATH0")
+
+	if _, err := cmd.connector.SendCommand("ATH1"); err != nil {
+		return nil, fmt.Errorf("failed to enable headers: %w", err)
+	}
+	defer cmd.connector.SendCommand(restore) //nolint:errcheck
+
+	if err := capture.captureData(topology); err != nil {
+		return nil, err
+	}
+
+	protocol, err := NewELM327(cmd.connector).Protocol()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query protocol: %w", err)
+	}
+
+	snapshot := &Snapshot{CapturedAt: time.Now().UTC(), Protocol: protocol}
+
+	for _, ecu := range topology.ECUs {
+		responses := recorder.responses[ecu.Address]
+
+		snapshot.ECUs = append(snapshot.ECUs, ECUSnapshot{
+			Address:       ecu.Address,
+			Name:          ecu.Name,
+			VIN:           decodeInfoString(recordedInfo(responses, infoTypeVIN)),
+			DTCs:          recordedDTCs(responses, storedDTCsCommand, protocol.IsCAN()),
+			PendingDTCs:   recordedDTCs(responses, pendingDTCsCommand, protocol.IsCAN()),
+			PermanentDTCs: recordedDTCs(responses, permanentDTCsCommand, protocol.IsCAN()),
+			Responses:     responses,
+		})
+	}
+
+	return snapshot, nil
+}
+
+// captureData requests everything the discovered ECUs support. Requests go to all ECUs at once, so each one is
+// sent only once.
+func (cmd *Commander) captureData(topology *VehicleTopology) error {
+	var requests []CommandCode
+
+	for _, ecu := range topology.ECUs {
+		requests = append(requests, ecu.SupportedPIDs...)
+		requests = append(requests, ecu.SupportedInfoTypes...)
+	}
+
+	freezeFrame, err := cmd.supportedRanges(modeFreezeFrame, []byte{0x00})
+	if err != nil {
+		return fmt.Errorf("failed to query freeze frame PIDs: %w", err)
+	}
+
+	for _, pids := range freezeFrame {
+		for _, pid := range pids {
+			requests = append(requests, CommandCode(fmt.Sprintf("%02X%02X00", modeFreezeFrame, pid)))
+		}
+	}
+
+	tests, err := cmd.supportedCommands(modeTestResults)
+	if err != nil {
+		return fmt.Errorf("failed to query on-board tests: %w", err)
+	}
+
+	for _, mids := range tests {
+		requests = append(requests, commandCodes(modeTestResults, mids)...)
+	}
+
+	requests = append(requests, storedDTCsCommand, pendingDTCsCommand, permanentDTCsCommand)
+
+	slices.Sort(requests)
+
+	for _, request := range slices.Compact(requests) {
+		if _, err := cmd.queryECUs(request); err != nil && !isAdapterError(err) {
+			return fmt.Errorf("failed to query %s: %w", request, err)
+		}
+	}
+
+	return nil
+}
+
+// Save writes the snapshot to a JSON file.
+func (s *Snapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// LoadSnapshot reads a snapshot written by Save.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+	}
+
+	return &snapshot, nil
+}
+
+// snapshotRecorder passes commands to a connector and records the answer of every ECU to each OBD request.
+// Requests must be sent with headers enabled.
+type snapshotRecorder struct {
+	Connector
+	responses map[string]map[string][]string
+}
+
+func (r *snapshotRecorder) SendCommand(command CommandCode) (string, error) {
+	response, err := r.Connector.SendCommand(command)
+	if err != nil {
+		return response, err
+	}
+
+	if _, decodeErr := hex.DecodeString(string(command)); decodeErr != nil {
+		return response, nil // not an OBD request
+	}
+
+	frames, parseErr := parseFrames(response)
+	if parseErr != nil {
+		return response, nil //nolint:nilerr // nothing to record, the caller handles the response
+	}
+
+	answers := map[string][]string{}
+	for _, message := range assembleMessages(frames) {
+		answers[message.Address] = append(answers[message.Address], strings.ToUpper(hex.EncodeToString(message.Data)))
+	}
+
+	for address, data := range answers {
+		if r.responses[address] == nil {
+			r.responses[address] = map[string][]string{}
+		}
+
+		r.responses[address][string(command)] = data
+	}
+
+	return response, nil
+}
+
+// AdapterSetting passes the settings tracked by the connector on, so that the capture restores them.
+func (r *snapshotRecorder) AdapterSetting(name string) (CommandCode, bool) {
+	if tracker, ok := r.Connector.(SettingsTracker); ok {
+		return tracker.AdapterSetting(name)
+	}
+
+	return "", false
+}
+
+// recordedInfo returns a mode 09 record from recorded answers.
+func recordedInfo(responses map[string][]string, infoType byte) []byte {
+	messages := make([]ECUMessage, 0, len(responses))
+
+	for _, answer := range responses[fmt.Sprintf("%02X%02X", modeVehicleInfo, infoType)] {
+		data, err := hex.DecodeString(answer)
+		if err == nil {
+			messages = append(messages, ECUMessage{Data: data})
+		}
+	}
+
+	return infoRecords(messages, infoType)[""]
+}
+
+// recordedDTCs decodes the trouble codes from the recorded answers to a mode 03, 07 or 0A request.
+func recordedDTCs(responses map[string][]string, request CommandCode, can bool) []string {
+	var codes []string
+
+	for _, answer := range responses[string(request)] {
+		data, err := hex.DecodeString(answer)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+
+		codes = append(codes, decodeDTCs(data[1:], can)...)
+	}
+
+	return codes
+}
+
+// decodeDTCs decodes the trouble codes of a mode 03, 07 or 0A answer, without the service ID. On CAN the codes are
+// preceded by their count, on legacy protocols unused slots are padded with zeros.
+func decodeDTCs(data []byte, can bool) []string {
+	if can && len(data) > 0 {
+		data = data[1:]
+	}
+
+	var codes []string
+
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			continue
+		}
+
+		codes = append(codes, formatDTC(data[i], data[i+1]))
+	}
+
+	return codes
+}
+
+// formatDTC formats a two byte trouble code, e.g. 0x03 0x01 as "P0301".
+func formatDTC(high, low byte) string {
+	return fmt.Sprintf("%c%d%X%02X", "PCBU"[high>>6], high>>4&0x03, high&0x0F, low)
+}
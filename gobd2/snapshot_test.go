@@ -0,0 +1,115 @@
+package gobd2_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+// connectVehicle connects a serial connector to an emulated adapter for vehicle.
+func connectVehicle(t *testing.T, vehicle emulator.Vehicle) *gobd2.SerialConnector {
+	t.Helper()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+
+	connector := gobd2.NewSerialConnector("emulator", 38400, &emulator.Opener{Vehicle: vehicle}, gobd2.WithInitProfile(profile))
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	return connector
+}
+
+func TestCommander_CaptureSnapshot(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	now := start
+	vehicle := emulator.NewSimulatedVehicle(emulator.CycleIdle, emulator.WithClock(func() time.Time { return now }),
+		emulator.WithFaults(emulator.MisfireFault(time.Minute, 2)))
+	now = start.Add(2 * time.Minute)
+
+	snapshot, err := gobd2.NewCommander(connectVehicle(t, vehicle)).CaptureSnapshot()
+	require.NoError(t, err)
+
+	require.Equal(t, gobd2.ProtocolCAN11Bit500, snapshot.Protocol)
+	require.Len(t, snapshot.ECUs, 1)
+
+	engine := snapshot.ECUs[0]
+	require.Equal(t, "7E8", engine.Address)
+	require.Equal(t, "ECM-EngineControl", engine.Name)
+	require.Equal(t, "1GOBD2SIMULATOR01", engine.VIN)
+	require.Equal(t, []string{"P0302"}, engine.DTCs)
+	require.Equal(t, []string{"P0302"}, engine.PendingDTCs)
+	require.Equal(t, []string{"P0302"}, engine.PermanentDTCs)
+	require.Equal(t, []string{"410D00"}, engine.Responses["010D"])
+	require.Equal(t, []string{"4202000302"}, engine.Responses["020200"])
+	require.Contains(t, engine.Responses, "020C00")
+	require.Equal(t, []string{"4621803D028C00000400"}, engine.Responses["0621"])
+
+	// Replaying the snapshot answers exactly like the vehicle did.
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	require.NoError(t, snapshot.Save(path))
+
+	replay, err := emulator.LoadSnapshotVehicle(path)
+	require.NoError(t, err)
+
+	replayed, err := gobd2.NewCommander(connectVehicle(t, replay)).CaptureSnapshot()
+	require.NoError(t, err)
+	require.Equal(t, snapshot.Protocol, replayed.Protocol)
+	require.Equal(t, snapshot.ECUs, replayed.ECUs)
+}
+
+func TestCommander_CaptureSnapshot_RestoresHeaders(t *testing.T) {
+	t.Parallel()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+	profile.Headers = gobd2.ToggleOn
+
+	connector := gobd2.NewSerialConnector("emulator", 38400, &emulator.Opener{Vehicle: emulator.NewDemoVehicle()},
+		gobd2.WithInitProfile(profile))
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	_, err := gobd2.NewCommander(connector).CaptureSnapshot()
+	require.NoError(t, err)
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "7E8 03 41 0D 00", response, "headers stay on as the profile set them")
+}
+
+func TestCommander_CaptureSnapshot_Legacy(t *testing.T) {
+	t.Parallel()
+
+	vehicle := emulator.NewStaticVehicle(gobd2.ProtocolISO9141, &emulator.ECU{
+		Address:   0x10,
+		PIDs:      map[byte][]byte{0x0D: {0x32}},
+		InfoTypes: map[byte][]byte{0x02: []byte("WVWZZZ1JZXW000001")},
+		DTCs:      []string{"P0301", "U0100", "C1234", "B0001"},
+	})
+
+	snapshot, err := gobd2.NewCommander(connectVehicle(t, vehicle)).CaptureSnapshot()
+	require.NoError(t, err)
+
+	require.Equal(t, gobd2.ProtocolISO9141, snapshot.Protocol)
+	require.Len(t, snapshot.ECUs, 1)
+	require.Equal(t, "486B10", snapshot.ECUs[0].Address)
+	require.Equal(t, "WVWZZZ1JZXW000001", snapshot.ECUs[0].VIN)
+	require.Equal(t, []string{"P0301", "U0100", "C1234", "B0001"}, snapshot.ECUs[0].DTCs)
+	require.Len(t, snapshot.ECUs[0].Responses["0902"], 5)
+
+	replay, err := emulator.NewSnapshotVehicle(snapshot)
+	require.NoError(t, err)
+
+	replayed, err := gobd2.NewCommander(connectVehicle(t, replay)).CaptureSnapshot()
+	require.NoError(t, err)
+	require.Equal(t, snapshot.ECUs, replayed.ECUs)
+}
@@ -0,0 +1,118 @@
+package gobd2
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrStreamingUnsupported is returned when monitoring is requested over a connector that cannot stream.
+var ErrStreamingUnsupported = errors.New("connector does not support streaming")
+
+// maxELMRequestBytes is the longest request a plain ELM327 can send in a single CAN frame.
+const maxELMRequestBytes = 7
+
+// STN provides typed access to the extended command set of OBDLink STN11xx/STN2xxx adapters.
+type STN struct {
+	connector Connector
+}
+
+// NewSTN creates a control API for the STN adapter behind connector.
+func NewSTN(connector Connector) *STN {
+	return &STN{connector}
+}
+
+// Identify returns the firmware identification reported by STI, e.g. "STN2255 v5.6.19".
+func (s *STN) Identify() (string, error) {
+	return NewELM327(s.connector).query("STI")
+}
+
+// DeviceID returns the device hardware identification reported by STDI, e.g. "OBDLink MX+ r1.2".
+func (s *STN) DeviceID() (string, error) {
+	return NewELM327(s.connector).query("STDI")
+}
+
+// AddPassFilter adds a CAN pass filter (STFAP). Only frames whose identifier matches pattern in the bits set in
+// mask are received, e.g. pattern "7E8" and mask "7F8" pass the responses of all OBD ECUs.
+func (s *STN) AddPassFilter(pattern, mask string) error {
+	return NewELM327(s.connector).setting(CommandCode(fmt.Sprintf("STFAP%s,%s", pattern, mask)))
+}
+
+// ClearPassFilters removes all CAN pass filters (STFCP).
+func (s *STN) ClearPassFilters() error {
+	return NewELM327(s.connector).setting("STFCP")
+}
+
+// SetCANSegmentation enables or disables ISO-TP segmentation of transmitted CAN messages (STCSEGT), which is
+// needed to send requests longer than a single frame.
+func (s *STN) SetCANSegmentation(enabled bool) error {
+	if enabled {
+		return NewELM327(s.connector).setting("STCSEGT1")
+	}
+
+	return NewELM327(s.connector).setting("STCSEGT0")
+}
+
+// Transmit sends data of arbitrary length using STPX. An empty header uses the header currently set on the
+// adapter; responses limits the number of responses to wait for, zero waits until the adapter times out.
+func (s *STN) Transmit(header string, data []byte, responses int) (string, error) {
+	return s.connector.SendCommand(stpxCommand(header, data, responses))
+}
+
+// MonitorAll reports every frame seen on the bus (STMA) until ctx is canceled. The connector must implement
+// StreamingConnector, and headers should be enabled for the frames to carry their identifiers.
+func (s *STN) MonitorAll(ctx context.Context, handle func(Frame)) error {
+	return monitor(ctx, s.connector, "STMA", handle)
+}
+
+// stpxCommand builds an STPX command transmitting data.
+func stpxCommand(header string, data []byte, responses int) CommandCode {
+	fields := make([]string, 0, 3)
+	if header != "" {
+		fields = append(fields, "H:"+header)
+	}
+
+	fields = append(fields, "D:"+strings.ToUpper(hex.EncodeToString(data)))
+
+	if responses > 0 {
+		fields = append(fields, fmt.Sprintf("R:%d", responses))
+	}
+
+	return CommandCode("STPX" + strings.Join(fields, ","))
+}
+
+// monitor runs a monitoring command over a streaming connector and reports each received frame.
+func monitor(ctx context.Context, connector Connector, command CommandCode, handle func(Frame)) error {
+	streamer, ok := connector.(StreamingConnector)
+	if !ok {
+		return ErrStreamingUnsupported
+	}
+
+	var parseErr error
+
+	err := streamer.Stream(ctx, command, func(line string) {
+		if parseErr != nil {
+			return
+		}
+
+		if err := responseError(line); err != nil {
+			parseErr = err
+
+			return
+		}
+
+		frame, err := parseFrame(strings.ReplaceAll(line, " ", ""))
+		if err != nil {
+			return // partial lines are expected when monitoring is interrupted
+		}
+
+		handle(frame)
+	})
+	if err != nil {
+		return err
+	}
+
+	return parseErr
+}
@@ -0,0 +1,111 @@
+package gobd2_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSTN_Commands(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	stn := gobd2.NewSTN(mockConnector)
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("STI")).Return("STN1155 v4.2.0", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STFAP7E8,7F8")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STFCP")).Return("OK", nil)
+	mockConnector.On("SendCommand", gobd2.CommandCode("STPXH:7E0,D:22F190,R:1")).Return("62 F1 90 57", nil)
+
+	id, err := stn.Identify()
+	require.NoError(t, err)
+	require.Equal(t, "STN1155 v4.2.0", id)
+
+	require.NoError(t, stn.AddPassFilter("7E8", "7F8"))
+	require.NoError(t, stn.ClearPassFilters())
+
+	response, err := stn.Transmit("7E0", []byte{0x22, 0xF1, 0x90}, 1)
+	require.NoError(t, err)
+	require.Equal(t, "62 F1 90 57", response)
+
+	mockConnector.AssertExpectations(t)
+}
+
+func TestSerialConnector_STNExtensions(t *testing.T) {
+	t.Parallel()
+
+	port := newScriptedSerialPort(map[string]string{
+		"STI":      "STN2255 v5.6.19",
+		"STCSEGT1": "OK",
+		"STPXD:2EF1900102030405060708090A0B0C0D0E0F1011": "6E F1 90",
+		"010C": "41 0C 1A F8",
+	})
+	connector := connectScripted(t, port, gobd2.WithSTNExtensions())
+
+	require.True(t, connector.Capabilities().Has(gobd2.CapabilitySTN))
+
+	response, err := connector.SendCommand("2EF1900102030405060708090A0B0C0D0E0F1011")
+	require.NoError(t, err)
+	require.Equal(t, "6E F1 90", response)
+
+	// Requests a plain ELM327 can send are passed through unchanged.
+	response, err = connector.SendCommand(gobd2.EngineRPMCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0C 1A F8", response)
+}
+
+func TestSerialConnector_STNExtensionsAfterReset(t *testing.T) {
+	t.Parallel()
+
+	port := newScriptedSerialPort(map[string]string{
+		"STI":      "STN2255 v5.6.19",
+		"STCSEGT1": "OK",
+		"0100":     "LV RESET\r\rELM327 v1.5",
+	})
+	connector := connectScripted(t, port, gobd2.WithSTNExtensions())
+	connected := len(port.commands)
+
+	_, err := connector.SendCommand(gobd2.SupportedPIDsCommand1_20)
+	require.ErrorIs(t, err, gobd2.ErrAdapterReset)
+	require.True(t, connector.Capabilities().Has(gobd2.CapabilitySTN))
+	require.Equal(t, []string{"0100", "ATZ", "ATE0", "ATL0", "ATSP0", "STI", "STCSEGT1"}, port.commands[connected:])
+}
+
+func TestSerialConnector_STNExtensionsOnELM327(t *testing.T) {
+	t.Parallel()
+
+	port := newScriptedSerialPort(nil)
+	connector := connectScripted(t, port, gobd2.WithSTNExtensions())
+
+	require.False(t, connector.Capabilities().Has(gobd2.CapabilitySTN))
+	require.Equal(t, []string{"ATZ", "ATE0", "ATL0", "ATSP0", "STI"}, port.commands)
+}
+
+func TestSTN_MonitorAll(t *testing.T) {
+	t.Parallel()
+
+	port := newScriptedSerialPort(map[string]string{
+		"STMA": "7E8 03 41 0D 20\r7E9 03 41 0D 20\rBUFFER FULL",
+	})
+	connector := connectScripted(t, port)
+
+	var frames []gobd2.Frame
+
+	err := gobd2.NewSTN(connector).MonitorAll(context.Background(), func(frame gobd2.Frame) {
+		frames = append(frames, frame)
+	})
+	require.ErrorIs(t, err, gobd2.ErrBufferFull)
+	require.Equal(t, []gobd2.Frame{
+		{Header: "7E8", Data: []byte{0x03, 0x41, 0x0D, 0x20}},
+		{Header: "7E9", Data: []byte{0x03, 0x41, 0x0D, 0x20}},
+	}, frames)
+}
+
+func TestSTN_MonitorAllRequiresStreaming(t *testing.T) {
+	t.Parallel()
+
+	err := gobd2.NewSTN(new(MockConnector)).MonitorAll(context.Background(), func(gobd2.Frame) {})
+	require.ErrorIs(t, err, gobd2.ErrStreamingUnsupported)
+}
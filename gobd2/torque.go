@@ -0,0 +1,142 @@
+package gobd2
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Columns of a Torque custom PID list.
+const (
+	torqueName = iota
+	torqueShortName
+	torqueModeAndPID
+	torqueEquation
+	torqueMin
+	torqueMax
+	torqueUnit
+	torqueHeader
+)
+
+// ReadTorqueCSV reads a custom PID list in the CSV format of Torque Pro and returns its PIDs. Each line holds the
+// name, short name, mode and PID, equation, minimum, maximum, unit and, optionally, the request header of a PID,
+// e.g.
+//
+//	Name,ShortName,ModeAndPID,Equation,Min Value,Max Value,Units,Header
+//	Transmission Temperature,TransT,221674,A-40,-40,150,°C,7E1
+//
+// The equations use the bytes after the echoed mode and PID as the variables A, B, C and so on. The line naming
+// the columns is optional and lines starting with # are ignored.
+func ReadTorqueCSV(r io.Reader) ([]PID, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var pids []PID
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return pids, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Torque PIDs: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		if len(pids) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "name") {
+			continue // column names
+		}
+
+		pid, err := torquePID(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		pids = append(pids, pid)
+	}
+}
+
+// torquePID converts a line of a Torque PID list.
+func torquePID(record []string) (PID, error) {
+	if len(record) <= torqueUnit {
+		return PID{}, fmt.Errorf("%d columns instead of at least %d: %w", len(record), torqueUnit+1, ErrInvalidPID)
+	}
+
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+
+	pid := PID{
+		Name:       record[torqueName],
+		ShortName:  record[torqueShortName],
+		Command:    CommandCode(strings.ToUpper(trimHexPrefix(record[torqueModeAndPID]))),
+		Expression: record[torqueEquation],
+		Unit:       record[torqueUnit],
+	}
+
+	if len(record) > torqueHeader {
+		pid.Header = strings.ToUpper(trimHexPrefix(record[torqueHeader]))
+	}
+
+	var err error
+
+	if pid.Min, err = parseTorqueLimit(record[torqueMin]); err != nil {
+		return PID{}, fmt.Errorf("%q: minimum: %w", pid.Name, err)
+	}
+
+	if pid.Max, err = parseTorqueLimit(record[torqueMax]); err != nil {
+		return PID{}, fmt.Errorf("%q: maximum: %w", pid.Name, err)
+	}
+
+	if err := pid.compile(); err != nil {
+		return PID{}, err
+	}
+
+	return pid, nil
+}
+
+// parseTorqueLimit parses a minimum or maximum, which may be empty.
+func parseTorqueLimit(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed number %q: %w", value, ErrInvalidPID)
+	}
+
+	return limit, nil
+}
+
+func trimHexPrefix(value string) string {
+	if len(value) > 2 && value[0] == '0' && (value[1] == 'x' || value[1] == 'X') {
+		return value[2:]
+	}
+
+	return value
+}
+
+// LoadTorqueCSV reads the Torque Pro custom PID list at path and returns its PIDs.
+func LoadTorqueCSV(path string) ([]PID, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pids, err := ReadTorqueCSV(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return pids, nil
+}
@@ -0,0 +1,61 @@
+package gobd2_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+const torquePIDs = `Name,ShortName,ModeAndPID,Equation,Min Value,Max Value,Units,Header
+# Engine
+"Engine Speed, fast",RPM,0x010C,((A*256)+B)/4,0,8000,rpm,
+Transmission Temperature,TransT,221674,A-40,-40,150,°C,7E1
+Boost,Boost,010B,A-101,,,kPa
+`
+
+func TestReadTorqueCSV(t *testing.T) {
+	t.Parallel()
+
+	pids, err := gobd2.ReadTorqueCSV(strings.NewReader(torquePIDs))
+	require.NoError(t, err)
+	require.Len(t, pids, 3)
+
+	require.Equal(t, "Engine Speed, fast", pids[0].Name)
+	require.Equal(t, "RPM", pids[0].ShortName)
+	require.Equal(t, gobd2.EngineRPMCommand, pids[0].Command)
+	require.Empty(t, pids[0].Header)
+	require.InDelta(t, 8000, pids[0].Max, 1e-9)
+
+	require.Equal(t, gobd2.CommandCode("221674"), pids[1].Command)
+	require.Equal(t, "7E1", pids[1].Header)
+	require.InDelta(t, -40, pids[1].Min, 1e-9)
+	require.Equal(t, "°C", pids[1].Unit)
+
+	require.Empty(t, pids[2].Header, "the header column is optional")
+
+	transmission := &emulator.ECU{Address: 0x7E9, DataIdentifiers: map[uint16][]byte{0x1674: {0x8C}}}
+	commander := gobd2.NewCommander(connectVehicle(t, emulator.NewStaticVehicle(gobd2.ProtocolCAN11Bit500, transmission)))
+
+	reading, err := commander.ReadPID(pids[1])
+	require.NoError(t, err)
+	require.Equal(t, "100 °C", reading.String())
+}
+
+func TestReadTorqueCSV_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"Oil,Oil,221310,A-40\n":              `line 1: 4 columns instead of at least 7: invalid PID`,
+		"Oil,Oil,221310,A-40,low,150,C\n":    `line 1: "Oil": minimum: malformed number "low": invalid PID`,
+		"Name\nOil,Oil,22131,A-40,0,150,C\n": `line 2: PID "Oil": malformed command "22131": invalid PID`,
+		"Oil,Oil,221310,A-40)*2,0,150,C\n":   `line 1: PID "Oil": "A-40)*2" at 5: unexpected ")": invalid expression`,
+	}
+
+	for input, message := range tests {
+		_, err := gobd2.ReadTorqueCSV(strings.NewReader(input))
+		require.EqualError(t, err, message, input)
+	}
+}
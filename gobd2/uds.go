@@ -0,0 +1,436 @@
+package gobd2
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UDS (ISO 14229) service identifiers.
+const (
+	ServiceDiagnosticSessionControl   byte = 0x10
+	ServiceECUReset                   byte = 0x11
+	ServiceClearDiagnosticInformation byte = 0x14
+	ServiceReadDTCInformation         byte = 0x19
+	ServiceReadDataByIdentifier       byte = 0x22
+	ServiceSecurityAccess             byte = 0x27
+	ServiceWriteDataByIdentifier      byte = 0x2E
+	ServiceInputOutputControl         byte = 0x2F
+	ServiceRoutineControl             byte = 0x31
+	ServiceTesterPresent              byte = 0x3E
+)
+
+// writeServices are the services changing the state of the ECU or of its actuators, which a client only sends when
+// created WithWritesAllowed.
+var writeServices = map[byte]bool{
+	ServiceECUReset:                   true,
+	ServiceClearDiagnosticInformation: true,
+	ServiceWriteDataByIdentifier:      true,
+	ServiceInputOutputControl:         true,
+	ServiceRoutineControl:             true,
+}
+
+// positiveResponseOffset is added to a service identifier to form the identifier of its positive response.
+const positiveResponseOffset = 0x40
+
+// negativeResponse is the identifier of a negative response, followed by the rejected service and the code.
+const negativeResponse = 0x7F
+
+// Errors returned by a UDSClient.
+var (
+	ErrUnsupportedAddressing = errors.New("UDS requires an ECU on a CAN bus")
+	ErrResponsePending       = errors.New("ECU is still processing the request")
+	ErrWritesNotAllowed      = errors.New("UDS client does not allow writes")
+	ErrRequestTooLong        = errors.New("request exceeds a single CAN frame, which needs STN extensions")
+)
+
+// NegativeResponseCode is the reason an ECU gives for rejecting a UDS request.
+type NegativeResponseCode byte
+
+// Negative response codes defined by ISO 14229-1.
+const (
+	NRCGeneralReject                          NegativeResponseCode = 0x10
+	NRCServiceNotSupported                    NegativeResponseCode = 0x11
+	NRCSubFunctionNotSupported                NegativeResponseCode = 0x12
+	NRCIncorrectMessageLength                 NegativeResponseCode = 0x13
+	NRCResponseTooLong                        NegativeResponseCode = 0x14
+	NRCBusyRepeatRequest                      NegativeResponseCode = 0x21
+	NRCConditionsNotCorrect                   NegativeResponseCode = 0x22
+	NRCRequestSequenceError                   NegativeResponseCode = 0x24
+	NRCNoResponseFromSubnetComponent          NegativeResponseCode = 0x25
+	NRCFailurePreventsExecution               NegativeResponseCode = 0x26
+	NRCRequestOutOfRange                      NegativeResponseCode = 0x31
+	NRCSecurityAccessDenied                   NegativeResponseCode = 0x33
+	NRCInvalidKey                             NegativeResponseCode = 0x35
+	NRCExceededNumberOfAttempts               NegativeResponseCode = 0x36
+	NRCRequiredTimeDelayNotExpired            NegativeResponseCode = 0x37
+	NRCUploadDownloadNotAccepted              NegativeResponseCode = 0x70
+	NRCTransferDataSuspended                  NegativeResponseCode = 0x71
+	NRCGeneralProgrammingFailure              NegativeResponseCode = 0x72
+	NRCWrongBlockSequenceCounter              NegativeResponseCode = 0x73
+	NRCResponsePending                        NegativeResponseCode = 0x78
+	NRCSubFunctionNotSupportedInActiveSession NegativeResponseCode = 0x7E
+	NRCServiceNotSupportedInActiveSession     NegativeResponseCode = 0x7F
+	NRCVoltageTooHigh                         NegativeResponseCode = 0x92
+	NRCVoltageTooLow                          NegativeResponseCode = 0x93
+)
+
+var negativeResponseNames = map[NegativeResponseCode]string{
+	NRCGeneralReject:                          "generalReject",
+	NRCServiceNotSupported:                    "serviceNotSupported",
+	NRCSubFunctionNotSupported:                "subFunctionNotSupported",
+	NRCIncorrectMessageLength:                 "incorrectMessageLengthOrInvalidFormat",
+	NRCResponseTooLong:                        "responseTooLong",
+	NRCBusyRepeatRequest:                      "busyRepeatRequest",
+	NRCConditionsNotCorrect:                   "conditionsNotCorrect",
+	NRCRequestSequenceError:                   "requestSequenceError",
+	NRCNoResponseFromSubnetComponent:          "noResponseFromSubnetComponent",
+	NRCFailurePreventsExecution:               "failurePreventsExecutionOfRequestedAction",
+	NRCRequestOutOfRange:                      "requestOutOfRange",
+	NRCSecurityAccessDenied:                   "securityAccessDenied",
+	NRCInvalidKey:                             "invalidKey",
+	NRCExceededNumberOfAttempts:               "exceededNumberOfAttempts",
+	NRCRequiredTimeDelayNotExpired:            "requiredTimeDelayNotExpired",
+	NRCUploadDownloadNotAccepted:              "uploadDownloadNotAccepted",
+	NRCTransferDataSuspended:                  "transferDataSuspended",
+	NRCGeneralProgrammingFailure:              "generalProgrammingFailure",
+	NRCWrongBlockSequenceCounter:              "wrongBlockSequenceCounter",
+	NRCResponsePending:                        "requestCorrectlyReceivedResponsePending",
+	NRCSubFunctionNotSupportedInActiveSession: "subFunctionNotSupportedInActiveSession",
+	NRCServiceNotSupportedInActiveSession:     "serviceNotSupportedInActiveSession",
+	NRCVoltageTooHigh:                         "voltageTooHigh",
+	NRCVoltageTooLow:                          "voltageTooLow",
+}
+
+// String returns the ISO 14229 name of the code, e.g. "requestOutOfRange".
+func (c NegativeResponseCode) String() string {
+	if name, ok := negativeResponseNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("NRC 0x%02X", byte(c))
+}
+
+// NegativeResponseError is returned when an ECU rejects a UDS request with a negative response.
+type NegativeResponseError struct {
+	// Service is the identifier of the rejected service.
+	Service byte
+	// Code is the reason the ECU gave.
+	Code NegativeResponseCode
+}
+
+func (e *NegativeResponseError) Error() string {
+	return fmt.Sprintf("service 0x%02X rejected: %s (0x%02X)", e.Service, e.Code, byte(e.Code))
+}
+
+// UDSOption configures optional behavior of a UDSClient.
+type UDSOption func(*UDSClient)
+
+// WithRequestHeader sends requests with header instead of the physical address derived from the ECU's response
+// address, for ECUs that do not follow the OBD addressing scheme.
+func WithRequestHeader(header string) UDSOption {
+	return func(c *UDSClient) {
+		c.requestHeader = strings.ToUpper(header)
+	}
+}
+
+// WithWritesAllowed lets the client send the requests changing the state of the ECU: ECUReset,
+// ClearDiagnosticInformation, WriteDataByIdentifier, RoutineControl and InputOutputControlByIdentifier. Without it
+// they fail with ErrWritesNotAllowed, so that read-only tools cannot clear trouble codes, run routines or move
+// actuators by accident.
+func WithWritesAllowed() UDSOption {
+	return func(c *UDSClient) {
+		c.allowWrites = true
+	}
+}
+
+// UDSClient sends UDS (ISO 14229) requests to a single ECU through an ELM327 connector. The adapter reassembles
+// segmented (ISO-TP) responses, but a plain ELM327 sends requests in a single CAN frame only; longer requests need
+// an STN adapter and WithSTNExtensions. The client configures the adapter to address the ECU physically, to receive
+// only its answers and to wait for them as long as the session's P2* allows.
+//
+// The adapter is configured on the first request and its previous settings are restored by Close. Requests are
+// sent one at a time.
+type UDSClient struct {
+	connector      Connector
+	requestHeader  string
+	responseHeader string
+	allowWrites    bool
+	verifyWrites   bool
+	mu             sync.Mutex
+	open           bool
+	// restore holds the commands putting back the adapter settings changed by configure.
+	restore []CommandCode
+
+	// session is the active diagnostic session, changed by DiagnosticSessionControl, and timing the timing the ECU
+	// reported for it.
+	session               DiagnosticSession
+	timing                SessionTiming
+	testerPresentInterval time.Duration
+	keepalive             *keepalive
+
+	// security holds the state of each security level, reset when the session changes.
+	security         map[SecurityLevel]SecurityState
+	securityAttempts int
+	securityDelay    time.Duration
+}
+
+// NewUDSClient creates a client for the ECU answering with ecuAddress, e.g. "7E8" on 11-bit CAN or "18DAF110"
+// on 29-bit CAN, as reported by DiscoverTopology.
+func NewUDSClient(connector Connector, ecuAddress string, opts ...UDSOption) (*UDSClient, error) {
+	client := &UDSClient{
+		connector:             connector,
+		responseHeader:        strings.ToUpper(ecuAddress),
+		session:               DefaultSession,
+		timing:                defaultSessionTiming,
+		testerPresentInterval: defaultTesterPresentInterval,
+		security:              map[SecurityLevel]SecurityState{},
+		securityAttempts:      defaultSecurityAttempts,
+		securityDelay:         defaultSecurityDelay,
+	}
+
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	if client.requestHeader == "" {
+		header, err := physicalRequestHeader(client.responseHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		client.requestHeader = header
+	}
+
+	return client, nil
+}
+
+// physicalRequestHeader returns the header addressing the ECU that answers with responseHeader: 7E8 is reached
+// through 7E0, 18DAF110 through 18DA10F1.
+func physicalRequestHeader(responseHeader string) (string, error) {
+	switch {
+	case len(responseHeader) == 3:
+		address, err := strconv.ParseUint(responseHeader, 16, 12)
+		if err != nil || address < 8 {
+			return "", fmt.Errorf("ECU %s: %w", responseHeader, ErrUnsupportedAddressing)
+		}
+
+		return fmt.Sprintf("%03X", address-8), nil
+	case len(responseHeader) == 8 && strings.HasPrefix(responseHeader, "18DAF1"):
+		return "18DA" + responseHeader[6:] + "F1", nil
+	}
+
+	return "", fmt.Errorf("ECU %s: %w", responseHeader, ErrUnsupportedAddressing)
+}
+
+// Request sends a UDS service with its parameters and returns the parameters of the positive response, without
+// the response service identifier. Services changing the state of the ECU require WithWritesAllowed. Requests
+// longer than 7 bytes fail with ErrRequestTooLong unless the connector uses the STN extensions. Negative
+// responses are returned as a *NegativeResponseError; "response pending" answers are skipped while the adapter keeps
+// waiting for the final one, for up to the session's P2* or the 1020 ms an ELM327 can wait at most.
+func (c *UDSClient) Request(service byte, parameters ...byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.request(service, parameters...)
+}
+
+// request sends a request with the client locked.
+func (c *UDSClient) request(service byte, parameters ...byte) ([]byte, error) {
+	if writeServices[service] && !c.allowWrites {
+		return nil, fmt.Errorf("service 0x%02X: %w", service, ErrWritesNotAllowed)
+	}
+
+	if len(parameters)+1 > maxELMRequestBytes && !sendsLongRequests(c.connector) {
+		return nil, fmt.Errorf("service 0x%02X with %d bytes: %w", service, len(parameters)+1, ErrRequestTooLong)
+	}
+
+	if !c.open {
+		if err := c.configure(); err != nil {
+			return nil, err
+		}
+
+		c.open = true
+	}
+
+	request := CommandCode(strings.ToUpper(hex.EncodeToString(append([]byte{service}, parameters...))))
+
+	response, err := c.connector.SendCommand(request)
+	if err != nil {
+		return nil, fmt.Errorf("service 0x%02X: %w", service, err)
+	}
+
+	data, err := c.answer(response)
+	if err != nil {
+		return nil, fmt.Errorf("service 0x%02X: %w", service, err)
+	}
+
+	switch {
+	case data[0] == negativeResponse && len(data) >= 3:
+		return nil, &NegativeResponseError{Service: data[1], Code: NegativeResponseCode(data[2])}
+	case data[0] != service+positiveResponseOffset:
+		return nil, fmt.Errorf("service 0x%02X answered with 0x%02X: %w", service, data[0], ErrUnexpectedResponse)
+	}
+
+	return data[1:], nil
+}
+
+// sendsLongRequests reports whether the adapter behind connector sends requests longer than a single CAN frame,
+// which a plain ELM327 cannot do: the connector must have enabled the STN extensions.
+func sendsLongRequests(connector Connector) bool {
+	provider, ok := connector.(CapabilityProvider)
+
+	return ok && provider.Capabilities().Has(CapabilitySTN)
+}
+
+// answer picks the ECU's final answer from a response, skipping "response pending" messages.
+func (c *UDSClient) answer(response string) ([]byte, error) {
+	frames, err := parseFrames(response)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := false
+
+	for _, message := range assembleMessages(frames) {
+		switch {
+		case message.Address != c.responseHeader || len(message.Data) == 0:
+		case len(message.Data) >= 3 && message.Data[0] == negativeResponse && message.Data[2] == byte(NRCResponsePending):
+			pending = true
+		default:
+			return message.Data, nil
+		}
+	}
+
+	if pending {
+		return nil, ErrResponsePending
+	}
+
+	return nil, ErrNoData
+}
+
+// configure makes the adapter show headers, address the ECU, wait for its answers as long as P2* and listen to its
+// answers only. The adapter gives up on a request once no frame arrives within its timeout, so a timeout shorter
+// than P2* would drop the final answer following a "response pending" one. Adapters known to ignore receive filters
+// are not given one; answers from other ECUs are skipped by their header either way.
+func (c *UDSClient) configure() error {
+	commands := []CommandCode{"ATH1"}
+	commands = append(commands, headerCommands(c.requestHeader)...)
+	commands = append(commands, responseTimeoutCommand(c.timing.P2Extended))
+
+	caps, known := adapterCapabilities(c.connector)
+	filtered := !known || caps.Has(CapabilityCANReceiveFilter)
+
+	if filtered {
+		commands = append(commands, CommandCode("ATCRA"+c.responseHeader))
+	}
+
+	c.restore = c.restoreCommands(filtered)
+
+	return sendSettings(c.connector, commands)
+}
+
+// restoreCommands returns the commands putting back the settings configure changes: their previous values if the
+// connector tracks them, or else the adapter's defaults with functional OBD addressing.
+func (c *UDSClient) restoreCommands(filtered bool) []CommandCode {
+	commands := []CommandCode{restoreSetting(c.connector, settingTimeout, defaultResponseTimeout)}
+
+	if filtered {
+		commands = append(commands, restoreSetting(c.connector, settingReceiveFilter, "ATCRA"))
+	}
+
+	commands = append(commands, restoreHeaderCommands(c.connector, c.requestHeader)...)
+
+	return append(commands, restoreSetting(c.connector, settingHeaders, "ATH0"))
+}
+
+// Close stops the TesterPresent keepalive, returns the ECU to the default session and restores the adapter's
+// headers, request header, receive filter and timeout. Connectors that do not track the adapter's settings are returned to
+// functional OBD addressing, without headers or a receive filter.
+func (c *UDSClient) Close() error {
+	c.stopKeepalive()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.open {
+		return nil
+	}
+
+	var sessionErr error
+	if c.session != DefaultSession {
+		_, sessionErr = c.diagnosticSessionControl(DefaultSession)
+	}
+
+	c.open = false
+
+	return errors.Join(sessionErr, sendSettings(c.connector, c.restore))
+}
+
+// headerCommands returns the commands setting the request header. 29-bit headers are set as their priority byte
+// (ATCP) followed by the remaining three bytes, which every ELM327 version accepts.
+func headerCommands(header string) []CommandCode {
+	if len(header) == 8 {
+		return []CommandCode{CommandCode("ATCP" + header[:2]), CommandCode("ATSH" + header[2:])}
+	}
+
+	return []CommandCode{CommandCode("ATSH" + header)}
+}
+
+// restoreHeaderCommands returns the commands putting back the request header after header was set: its previous
+// value if the connector tracks it, or else functional addressing on the bus header belongs to.
+func restoreHeaderCommands(connector Connector, header string) []CommandCode {
+	var commands []CommandCode
+
+	functional := headerCommands(functionalHeader(header))
+	if len(functional) == 2 {
+		commands = append(commands, restoreSetting(connector, settingPriority, functional[0]))
+	}
+
+	return append(commands, restoreSetting(connector, settingHeader, functional[len(functional)-1]))
+}
+
+// sendSettings sends AT commands that the adapter acknowledges with "OK".
+func sendSettings(connector Connector, commands []CommandCode) error {
+	elm := NewELM327(connector)
+
+	for _, command := range commands {
+		if err := elm.setting(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadDataByIdentifier reads the data record identified by did (service 0x22).
+func (c *UDSClient) ReadDataByIdentifier(did uint16) ([]byte, error) {
+	data, err := c.Request(ServiceReadDataByIdentifier, byte(did>>8), byte(did))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 2 || uint16(data[0])<<8|uint16(data[1]) != did {
+		return nil, fmt.Errorf("DID %04X answered % X: %w", did, data, ErrUnexpectedResponse)
+	}
+
+	return data[2:], nil
+}
+
+// ClearDiagnosticInformation clears the trouble codes of a group (service 0x14), 0xFFFFFF for all groups.
+func (c *UDSClient) ClearDiagnosticInformation(group uint32) error {
+	_, err := c.Request(ServiceClearDiagnosticInformation, byte(group>>16), byte(group>>8), byte(group))
+
+	return err
+}
+
+// TesterPresent tells the ECU a tester is still connected (service 0x3E), keeping a non-default session active.
+func (c *UDSClient) TesterPresent() error {
+	_, err := c.Request(ServiceTesterPresent, 0x00)
+
+	return err
+}
@@ -0,0 +1,122 @@
+package gobd2
+
+import (
+	"fmt"
+)
+
+// RoutineControlType is the sub-function of a RoutineControl request (service 0x31).
+type RoutineControlType byte
+
+// Routine control types defined by ISO 14229-1.
+const (
+	StartRoutine          RoutineControlType = 0x01
+	StopRoutine           RoutineControlType = 0x02
+	RequestRoutineResults RoutineControlType = 0x03
+)
+
+var routineControlNames = map[RoutineControlType]string{
+	StartRoutine:          "start",
+	StopRoutine:           "stop",
+	RequestRoutineResults: "results",
+}
+
+// String returns the name of the control type, e.g. "start".
+func (t RoutineControlType) String() string {
+	if name, ok := routineControlNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("routine control 0x%02X", byte(t))
+}
+
+// IOControlParameter tells an ECU what to do with an input or output in an InputOutputControlByIdentifier request
+// (service 0x2F).
+type IOControlParameter byte
+
+// Input output control parameters defined by ISO 14229-1.
+const (
+	ReturnControlToECU  IOControlParameter = 0x00
+	ResetToDefault      IOControlParameter = 0x01
+	FreezeCurrentState  IOControlParameter = 0x02
+	ShortTermAdjustment IOControlParameter = 0x03
+)
+
+var ioControlNames = map[IOControlParameter]string{
+	ReturnControlToECU:  "returnControlToECU",
+	ResetToDefault:      "resetToDefault",
+	FreezeCurrentState:  "freezeCurrentState",
+	ShortTermAdjustment: "shortTermAdjustment",
+}
+
+// String returns the ISO 14229 name of the parameter, e.g. "shortTermAdjustment".
+func (p IOControlParameter) String() string {
+	if name, ok := ioControlNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("IO control 0x%02X", byte(p))
+}
+
+// RoutineControl starts or stops the routine identified by routine, or requests its results (service 0x31), and
+// returns the routine status record the ECU answers with. Its content is specific to the routine; ECUs following
+// older versions of ISO 14229 start it with a routine info byte. The client must be created WithWritesAllowed.
+func (c *UDSClient) RoutineControl(control RoutineControlType, routine uint16, options ...byte) ([]byte, error) {
+	data, err := c.Request(ServiceRoutineControl, append([]byte{byte(control), byte(routine >> 8), byte(routine)}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 3 || data[0] != byte(control) || uint16(data[1])<<8|uint16(data[2]) != routine {
+		return nil, fmt.Errorf("routine %04X %s answered % X: %w", routine, control, data, ErrUnexpectedResponse)
+	}
+
+	return data[3:], nil
+}
+
+// StartRoutine starts a routine with its option record and returns its status record.
+func (c *UDSClient) StartRoutine(routine uint16, options ...byte) ([]byte, error) {
+	return c.RoutineControl(StartRoutine, routine, options...)
+}
+
+// StopRoutine stops a running routine with its option record and returns its status record.
+func (c *UDSClient) StopRoutine(routine uint16, options ...byte) ([]byte, error) {
+	return c.RoutineControl(StopRoutine, routine, options...)
+}
+
+// RoutineResults returns the results of a routine that was started.
+func (c *UDSClient) RoutineResults(routine uint16) ([]byte, error) {
+	return c.RoutineControl(RequestRoutineResults, routine)
+}
+
+// InputOutputControl takes control of the input or output identified by did (service 0x2F) and returns the state
+// the ECU reports for it. The control state is only sent with ShortTermAdjustment, followed by the optional control
+// enable mask selecting the parts of the state to adjust. The client must be created WithWritesAllowed.
+//
+// ECUs keep the control until ReturnControlToECU is sent or the diagnostic session ends.
+func (c *UDSClient) InputOutputControl(did uint16, parameter IOControlParameter, state, mask []byte) ([]byte, error) {
+	request := []byte{byte(did >> 8), byte(did), byte(parameter)}
+	request = append(append(request, state...), mask...)
+
+	data, err := c.Request(ServiceInputOutputControl, request...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 3 || uint16(data[0])<<8|uint16(data[1]) != did || data[2] != byte(parameter) {
+		return nil, fmt.Errorf("IO control %04X %s answered % X: %w", did, parameter, data, ErrUnexpectedResponse)
+	}
+
+	return data[3:], nil
+}
+
+// AdjustOutput sets the input or output identified by did to state until control is returned to the ECU, e.g.
+// to drive an actuator during a test, and returns the state the ECU reports.
+func (c *UDSClient) AdjustOutput(did uint16, state []byte) ([]byte, error) {
+	return c.InputOutputControl(did, ShortTermAdjustment, state, nil)
+}
+
+// ReturnControl gives the control of the input or output identified by did back to the ECU and returns the state
+// the ECU reports.
+func (c *UDSClient) ReturnControl(did uint16) ([]byte, error) {
+	return c.InputOutputControl(did, ReturnControlToECU, nil, nil)
+}
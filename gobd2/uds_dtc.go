@@ -0,0 +1,262 @@
+package gobd2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReadDTCInformation (service 0x19) sub-functions.
+const (
+	reportNumberOfDTCByStatusMask      byte = 0x01
+	reportDTCByStatusMask              byte = 0x02
+	reportDTCSnapshotRecordByDTCNumber byte = 0x04
+	reportDTCExtDataRecordByDTCNumber  byte = 0x06
+	reportSupportedDTC                 byte = 0x0A
+)
+
+// AllRecords requests every snapshot or extended data record of a trouble code.
+const AllRecords byte = 0xFF
+
+// DTCStatus is the status byte UDS reports with each trouble code.
+type DTCStatus byte
+
+// DTC status bits defined by ISO 14229-1.
+const (
+	DTCTestFailed                         DTCStatus = 0x01
+	DTCTestFailedThisOperationCycle       DTCStatus = 0x02
+	DTCPending                            DTCStatus = 0x04
+	DTCConfirmed                          DTCStatus = 0x08
+	DTCTestNotCompletedSinceLastClear     DTCStatus = 0x10
+	DTCTestFailedSinceLastClear           DTCStatus = 0x20
+	DTCTestNotCompletedThisOperationCycle DTCStatus = 0x40
+	DTCWarningIndicatorRequested          DTCStatus = 0x80
+	AllDTCStatus                          DTCStatus = 0xFF
+)
+
+var dtcStatusNames = []string{
+	"testFailed",
+	"testFailedThisOperationCycle",
+	"pendingDTC",
+	"confirmedDTC",
+	"testNotCompletedSinceLastClear",
+	"testFailedSinceLastClear",
+	"testNotCompletedThisOperationCycle",
+	"warningIndicatorRequested",
+}
+
+// Has reports whether every bit of flags is set.
+func (s DTCStatus) Has(flags DTCStatus) bool {
+	return s&flags == flags
+}
+
+// Flags returns the ISO 14229 names of the bits that are set, e.g. "confirmedDTC".
+func (s DTCStatus) Flags() []string {
+	var flags []string
+
+	for bit, name := range dtcStatusNames {
+		if s&(1<<bit) != 0 {
+			flags = append(flags, name)
+		}
+	}
+
+	return flags
+}
+
+// String returns the names of the bits that are set, separated by "|".
+func (s DTCStatus) String() string {
+	return strings.Join(s.Flags(), "|")
+}
+
+// DTC is a trouble code as reported by UDS: three bytes, the two of the OBD-II code followed by a failure type, and
+// its status.
+type DTC struct {
+	Code   uint32
+	Status DTCStatus
+}
+
+// Name returns the OBD-II style code of the first two bytes, e.g. "P0301" for 0x030100.
+func (d DTC) Name() string {
+	return formatDTC(byte(d.Code>>16), byte(d.Code>>8))
+}
+
+// FailureType returns the third byte of the code, which tells how the component failed, e.g. 0x1C for "circuit
+// voltage out of range".
+func (d DTC) FailureType() byte {
+	return byte(d.Code)
+}
+
+// String formats the code with its failure type, e.g. "P0301-1C".
+func (d DTC) String() string {
+	return fmt.Sprintf("%s-%02X", d.Name(), d.FailureType())
+}
+
+// DTCCount is the answer to a request for the number of trouble codes matching a status mask.
+type DTCCount struct {
+	// Availability holds the status bits the ECU supports.
+	Availability DTCStatus
+	// Format is the DTC format identifier, 0x01 for ISO 14229-1 codes.
+	Format byte
+	Count  int
+}
+
+// SnapshotRecord is a snapshot of data identifiers the ECU stored when a trouble code was set.
+type SnapshotRecord struct {
+	Number byte
+	// Values holds the value of each data identifier whose length was known.
+	Values map[uint16][]byte
+	// Raw holds the data of the record that could not be split into values, starting with the first unknown
+	// identifier.
+	Raw []byte
+}
+
+// ExtendedDataRecord is manufacturer specific data the ECU keeps about a trouble code, e.g. occurrence counters.
+type ExtendedDataRecord struct {
+	Number byte
+	Data   []byte
+}
+
+// ReadDTCCount returns the number of trouble codes whose status matches mask (service 0x19, sub-function 0x01).
+func (c *UDSClient) ReadDTCCount(mask DTCStatus) (DTCCount, error) {
+	data, err := c.readDTCInformation(reportNumberOfDTCByStatusMask, byte(mask))
+	if err != nil {
+		return DTCCount{}, err
+	}
+
+	if len(data) != 4 {
+		return DTCCount{}, fmt.Errorf("DTC count answered % X: %w", data, ErrUnexpectedResponse)
+	}
+
+	return DTCCount{Availability: DTCStatus(data[0]), Format: data[1], Count: int(data[2])<<8 | int(data[3])}, nil
+}
+
+// ReadDTCs returns the trouble codes whose status matches mask (service 0x19, sub-function 0x02), e.g.
+// DTCConfirmed for the stored codes, and the status bits the ECU supports.
+func (c *UDSClient) ReadDTCs(mask DTCStatus) ([]DTC, DTCStatus, error) {
+	return c.readDTCList(reportDTCByStatusMask, byte(mask))
+}
+
+// ReadSupportedDTCs returns every trouble code the ECU can report, whatever its status (service 0x19, sub-function
+// 0x0A), and the status bits the ECU supports.
+func (c *UDSClient) ReadSupportedDTCs() ([]DTC, DTCStatus, error) {
+	return c.readDTCList(reportSupportedDTC)
+}
+
+func (c *UDSClient) readDTCList(subFunction byte, parameters ...byte) ([]DTC, DTCStatus, error) {
+	data, err := c.readDTCInformation(subFunction, parameters...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(data) == 0 || (len(data)-1)%4 != 0 {
+		return nil, 0, fmt.Errorf("DTC list answered % X: %w", data, ErrUnexpectedResponse)
+	}
+
+	dtcs := make([]DTC, 0, (len(data)-1)/4)
+	for i := 1; i < len(data); i += 4 {
+		dtcs = append(dtcs, decodeDTC(data[i:]))
+	}
+
+	return dtcs, DTCStatus(data[0]), nil
+}
+
+// ReadDTCSnapshots returns the snapshot records of a trouble code (service 0x19, sub-function 0x04), the one
+// numbered record or AllRecords. The length of the data identifiers' values is ECU specific: didLengths gives it
+// for the identifiers to decode, the data from the first unknown identifier on is returned raw.
+func (c *UDSClient) ReadDTCSnapshots(code uint32, record byte, didLengths map[uint16]int) (DTC, []SnapshotRecord, error) {
+	dtc, data, err := c.readDTCRecords(reportDTCSnapshotRecordByDTCNumber, code, record)
+	if err != nil {
+		return DTC{}, nil, err
+	}
+
+	var records []SnapshotRecord
+
+	for len(data) >= 2 {
+		snapshot := SnapshotRecord{Number: data[0], Values: map[uint16][]byte{}}
+		count := int(data[1])
+		data = data[2:]
+
+		for ; count > 0; count-- {
+			if len(data) < 2 {
+				return DTC{}, nil, fmt.Errorf("snapshot %02X of %s is truncated: %w", snapshot.Number, dtc, ErrUnexpectedResponse)
+			}
+
+			did := uint16(data[0])<<8 | uint16(data[1])
+
+			length, ok := didLengths[did]
+			if !ok || len(data) < 2+length {
+				snapshot.Raw, data = data, nil
+
+				break
+			}
+
+			snapshot.Values[did] = data[2 : 2+length]
+			data = data[2+length:]
+		}
+
+		records = append(records, snapshot)
+	}
+
+	return dtc, records, nil
+}
+
+// ReadDTCExtendedData returns the extended data records of a trouble code (service 0x19, sub-function 0x06), the
+// one numbered record or AllRecords. The length of the records is ECU specific: recordLengths gives it for the
+// records to split, the data from the first unknown record on is returned as that record's.
+func (c *UDSClient) ReadDTCExtendedData(code uint32, record byte, recordLengths map[byte]int) (DTC, []ExtendedDataRecord, error) {
+	dtc, data, err := c.readDTCRecords(reportDTCExtDataRecordByDTCNumber, code, record)
+	if err != nil {
+		return DTC{}, nil, err
+	}
+
+	var records []ExtendedDataRecord
+
+	for len(data) > 0 {
+		length, ok := recordLengths[data[0]]
+		if !ok || len(data) < 1+length {
+			length = len(data) - 1
+		}
+
+		records = append(records, ExtendedDataRecord{Number: data[0], Data: data[1 : 1+length]})
+		data = data[1+length:]
+	}
+
+	return dtc, records, nil
+}
+
+// readDTCRecords requests the records of a trouble code and returns the code with its status and the records.
+func (c *UDSClient) readDTCRecords(subFunction byte, code uint32, record byte) (DTC, []byte, error) {
+	data, err := c.readDTCInformation(subFunction, byte(code>>16), byte(code>>8), byte(code), record)
+	if err != nil {
+		return DTC{}, nil, err
+	}
+
+	if len(data) < 4 {
+		return DTC{}, nil, fmt.Errorf("DTC records answered % X: %w", data, ErrUnexpectedResponse)
+	}
+
+	dtc := decodeDTC(data)
+	if dtc.Code != code&0xFFFFFF {
+		return DTC{}, nil, fmt.Errorf("records of %06X answered for %s: %w", code, dtc, ErrUnexpectedResponse)
+	}
+
+	return dtc, data[4:], nil
+}
+
+// readDTCInformation sends a ReadDTCInformation request and returns its answer without the sub-function.
+func (c *UDSClient) readDTCInformation(subFunction byte, parameters ...byte) ([]byte, error) {
+	data, err := c.Request(ServiceReadDTCInformation, append([]byte{subFunction}, parameters...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || data[0] != subFunction {
+		return nil, fmt.Errorf("DTC information 0x%02X answered % X: %w", subFunction, data, ErrUnexpectedResponse)
+	}
+
+	return data[1:], nil
+}
+
+// decodeDTC decodes a three byte trouble code followed by its status.
+func decodeDTC(data []byte) DTC {
+	return DTC{Code: uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2]), Status: DTCStatus(data[3])}
+}
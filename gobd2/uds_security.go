@@ -0,0 +1,164 @@
+package gobd2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Defaults for the attempt counter of SecurityAccess.
+const (
+	defaultSecurityAttempts = 3
+	defaultSecurityDelay    = 10 * time.Second
+)
+
+// Errors returned by UDSClient.Unlock.
+var (
+	ErrInvalidSecurityLevel = errors.New("security levels are odd numbers from 0x01 to 0x7D")
+	ErrSecurityDelay        = errors.New("security access is delayed after failed attempts")
+)
+
+// SecurityLevel is a UDS security level, identified by the odd sub-function that requests its seed, e.g. 0x01.
+// The key is sent with the following even sub-function.
+type SecurityLevel byte
+
+// SeedKeyAlgorithm computes the key that unlocks a security level from the seed the ECU sent. The algorithms are
+// specific to each manufacturer and often to each ECU.
+type SeedKeyAlgorithm interface {
+	Key(level SecurityLevel, seed []byte) ([]byte, error)
+}
+
+// SeedKeyFunc adapts a function to a SeedKeyAlgorithm.
+type SeedKeyFunc func(level SecurityLevel, seed []byte) ([]byte, error)
+
+// Key calls f.
+func (f SeedKeyFunc) Key(level SecurityLevel, seed []byte) ([]byte, error) {
+	return f(level, seed)
+}
+
+// SecurityState is what a UDSClient knows about a security level of its ECU.
+type SecurityState struct {
+	// Unlocked reports whether the level was unlocked in the current session.
+	Unlocked bool
+	// FailedAttempts is the number of keys the ECU rejected since the level was last unlocked or delayed.
+	FailedAttempts int
+	// DelayedUntil is when the ECU accepts new attempts after rejecting too many keys, zero if it does now.
+	DelayedUntil time.Time
+}
+
+// WithSecurityAttempts sets how many rejected keys Unlock allows before waiting for the security delay, like
+// ECUs do. It defaults to 3.
+func WithSecurityAttempts(attempts int) UDSOption {
+	return func(c *UDSClient) {
+		c.securityAttempts = attempts
+	}
+}
+
+// WithSecurityDelay sets how long Unlock waits after too many rejected keys, when the ECU does not tell. It
+// defaults to 10 seconds.
+func WithSecurityDelay(delay time.Duration) UDSOption {
+	return func(c *UDSClient) {
+		c.securityDelay = delay
+	}
+}
+
+// SecurityState returns the state of level.
+func (c *UDSClient) SecurityState(level SecurityLevel) SecurityState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.security[level]
+}
+
+// Unlock unlocks level with SecurityAccess (service 0x27): it requests a seed, computes the key with algorithm
+// and sends it. Levels stay unlocked until the session changes, so most ECUs must be switched to a non-default
+// session first.
+//
+// Rejected keys are counted. After too many of them, or when the ECU reports exceededNumberOfAttempts or
+// requiredTimeDelayNotExpired, Unlock fails with ErrSecurityDelay without contacting the ECU until the delay has
+// passed.
+func (c *UDSClient) Unlock(level SecurityLevel, algorithm SeedKeyAlgorithm) error {
+	if level == 0 || level%2 == 0 || level > 0x7D {
+		return fmt.Errorf("level 0x%02X: %w", byte(level), ErrInvalidSecurityLevel)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.security[level]
+
+	switch {
+	case state.Unlocked:
+		return nil
+	case time.Now().Before(state.DelayedUntil):
+		return fmt.Errorf("level 0x%02X for another %s: %w", byte(level), time.Until(state.DelayedUntil).Round(time.Second),
+			ErrSecurityDelay)
+	}
+
+	seed, err := c.securityAccess(level, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(seed) > 0 && !bytes.Equal(seed, make([]byte, len(seed))) {
+		key, err := algorithm.Key(level, seed)
+		if err != nil {
+			return fmt.Errorf("level 0x%02X: computing key: %w", byte(level), err)
+		}
+
+		if _, err := c.securityAccess(level+1, key); err != nil {
+			return err
+		}
+	}
+
+	// A seed of zeros means the level is unlocked already.
+	c.security[level] = SecurityState{Unlocked: true}
+
+	return nil
+}
+
+// securityAccess sends a SecurityAccess request with the client locked and returns the parameters of its answer,
+// the seed when requesting one. Rejections are counted against the level.
+func (c *UDSClient) securityAccess(subFunction SecurityLevel, key []byte) ([]byte, error) {
+	level := subFunction - (subFunction+1)%2
+
+	data, err := c.request(ServiceSecurityAccess, append([]byte{byte(subFunction)}, key...)...)
+
+	var negative *NegativeResponseError
+	if errors.As(err, &negative) {
+		c.securityRejected(level, negative.Code)
+
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 || data[0] != byte(subFunction) {
+		return nil, fmt.Errorf("security access 0x%02X answered % X: %w", byte(subFunction), data, ErrUnexpectedResponse)
+	}
+
+	return data[1:], nil
+}
+
+// securityRejected updates the state of level after the ECU rejected a request with code.
+func (c *UDSClient) securityRejected(level SecurityLevel, code NegativeResponseCode) {
+	state := c.security[level]
+
+	switch code {
+	case NRCInvalidKey:
+		state.FailedAttempts++
+		if state.FailedAttempts < c.securityAttempts {
+			break
+		}
+
+		fallthrough
+	case NRCExceededNumberOfAttempts, NRCRequiredTimeDelayNotExpired:
+		state.FailedAttempts = 0
+		state.DelayedUntil = time.Now().Add(c.securityDelay)
+	}
+
+	c.security[level] = state
+}
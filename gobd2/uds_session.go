@@ -0,0 +1,216 @@
+package gobd2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultTesterPresentInterval keeps sessions alive well within the 5 second S3 timeout of ISO 14229.
+const defaultTesterPresentInterval = 2 * time.Second
+
+// suppressPositiveResponse is set in a sub-function to ask the ECU not to answer a successful request.
+const suppressPositiveResponse = 0x80
+
+// DiagnosticSession is a UDS diagnostic session, selected with DiagnosticSessionControl (service 0x10).
+type DiagnosticSession byte
+
+// Diagnostic sessions defined by ISO 14229-1.
+const (
+	DefaultSession            DiagnosticSession = 0x01
+	ProgrammingSession        DiagnosticSession = 0x02
+	ExtendedDiagnosticSession DiagnosticSession = 0x03
+	SafetySystemSession       DiagnosticSession = 0x04
+)
+
+var sessionNames = map[DiagnosticSession]string{
+	DefaultSession:            "default",
+	ProgrammingSession:        "programming",
+	ExtendedDiagnosticSession: "extended",
+	SafetySystemSession:       "safety system",
+}
+
+// String returns the name of the session, e.g. "extended".
+func (s DiagnosticSession) String() string {
+	if name, ok := sessionNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("session 0x%02X", byte(s))
+}
+
+// SessionTiming holds the timing parameters an ECU reports when entering a session.
+type SessionTiming struct {
+	// P2 is the time the ECU takes at most to start answering a request.
+	P2 time.Duration
+	// P2Extended is the time the ECU takes at most to answer after a "response pending" answer.
+	P2Extended time.Duration
+}
+
+// defaultSessionTiming is the timing ISO 14229-2 specifies for the default session, assumed until the ECU reports
+// its own.
+var defaultSessionTiming = SessionTiming{P2: 50 * time.Millisecond, P2Extended: 5 * time.Second}
+
+// WithTesterPresentInterval sets how often StartSession sends TesterPresent to keep the session alive. It defaults
+// to 2 seconds.
+func WithTesterPresentInterval(interval time.Duration) UDSOption {
+	return func(c *UDSClient) {
+		c.testerPresentInterval = interval
+	}
+}
+
+// Session returns the diagnostic session the ECU was last switched to.
+func (c *UDSClient) Session() DiagnosticSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.session
+}
+
+// DiagnosticSessionControl switches the ECU to session (service 0x10) and returns the timing it reports. Sessions
+// other than the default one end when the ECU receives no request for a few seconds; StartSession keeps them
+// alive.
+func (c *UDSClient) DiagnosticSessionControl(session DiagnosticSession) (SessionTiming, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.diagnosticSessionControl(session)
+}
+
+func (c *UDSClient) diagnosticSessionControl(session DiagnosticSession) (SessionTiming, error) {
+	data, err := c.request(ServiceDiagnosticSessionControl, byte(session))
+	if err != nil {
+		return SessionTiming{}, err
+	}
+
+	if len(data) == 0 || data[0] != byte(session) {
+		return SessionTiming{}, fmt.Errorf("session %s answered % X: %w", session, data, ErrUnexpectedResponse)
+	}
+
+	c.enterSession(session)
+
+	var timing SessionTiming
+	if len(data) >= 5 {
+		timing.P2 = time.Duration(uint16(data[1])<<8|uint16(data[2])) * time.Millisecond
+		timing.P2Extended = time.Duration(uint16(data[3])<<8|uint16(data[4])) * 10 * time.Millisecond
+
+		if err := c.applyTiming(timing); err != nil {
+			return timing, err
+		}
+	}
+
+	return timing, nil
+}
+
+// applyTiming makes the adapter wait for answers as long as the P2* of timing, the session's new timing.
+func (c *UDSClient) applyTiming(timing SessionTiming) error {
+	previous := responseTimeoutCommand(c.timing.P2Extended)
+	c.timing = timing
+
+	if command := responseTimeoutCommand(timing.P2Extended); command != previous {
+		return sendSettings(c.connector, []CommandCode{command})
+	}
+
+	return nil
+}
+
+// enterSession records that the ECU switched to session, which locks every security level again.
+func (c *UDSClient) enterSession(session DiagnosticSession) {
+	c.session = session
+
+	for level, state := range c.security {
+		state.Unlocked = false
+		c.security[level] = state
+	}
+}
+
+// StartSession switches the ECU to session and, unless it is the default session, keeps it alive by sending
+// TesterPresent in the background until Close is called or ctx is done. When ctx is done, the ECU is returned to
+// the default session.
+//
+// The keepalive asks the ECU not to answer and turns the adapter's responses off meanwhile, so it does not wait for
+// an answer that never comes.
+func (c *UDSClient) StartSession(ctx context.Context, session DiagnosticSession) (SessionTiming, error) {
+	c.stopKeepalive()
+
+	timing, err := c.DiagnosticSessionControl(session)
+	if err != nil || session == DefaultSession {
+		return timing, err
+	}
+
+	k := &keepalive{stop: make(chan struct{}), done: make(chan struct{})}
+
+	c.mu.Lock()
+	c.keepalive = k
+	c.mu.Unlock()
+
+	go c.keepAlive(ctx, k)
+
+	return timing, nil
+}
+
+// keepalive is a running TesterPresent loop.
+type keepalive struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// keepAlive sends TesterPresent every interval until stopped, or until ctx is done, when it returns the ECU to the
+// default session. Failed TesterPresent requests are retried on the next tick.
+func (c *UDSClient) keepAlive(ctx context.Context, k *keepalive) {
+	defer close(k.done)
+
+	ticker := time.NewTicker(c.testerPresentInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ctx.Done():
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.keepalive == k {
+				c.keepalive = nil
+			}
+
+			if c.open && c.session != DefaultSession {
+				c.diagnosticSessionControl(DefaultSession) //nolint:errcheck // nobody is left to report it to
+			}
+
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			c.sendTesterPresent() //nolint:errcheck // the session survives a few missed requests
+			c.mu.Unlock()
+		}
+	}
+}
+
+// sendTesterPresent sends TesterPresent without waiting for an answer, with the client locked.
+func (c *UDSClient) sendTesterPresent() error {
+	if err := sendSettings(c.connector, []CommandCode{"ATR0"}); err != nil {
+		return err
+	}
+
+	_, err := c.connector.SendCommand(CommandCode(fmt.Sprintf("%02X%02X", ServiceTesterPresent, suppressPositiveResponse)))
+
+	return errors.Join(err, sendSettings(c.connector, []CommandCode{"ATR1"}))
+}
+
+// stopKeepalive stops the running keepalive, if any, and waits for it to finish.
+func (c *UDSClient) stopKeepalive() {
+	c.mu.Lock()
+	k := c.keepalive
+	c.keepalive = nil
+	c.mu.Unlock()
+
+	if k == nil {
+		return
+	}
+
+	close(k.stop)
+	<-k.done
+}
@@ -0,0 +1,578 @@
+package gobd2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/janekbaraniewski/gobd2/gobd2/gobd2test"
+	"github.com/stretchr/testify/require"
+)
+
+// udsVehicle creates a vehicle on protocol with an engine ECU answering with address and holding the VIN as
+// data identifier F190.
+func udsVehicle(protocol gobd2.Protocol, address uint32, pending int) (*emulator.StaticVehicle, *emulator.ECU) {
+	engine := &emulator.ECU{
+		Address:         address,
+		PIDs:            map[byte][]byte{0x0D: {0x00}},
+		DTCs:            []string{"P0301"},
+		DataIdentifiers: map[uint16][]byte{0xF190: []byte("1GOBD2EMULATOR001")},
+		ResponsePending: pending,
+	}
+
+	return emulator.NewStaticVehicle(protocol, engine), engine
+}
+
+func TestUDSClient_ReadDataByIdentifier(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		protocol gobd2.Protocol
+		address  uint32
+		ecu      string
+	}{
+		{"11-bit CAN", gobd2.ProtocolCAN11Bit500, 0x7E8, "7E8"},
+		{"29-bit CAN", gobd2.ProtocolCAN29Bit500, 0x18DAF110, "18DAF110"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			vehicle, _ := udsVehicle(tt.protocol, tt.address, 0)
+			connector := connectVehicle(t, vehicle)
+
+			client, err := gobd2.NewUDSClient(connector, tt.ecu)
+			require.NoError(t, err)
+
+			vin, err := client.ReadDataByIdentifier(0xF190)
+			require.NoError(t, err)
+			require.Equal(t, "1GOBD2EMULATOR001", string(vin))
+
+			require.NoError(t, client.TesterPresent())
+			require.NoError(t, client.Close())
+
+			response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+			require.NoError(t, err)
+			require.Equal(t, "41 0D 00", response, "functional addressing without headers is restored")
+		})
+	}
+}
+
+func TestUDSClient_NegativeResponse(t *testing.T) {
+	t.Parallel()
+
+	vehicle, _ := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8")
+	require.NoError(t, err)
+
+	_, err = client.ReadDataByIdentifier(0xF18C)
+
+	var negative *gobd2.NegativeResponseError
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.ServiceReadDataByIdentifier, negative.Service)
+	require.Equal(t, gobd2.NRCRequestOutOfRange, negative.Code)
+	require.EqualError(t, err, "service 0x22 rejected: requestOutOfRange (0x31)")
+
+	_, err = client.Request(0x23, 0x12, 0x00, 0x10, 0x01)
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCServiceNotSupported, negative.Code)
+}
+
+func TestUDSClient_Close_RestoresSettings(t *testing.T) {
+	t.Parallel()
+
+	profile := gobd2.DefaultInitProfile()
+	profile.StepDelay = 0
+	profile.Headers = gobd2.ToggleOn
+	profile.ExtraCommands = []gobd2.CommandCode{"ATCRA7E8"}
+
+	vehicle, _ := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	connector := gobd2.NewSerialConnector("emulator", 38400, &emulator.Opener{Vehicle: vehicle},
+		gobd2.WithInitProfile(profile))
+	require.NoError(t, connector.Connect())
+
+	t.Cleanup(func() { connector.Close() })
+
+	_, err := connector.SendCommand("ATSH7E0")
+	require.NoError(t, err)
+
+	client, err := gobd2.NewUDSClient(connector, "7E8")
+	require.NoError(t, err)
+	require.NoError(t, client.TesterPresent())
+	require.NoError(t, client.Close())
+
+	for name, expected := range map[string]gobd2.CommandCode{"ATH": "ATH1", "ATSH": "ATSH7E0", "ATCRA": "ATCRA7E8"} {
+		setting, ok := connector.AdapterSetting(name)
+		require.True(t, ok, name)
+		require.Equal(t, expected, setting, name)
+	}
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "7E8 03 41 0D 00", response)
+}
+
+func TestUDSClient_AdapterIgnoringReceiveFilter(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	for _, command := range []gobd2.CommandCode{"ATH1", "ATSH7E0", "ATSTFF", "ATST32", "ATSH7DF", "ATH0"} {
+		mockConnector.On("SendCommand", command).Return("OK", nil).Once()
+	}
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("3E00")).Return("7E9 03 7F 3E 11\r7E8 02 7E 00", nil).Once()
+
+	connector := fingerprintedConnector{MockConnector: mockConnector, capabilities: gobd2.CapabilityMultiPID}
+
+	client, err := gobd2.NewUDSClient(connector, "7E8")
+	require.NoError(t, err)
+
+	require.NoError(t, client.TesterPresent())
+	require.NoError(t, client.Close())
+	mockConnector.AssertExpectations(t)
+}
+
+func TestUDSClient_ResponsePending(t *testing.T) {
+	t.Parallel()
+
+	vehicle, _ := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 2)
+	connector := connectVehicle(t, vehicle)
+	client, err := gobd2.NewUDSClient(connector, "7E8")
+	require.NoError(t, err)
+
+	vin, err := client.ReadDataByIdentifier(0xF190)
+	require.NoError(t, err)
+	require.Equal(t, "1GOBD2EMULATOR001", string(vin))
+
+	timeout, ok := connector.AdapterSetting("ATST")
+	require.True(t, ok)
+	require.Equal(t, gobd2.CommandCode("ATSTFF"), timeout, "the adapter waits as long as it can for the default P2*")
+
+	require.NoError(t, client.Close())
+
+	timeout, ok = connector.AdapterSetting("ATST")
+	require.True(t, ok)
+	require.Equal(t, gobd2.CommandCode("ATST32"), timeout, "the adapter's default timeout is restored")
+}
+
+func TestUDSClient_SessionTimeout(t *testing.T) {
+	t.Parallel()
+
+	mockConnector := new(MockConnector)
+	for _, command := range []gobd2.CommandCode{"ATH1", "ATSH7E0", "ATSTFF", "ATCRA7E8", "ATST19"} {
+		mockConnector.On("SendCommand", command).Return("OK", nil).Once()
+	}
+
+	mockConnector.On("SendCommand", gobd2.CommandCode("1003")).Return("7E8 06 50 03 00 32 00 0A", nil).Once()
+
+	client, err := gobd2.NewUDSClient(mockConnector, "7E8")
+	require.NoError(t, err)
+
+	timing, err := client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+	require.Equal(t, 100*time.Millisecond, timing.P2Extended)
+	mockConnector.AssertExpectations(t)
+}
+
+func TestNewUDSClient_Addressing(t *testing.T) {
+	t.Parallel()
+
+	for _, address := range []string{"10", "486B10", "18DB33F1", "ZZZ"} {
+		_, err := gobd2.NewUDSClient(nil, address)
+		require.ErrorIs(t, err, gobd2.ErrUnsupportedAddressing, address)
+	}
+
+	_, err := gobd2.NewUDSClient(nil, "10", gobd2.WithRequestHeader("7E0"))
+	require.NoError(t, err, "an explicit request header overrides the derived one")
+}
+
+func TestUDSClient_DiagnosticSessionControl(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.SessionTimeout = 100 * time.Millisecond
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8")
+	require.NoError(t, err)
+
+	timing, err := client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+	require.Equal(t, gobd2.SessionTiming{P2: 50 * time.Millisecond, P2Extended: 5 * time.Second}, timing)
+	require.Equal(t, gobd2.ExtendedDiagnosticSession, client.Session())
+
+	time.Sleep(3 * engine.SessionTimeout)
+
+	_, err = client.ReadDataByIdentifier(0xF190)
+	require.NoError(t, err)
+	vehicle.Update(func([]*emulator.ECU) { require.Equal(t, byte(0x01), engine.Session, "the session timed out") })
+}
+
+func TestUDSClient_StartSession(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.SessionTimeout = 100 * time.Millisecond
+	connector := connectVehicle(t, vehicle)
+	client, err := gobd2.NewUDSClient(connector, "7E8", gobd2.WithTesterPresentInterval(20*time.Millisecond))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = client.StartSession(ctx, gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+
+	time.Sleep(3 * engine.SessionTimeout)
+
+	_, err = client.ReadDataByIdentifier(0xF190)
+	require.NoError(t, err)
+	vehicle.Update(func([]*emulator.ECU) { require.Equal(t, byte(0x03), engine.Session, "TesterPresent kept it alive") })
+
+	cancel()
+
+	require.Eventually(t, func() bool { return client.Session() == gobd2.DefaultSession }, time.Second, 10*time.Millisecond)
+	vehicle.Update(func([]*emulator.ECU) { require.Equal(t, byte(0x01), engine.Session) })
+
+	_, err = client.StartSession(context.Background(), gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+	vehicle.Update(func([]*emulator.ECU) { require.Equal(t, byte(0x01), engine.Session, "Close ends the session") })
+
+	response, err := connector.SendCommand(gobd2.VehicleSpeedCommand)
+	require.NoError(t, err)
+	require.Equal(t, "41 0D 00", response)
+}
+
+// xorKey is the seed-key algorithm of the emulated ECUs in these tests.
+var xorKey = gobd2.SeedKeyFunc(func(level gobd2.SecurityLevel, seed []byte) ([]byte, error) {
+	key := make([]byte, len(seed))
+	for i, b := range seed {
+		key[i] = b ^ 0xA5 ^ byte(level)
+	}
+
+	return key, nil
+})
+
+func TestUDSClient_Unlock(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.SeedKey = xorKey
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8")
+	require.NoError(t, err)
+
+	err = client.Unlock(0x01, xorKey)
+
+	var negative *gobd2.NegativeResponseError
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCServiceNotSupportedInActiveSession, negative.Code)
+
+	_, err = client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+	require.NoError(t, client.Unlock(0x01, xorKey))
+	require.Equal(t, gobd2.SecurityState{Unlocked: true}, client.SecurityState(0x01))
+	require.NoError(t, client.Unlock(0x01, nil), "unlocked levels are not unlocked again")
+
+	_, err = client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+	require.False(t, client.SecurityState(0x01).Unlocked, "changing the session locks the level")
+
+	require.ErrorIs(t, client.Unlock(0x02, xorKey), gobd2.ErrInvalidSecurityLevel)
+}
+
+func TestUDSClient_Unlock_Attempts(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.SeedKey = xorKey
+	engine.SecurityDelay = 100 * time.Millisecond
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8", gobd2.WithSecurityDelay(100*time.Millisecond))
+	require.NoError(t, err)
+
+	_, err = client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+
+	wrongKey := gobd2.SeedKeyFunc(func(_ gobd2.SecurityLevel, seed []byte) ([]byte, error) { return seed, nil })
+
+	var negative *gobd2.NegativeResponseError
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		require.ErrorAs(t, client.Unlock(0x03, wrongKey), &negative)
+		require.Equal(t, gobd2.NRCInvalidKey, negative.Code)
+		require.Equal(t, attempt, client.SecurityState(0x03).FailedAttempts)
+	}
+
+	require.ErrorAs(t, client.Unlock(0x03, wrongKey), &negative)
+	require.Equal(t, gobd2.NRCExceededNumberOfAttempts, negative.Code)
+	require.False(t, client.SecurityState(0x03).DelayedUntil.IsZero())
+
+	require.ErrorIs(t, client.Unlock(0x03, xorKey), gobd2.ErrSecurityDelay)
+
+	time.Sleep(150 * time.Millisecond)
+
+	require.NoError(t, client.Unlock(0x03, xorKey))
+}
+
+func TestUDSClient_ReadDTCInformation(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.DTCRecords = []emulator.DTCRecord{
+		{
+			Code:         0x030100,
+			Status:       0x2F,
+			Snapshots:    map[byte]map[uint16][]byte{0x01: {0xF40C: {0x0C, 0x80}, 0xF40D: {0x32}}},
+			ExtendedData: map[byte][]byte{0x01: {0x05}, 0x02: {0x10, 0x20}},
+		},
+		{Code: 0xC14087, Status: 0x88},
+		{Code: 0x9A1234, Status: 0x50},
+	}
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8")
+	require.NoError(t, err)
+
+	count, err := client.ReadDTCCount(gobd2.DTCConfirmed)
+	require.NoError(t, err)
+	require.Equal(t, gobd2.DTCCount{Availability: gobd2.AllDTCStatus, Format: 0x01, Count: 2}, count)
+
+	dtcs, availability, err := client.ReadDTCs(gobd2.DTCConfirmed)
+	require.NoError(t, err)
+	require.Equal(t, gobd2.AllDTCStatus, availability)
+	require.Len(t, dtcs, 2)
+	require.Equal(t, "P0301-00", dtcs[0].String())
+	require.Equal(t, "U0140-87", dtcs[1].String())
+	require.Equal(t, byte(0x87), dtcs[1].FailureType())
+	require.True(t, dtcs[1].Status.Has(gobd2.DTCConfirmed|gobd2.DTCWarningIndicatorRequested))
+	require.Equal(t, []string{"confirmedDTC", "warningIndicatorRequested"}, dtcs[1].Status.Flags())
+	require.Equal(t, "testFailed|testFailedThisOperationCycle|pendingDTC|confirmedDTC|testFailedSinceLastClear",
+		dtcs[0].Status.String())
+
+	dtcs, _, err = client.ReadSupportedDTCs()
+	require.NoError(t, err)
+	require.Len(t, dtcs, 3)
+	require.Equal(t, "B1A12-34", dtcs[2].String())
+
+	dtc, snapshots, err := client.ReadDTCSnapshots(0x030100, gobd2.AllRecords, map[uint16]int{0xF40C: 2})
+	require.NoError(t, err)
+	require.Equal(t, gobd2.DTC{Code: 0x030100, Status: 0x2F}, dtc)
+	require.Equal(t, []gobd2.SnapshotRecord{{
+		Number: 0x01,
+		Values: map[uint16][]byte{0xF40C: {0x0C, 0x80}},
+		Raw:    []byte{0xF4, 0x0D, 0x32},
+	}}, snapshots, "the value of an identifier of unknown length is left raw")
+
+	_, records, err := client.ReadDTCExtendedData(0x030100, gobd2.AllRecords, map[byte]int{0x01: 1})
+	require.NoError(t, err)
+	require.Equal(t, []gobd2.ExtendedDataRecord{{Number: 0x01, Data: []byte{0x05}}, {Number: 0x02, Data: []byte{0x10, 0x20}}},
+		records)
+
+	_, records, err = client.ReadDTCExtendedData(0x030100, 0x02, nil)
+	require.NoError(t, err)
+	require.Equal(t, []gobd2.ExtendedDataRecord{{Number: 0x02, Data: []byte{0x10, 0x20}}}, records)
+
+	_, _, err = client.ReadDTCSnapshots(0x030200, gobd2.AllRecords, nil)
+
+	var negative *gobd2.NegativeResponseError
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCRequestOutOfRange, negative.Code)
+}
+
+func TestUDSClient_ClearDiagnosticInformation(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.DTCRecords = []emulator.DTCRecord{{Code: 0x030100, Status: 0x2F}}
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8", gobd2.WithWritesAllowed())
+	require.NoError(t, err)
+
+	require.NoError(t, client.ClearDiagnosticInformation(0xFFFFFF))
+
+	count, err := client.ReadDTCCount(gobd2.AllDTCStatus)
+	require.NoError(t, err)
+	require.Zero(t, count.Count)
+	vehicle.Update(func([]*emulator.ECU) { require.Empty(t, engine.DTCs) })
+}
+
+func TestUDSClient_WritesNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	connector := gobd2test.NewConnector()
+	client, err := gobd2.NewUDSClient(connector, "7E8")
+	require.NoError(t, err)
+
+	_, err = client.StartRoutine(0xFF00)
+	require.ErrorIs(t, err, gobd2.ErrWritesNotAllowed)
+
+	_, err = client.AdjustOutput(0x4A10, []byte{0xFF})
+	require.ErrorIs(t, err, gobd2.ErrWritesNotAllowed)
+
+	require.ErrorIs(t, client.WriteDataByIdentifier(0xF190, []byte("1GOBD2EMULATOR002")), gobd2.ErrWritesNotAllowed)
+	require.ErrorIs(t, client.ECUReset(gobd2.HardReset), gobd2.ErrWritesNotAllowed)
+	require.ErrorIs(t, client.ClearDiagnosticInformation(0xFFFFFF), gobd2.ErrWritesNotAllowed)
+
+	_, err = client.Request(gobd2.ServiceRoutineControl, 0x01, 0xFF, 0x00)
+	require.ErrorIs(t, err, gobd2.ErrWritesNotAllowed, "raw requests are guarded too")
+	require.Empty(t, connector.Calls(), "nothing is sent to the adapter")
+}
+
+func TestUDSClient_RoutineControl(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.Routines = map[uint16][]byte{0x0203: {0x00, 0x12, 0x34}}
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8", gobd2.WithWritesAllowed())
+	require.NoError(t, err)
+
+	var negative *gobd2.NegativeResponseError
+
+	_, err = client.StartRoutine(0x0203)
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCServiceNotSupportedInActiveSession, negative.Code)
+
+	_, err = client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+
+	_, err = client.RoutineResults(0x0203)
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCRequestSequenceError, negative.Code, "the routine was not started")
+
+	status, err := client.StartRoutine(0x0203, 0x01)
+	require.NoError(t, err)
+	require.Empty(t, status)
+
+	_, err = client.StopRoutine(0x0203)
+	require.NoError(t, err)
+
+	results, err := client.RoutineResults(0x0203)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x12, 0x34}, results)
+
+	_, err = client.StopRoutine(0x0203)
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCRequestSequenceError, negative.Code, "the routine is not running")
+
+	_, err = client.StartRoutine(0x0204)
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCRequestOutOfRange, negative.Code)
+
+	_, err = client.StartRoutine(0x0203, 0x01, 0x02, 0x03, 0x04)
+	require.ErrorIs(t, err, gobd2.ErrRequestTooLong)
+}
+
+func TestUDSClient_LongRequests(t *testing.T) {
+	t.Parallel()
+
+	port := newScriptedSerialPort(map[string]string{
+		"STI":      "STN2255 v5.6.19",
+		"STCSEGT1": "OK",
+		"ATH1":     "OK",
+		"ATSH7E0":  "OK",
+		"ATSTFF":   "OK",
+		"ATCRA7E8": "OK",
+		"STPXD:2EF19031474F424432454D554C41544F52303032": "7E8 03 6E F1 90",
+	})
+	connector := connectScripted(t, port, gobd2.WithSTNExtensions())
+
+	client, err := gobd2.NewUDSClient(connector, "7E8", gobd2.WithWritesAllowed())
+	require.NoError(t, err)
+	require.NoError(t, client.WriteDataByIdentifier(0xF190, []byte("1GOBD2EMULATOR002")))
+}
+
+func TestUDSClient_InputOutputControl(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.IOControls = map[uint16][]byte{0x4A10: {0x00, 0x20}}
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8", gobd2.WithWritesAllowed())
+	require.NoError(t, err)
+
+	_, err = client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+
+	state, err := client.AdjustOutput(0x4A10, []byte{0xFF, 0x80})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xFF, 0x80}, state)
+
+	state, err = client.InputOutputControl(0x4A10, gobd2.FreezeCurrentState, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xFF, 0x80}, state)
+
+	state, err = client.ReturnControl(0x4A10)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x20}, state)
+
+	_, err = client.AdjustOutput(0x4A10, []byte{0xFF})
+
+	var negative *gobd2.NegativeResponseError
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCIncorrectMessageLength, negative.Code)
+}
+
+func TestUDSClient_WriteDataByIdentifier(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.DataIdentifiers[0x0101] = []byte{0x00, 0x00}
+	engine.DataIdentifiers[0x0102] = []byte{0x00}
+	engine.WritableDataIdentifiers = []uint16{0x0101, 0x0102}
+	engine.SeedKey = xorKey
+	engine.Services = map[string][]byte{"2E010201": {0x6E, 0x01, 0x02}}
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8", gobd2.WithWritesAllowed(),
+		gobd2.WithWriteVerification())
+	require.NoError(t, err)
+
+	_, err = client.DiagnosticSessionControl(gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+
+	err = client.WriteDataByIdentifier(0x0101, []byte{0x12, 0x34})
+
+	var negative *gobd2.NegativeResponseError
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCSecurityAccessDenied, negative.Code)
+
+	require.NoError(t, client.Unlock(0x01, xorKey))
+	require.NoError(t, client.WriteDataByIdentifier(0x0101, []byte{0x12, 0x34}))
+
+	record, err := client.ReadDataByIdentifier(0x0101)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x12, 0x34}, record)
+
+	err = client.WriteDataByIdentifier(0xF190, []byte("1GOBD2EMULATOR002"))
+	require.ErrorIs(t, err, gobd2.ErrRequestTooLong, "a plain ELM327 cannot send the VIN in a single frame")
+
+	err = client.WriteDataByIdentifier(0xF190, []byte("1GOB"))
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCRequestOutOfRange, negative.Code, "the VIN is read-only")
+
+	err = client.WriteDataByIdentifier(0x0102, []byte{0x01})
+	require.ErrorIs(t, err, gobd2.ErrWriteVerification, "the ECU acknowledges the write without storing it")
+	require.EqualError(t, err, "DID 0102 reads 00 after writing 01: written data does not read back")
+}
+
+func TestUDSClient_ECUReset(t *testing.T) {
+	t.Parallel()
+
+	vehicle, engine := udsVehicle(gobd2.ProtocolCAN11Bit500, 0x7E8, 0)
+	engine.SeedKey = xorKey
+	client, err := gobd2.NewUDSClient(connectVehicle(t, vehicle), "7E8", gobd2.WithWritesAllowed(),
+		gobd2.WithTesterPresentInterval(20*time.Millisecond))
+	require.NoError(t, err)
+
+	_, err = client.StartSession(context.Background(), gobd2.ExtendedDiagnosticSession)
+	require.NoError(t, err)
+	require.NoError(t, client.Unlock(0x01, xorKey))
+
+	require.NoError(t, client.ECUReset(gobd2.SoftReset))
+	require.Equal(t, gobd2.DefaultSession, client.Session())
+	require.False(t, client.SecurityState(0x01).Unlocked, "the reset locks the ECU")
+	vehicle.Update(func([]*emulator.ECU) { require.Equal(t, byte(0x01), engine.Session) })
+
+	err = client.ECUReset(0x05)
+
+	var negative *gobd2.NegativeResponseError
+	require.ErrorAs(t, err, &negative)
+	require.Equal(t, gobd2.NRCSubFunctionNotSupported, negative.Code)
+	require.NoError(t, client.Close())
+}
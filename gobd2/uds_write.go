@@ -0,0 +1,102 @@
+package gobd2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrWriteVerification is returned when a data record written with verification reads back differently.
+var ErrWriteVerification = errors.New("written data does not read back")
+
+// ResetType is the kind of reset requested with ECUReset (service 0x11).
+type ResetType byte
+
+// Reset types defined by ISO 14229-1.
+const (
+	HardReset     ResetType = 0x01
+	KeyOffOnReset ResetType = 0x02
+	SoftReset     ResetType = 0x03
+)
+
+var resetNames = map[ResetType]string{
+	HardReset:     "hard",
+	KeyOffOnReset: "key off on",
+	SoftReset:     "soft",
+}
+
+// String returns the name of the reset type, e.g. "hard".
+func (t ResetType) String() string {
+	if name, ok := resetNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("reset 0x%02X", byte(t))
+}
+
+// WithWriteVerification makes WriteDataByIdentifier read every record back after writing it and fail with
+// ErrWriteVerification if it differs.
+func WithWriteVerification() UDSOption {
+	return func(c *UDSClient) {
+		c.verifyWrites = true
+	}
+}
+
+// WriteDataByIdentifier writes the data record identified by did (service 0x2E), e.g. coding or configuration
+// data. ECUs usually only accept writes outside the default session and once a security level is unlocked. The
+// client must be created WithWritesAllowed. Records longer than 4 bytes need a connector using the STN extensions.
+func (c *UDSClient) WriteDataByIdentifier(did uint16, record []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := c.request(ServiceWriteDataByIdentifier, append([]byte{byte(did >> 8), byte(did)}, record...)...)
+	if err != nil {
+		return err
+	}
+
+	if len(data) != 2 || uint16(data[0])<<8|uint16(data[1]) != did {
+		return fmt.Errorf("writing DID %04X answered % X: %w", did, data, ErrUnexpectedResponse)
+	}
+
+	if !c.verifyWrites {
+		return nil
+	}
+
+	data, err = c.request(ServiceReadDataByIdentifier, byte(did>>8), byte(did))
+	if err != nil {
+		return fmt.Errorf("reading back DID %04X: %w", did, err)
+	}
+
+	if len(data) < 2 || uint16(data[0])<<8|uint16(data[1]) != did {
+		return fmt.Errorf("reading back DID %04X answered % X: %w", did, data, ErrUnexpectedResponse)
+	}
+
+	if !bytes.Equal(data[2:], record) {
+		return fmt.Errorf("DID %04X reads % X after writing % X: %w", did, data[2:], record, ErrWriteVerification)
+	}
+
+	return nil
+}
+
+// ECUReset resets the ECU (service 0x11). The ECU restarts in the default session with every security level
+// locked, so the TesterPresent keepalive of StartSession is stopped. The client must be created
+// WithWritesAllowed.
+func (c *UDSClient) ECUReset(reset ResetType) error {
+	data, err := c.Request(ServiceECUReset, byte(reset))
+	if err != nil {
+		return err
+	}
+
+	if len(data) == 0 || data[0] != byte(reset) {
+		return fmt.Errorf("%s reset answered % X: %w", reset, data, ErrUnexpectedResponse)
+	}
+
+	c.stopKeepalive()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.enterSession(DefaultSession)
+
+	return nil
+}
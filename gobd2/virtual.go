@@ -0,0 +1,189 @@
+package gobd2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"time"
+)
+
+// ReadVirtualSensors reads virtual sensors defined in JSON, e.g.
+//
+//	[
+//	  {"name": "Intake heat soak", "expression": "IAT - AAT", "unit": "°C"},
+//	  {"name": "Engine power estimate", "shortName": "HP", "expression": "MAF * 1.32", "unit": "hp"}
+//	]
+//
+// Their expressions refer to other PIDs by name or short name, [Engine RPM] for names that are not identifiers.
+func ReadVirtualSensors(r io.Reader) ([]PID, error) {
+	var sensors []PID
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&sensors); err != nil {
+		return nil, fmt.Errorf("failed to decode virtual sensors: %w", err)
+	}
+
+	for i := range sensors {
+		if !sensors[i].Virtual() {
+			return nil, fmt.Errorf("virtual sensor %q has a command: %w", sensors[i].Name, ErrInvalidPID)
+		}
+
+		if err := sensors[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return sensors, nil
+}
+
+// LoadVirtualSensors reads the virtual sensors defined in the JSON file at path.
+func LoadVirtualSensors(path string) ([]PID, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sensors, err := ReadVirtualSensors(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return sensors, nil
+}
+
+// Sample is the outcome of reading or computing a PID.
+type Sample struct {
+	PID     PID
+	Reading Reading
+	Err     error
+}
+
+// Sampler reads a set of PIDs, virtual sensors included. Each sample reads the PIDs the virtual sensors are computed
+// from once, however many sensors use them, and computes the sensors from these readings. Adapters fingerprinted as
+// forwarding multi-PID requests are asked for up to six standard PIDs at once.
+type Sampler struct {
+	commander *Commander
+	pids      []PID
+	schedule  []PID
+	// inputs maps the names each virtual sensor refers to to the names of the PIDs they resolve to.
+	inputs map[string]map[string]string
+}
+
+// NewSampler creates a sampler of pids, resolving the dependencies of virtual sensors in registry.
+func NewSampler(commander *Commander, registry *PIDRegistry, pids ...PID) (*Sampler, error) {
+	schedule, err := registry.Resolve(pids...)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := map[string]map[string]string{}
+
+	for _, pid := range schedule {
+		if !pid.Virtual() {
+			continue
+		}
+
+		inputs[pid.Name] = map[string]string{}
+
+		for _, name := range pid.references() {
+			input, err := registry.Lookup(name)
+			if err != nil {
+				return nil, err
+			}
+
+			inputs[pid.Name][name] = input.Name
+		}
+	}
+
+	return &Sampler{commander: commander, pids: pids, schedule: schedule, inputs: inputs}, nil
+}
+
+// PIDs returns the PIDs the sampler was created for.
+func (s *Sampler) PIDs() []PID {
+	return s.pids
+}
+
+// Sample reads the PIDs and computes the virtual sensors, returning a sample of each PID the sampler was created
+// for, in the same order. A virtual sensor fails if one of its inputs does.
+func (s *Sampler) Sample() []Sample {
+	samples := make(map[string]Sample, len(s.schedule))
+	readings := s.readBatches()
+
+	for _, pid := range s.schedule {
+		sample := Sample{PID: pid}
+
+		switch reading, ok := readings[pid.Name]; {
+		case pid.Virtual():
+			sample.Reading, sample.Err = s.compute(pid, samples)
+		case ok:
+			sample.Reading = reading
+		default:
+			sample.Reading, sample.Err = s.commander.ReadPID(pid)
+		}
+
+		samples[pid.Name] = sample
+	}
+
+	result := make([]Sample, len(s.pids))
+	for i, pid := range s.pids {
+		result[i] = samples[pid.Name]
+	}
+
+	return result
+}
+
+// readBatches reads the PIDs that can be requested together in requests for up to six of them, if the adapter is
+// known to forward such requests. PIDs missing from the answers are left to be read one at a time.
+func (s *Sampler) readBatches() map[string]Reading {
+	readings := map[string]Reading{}
+
+	if caps, known := adapterCapabilities(s.commander.connector); !known || !caps.Has(CapabilityMultiPID) {
+		return readings
+	}
+
+	var batch []PID
+
+	for _, pid := range s.schedule {
+		if pid.batchable() {
+			batch = append(batch, pid)
+		}
+	}
+
+	for len(batch) > 1 {
+		n := min(len(batch), maxPIDsPerRequest)
+
+		if answered, err := s.commander.readPIDs(batch[:n]); err == nil {
+			maps.Copy(readings, answered)
+		}
+
+		batch = batch[n:]
+	}
+
+	return readings
+}
+
+// compute computes a virtual sensor from the samples of its inputs.
+func (s *Sampler) compute(pid PID, samples map[string]Sample) (Reading, error) {
+	values := make(map[string]float64, len(s.inputs[pid.Name]))
+
+	for name, input := range s.inputs[pid.Name] {
+		sample := samples[input]
+		if sample.Err != nil {
+			return Reading{}, fmt.Errorf("virtual sensor %q: input %q: %w", pid.Name, input, sample.Err)
+		}
+
+		values[name] = sample.Reading.Value
+	}
+
+	value, err := pid.Compute(values)
+	if err != nil {
+		return Reading{}, fmt.Errorf("virtual sensor %q: %w", pid.Name, err)
+	}
+
+	return Reading{PID: pid.Name, Value: value, Unit: pid.Unit, Time: time.Now()}, nil
+}
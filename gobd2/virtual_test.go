@@ -0,0 +1,221 @@
+package gobd2_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/janekbaraniewski/gobd2/gobd2"
+	"github.com/janekbaraniewski/gobd2/gobd2/emulator"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPIDRegistry_VirtualSensors(t *testing.T) {
+	t.Parallel()
+
+	registry := gobd2.NewPIDRegistry()
+
+	maf, err := registry.Lookup("MAF")
+	require.NoError(t, err)
+	require.Equal(t, "MAF air flow rate", maf.Name, "PIDs can be looked up by short name")
+
+	economy, err := registry.Lookup("Fuel economy")
+	require.NoError(t, err)
+	require.True(t, economy.Virtual())
+
+	pids, err := registry.Resolve(economy)
+	require.NoError(t, err)
+
+	var names []string
+	for _, pid := range pids {
+		names = append(names, pid.Name)
+	}
+
+	require.Equal(t, []string{"MAF air flow rate", "Fuel rate", "Vehicle speed", "Fuel economy"}, names)
+
+	value, err := economy.Compute(map[string]float64{"FUELRATE": 6, "SPEED": 100})
+	require.NoError(t, err)
+	require.InDelta(t, 6, value, 1e-9)
+
+	_, err = economy.Compute(map[string]float64{"FUELRATE": 6, "SPEED": 0})
+	require.ErrorIs(t, err, gobd2.ErrEvaluation, "the fuel economy is undefined at standstill")
+
+	_, err = economy.Decode([]byte{0x41, 0x0D, 0x00})
+	require.ErrorIs(t, err, gobd2.ErrInvalidPID, "virtual sensors are not read")
+
+	tests := map[string]struct {
+		pids     []gobd2.PID
+		expected error
+	}{
+		"unknown input": {
+			[]gobd2.PID{{Name: "Lambda AFR", Expression: "14.7 * LAMBDA"}},
+			gobd2.ErrUnknownPID,
+		},
+		"cycle": {
+			[]gobd2.PID{{Name: "Ping", Expression: "Pong + 1"}, {Name: "Pong", Expression: "[Ping] - 1"}},
+			gobd2.ErrInvalidPID,
+		},
+		"no input": {
+			[]gobd2.PID{{Name: "Constant", Expression: "42"}},
+			gobd2.ErrInvalidPID,
+		},
+		"read PID with input": {
+			[]gobd2.PID{{Name: "Scaled speed", Command: "010D", Expression: "A * SPEED"}},
+			gobd2.ErrInvalidPID,
+		},
+		"name taken by a short name": {
+			[]gobd2.PID{{Name: "RPM", Expression: "[Engine RPM] / 1000", Unit: "krpm"}},
+			gobd2.ErrDuplicatePID,
+		},
+		"short name taken by a short name": {
+			[]gobd2.PID{{Name: "Engine torque", ShortName: "RPM", Expression: "MAP * 2", Unit: "Nm"}},
+			gobd2.ErrDuplicatePID,
+		},
+		"short name taken by a name": {
+			[]gobd2.PID{{Name: "Speed in mph", ShortName: "Vehicle speed", Expression: "SPEED / 1.609", Unit: "mph"}},
+			gobd2.ErrDuplicatePID,
+		},
+		"short name taken in the same call": {
+			[]gobd2.PID{
+				{Name: "Boost in bar", ShortName: "BOOSTX", Expression: "MAP / 100", Unit: "bar"},
+				{Name: "Boost in psi", ShortName: "BOOSTX", Expression: "MAP / 6.895", Unit: "psi"},
+			},
+			gobd2.ErrDuplicatePID,
+		},
+	}
+
+	for name, tt := range tests {
+		require.ErrorIs(t, registry.Register(tt.pids...), tt.expected, name)
+
+		for _, pid := range registry.PIDs() {
+			require.NotEqual(t, tt.pids[0].Expression, pid.Expression, "%s: nothing is registered", name)
+		}
+	}
+}
+
+func TestReadVirtualSensors(t *testing.T) {
+	t.Parallel()
+
+	sensors, err := gobd2.ReadVirtualSensors(strings.NewReader(`[
+  {"name": "Intake heat soak", "expression": "IAT - AAT", "unit": "°C"},
+  {"name": "Engine power estimate", "shortName": "HP", "expression": "MAF * 1.32", "unit": "hp"}
+]`))
+	require.NoError(t, err)
+	require.Len(t, sensors, 2)
+	require.Equal(t, "HP", sensors[1].ShortName)
+
+	_, err = gobd2.ReadVirtualSensors(strings.NewReader(`[{"name": "Speed", "command": "010D", "expression": "SPEED"}]`))
+	require.ErrorIs(t, err, gobd2.ErrInvalidPID)
+
+	_, err = gobd2.ReadVirtualSensors(strings.NewReader(`[{"name": "Typo", "expresion": "SPEED"}]`))
+	require.ErrorContains(t, err, "unknown field")
+}
+
+func TestSampler(t *testing.T) {
+	t.Parallel()
+
+	engine := &emulator.ECU{
+		Address: 0x7E8,
+		PIDs: map[byte][]byte{
+			0x0B: {0xAA},       // 170 kPa
+			0x0D: {0x00},       // 0 km/h
+			0x10: {0x07, 0xD0}, // 20 g/s
+			0x33: {0x64},       // 100 kPa
+		},
+	}
+	connector := connectVehicle(t, emulator.NewStaticVehicle(gobd2.ProtocolCAN11Bit500, engine))
+	commander := gobd2.NewCommander(connector)
+
+	registry := gobd2.NewPIDRegistry()
+	require.NoError(t, registry.Register(
+		gobd2.PID{Name: "Engine power estimate", ShortName: "HP", Expression: "MAF * 1.32", Unit: "hp"},
+		gobd2.PID{Name: "Coolant margin", Expression: "110 - ECT", Unit: "°C"},
+	))
+
+	var pids []gobd2.PID
+
+	for _, name := range []string{"Boost pressure", "HP", "Fuel economy", "Coolant margin", "Vehicle speed"} {
+		pid, err := registry.Lookup(name)
+		require.NoError(t, err)
+
+		pids = append(pids, pid)
+	}
+
+	sampler, err := gobd2.NewSampler(commander, registry, pids...)
+	require.NoError(t, err)
+	require.Equal(t, pids, sampler.PIDs())
+
+	samples := sampler.Sample()
+	require.Len(t, samples, len(pids))
+
+	for i, expected := range []string{"70 kPa", "26.4 hp", "", "", "0 km/h"} {
+		require.Equal(t, pids[i].Name, samples[i].PID.Name)
+
+		if expected == "" {
+			continue
+		}
+
+		require.NoError(t, samples[i].Err, pids[i].Name)
+		require.Equal(t, pids[i].Name, samples[i].Reading.PID)
+		require.Equal(t, expected, samples[i].Reading.String())
+	}
+
+	require.ErrorIs(t, samples[2].Err, gobd2.ErrEvaluation, "the fuel economy is undefined at standstill")
+	require.ErrorContains(t, samples[3].Err, `input "Coolant temperature"`, "the coolant temperature is not supported")
+}
+
+func TestSampler_MultiPID(t *testing.T) {
+	t.Parallel()
+
+	registry := gobd2.NewPIDRegistry()
+
+	var pids []gobd2.PID
+
+	for _, name := range []string{"RPM", "SPEED", "FUELRATE"} {
+		pid, err := registry.Lookup(name)
+		require.NoError(t, err)
+
+		pids = append(pids, pid)
+	}
+
+	t.Run("batched", func(t *testing.T) {
+		t.Parallel()
+
+		mockConnector := new(MockConnector)
+		mockConnector.On("SendCommand", gobd2.CommandCode("010C0D10")).
+			Return("007\r0: 41 0C 1A F8 10 07\r1: D0 00 00 00 00 00 00", nil).Once()
+		mockConnector.On("SendCommand", gobd2.VehicleSpeedCommand).Return("41 0D 32", nil).Once()
+
+		connector := fingerprintedConnector{MockConnector: mockConnector, capabilities: gobd2.CapabilityMultiPID}
+
+		sampler, err := gobd2.NewSampler(gobd2.NewCommander(connector), registry, pids...)
+		require.NoError(t, err)
+
+		samples := sampler.Sample()
+		for i, expected := range []float64{1726, 50, 20 * 3600 / (14.7 * 740)} {
+			require.NoError(t, samples[i].Err, pids[i].Name)
+			require.InDelta(t, expected, samples[i].Reading.Value, 1e-9, pids[i].Name)
+		}
+
+		mockConnector.AssertExpectations(t)
+	})
+
+	t.Run("adapter without multi-PID support", func(t *testing.T) {
+		t.Parallel()
+
+		mockConnector := new(MockConnector)
+		mockConnector.On("SendCommand", gobd2.EngineRPMCommand).Return("41 0C 1A F8", nil).Once()
+		mockConnector.On("SendCommand", gobd2.VehicleSpeedCommand).Return("41 0D 32", nil).Once()
+		mockConnector.On("SendCommand", gobd2.MAFCommand).Return("41 10 07 D0", nil).Once()
+
+		connector := fingerprintedConnector{MockConnector: mockConnector, capabilities: gobd2.CapabilityVoltage}
+
+		sampler, err := gobd2.NewSampler(gobd2.NewCommander(connector), registry, pids...)
+		require.NoError(t, err)
+
+		for _, sample := range sampler.Sample() {
+			require.NoError(t, sample.Err, sample.PID.Name)
+		}
+
+		mockConnector.AssertExpectations(t)
+	})
+}